@@ -7,13 +7,21 @@ const DRIVER_HEADER_TEMPLATE =
 #define NTSTRSAFE_LIB
 #endif
 
+{{ if eq .Features.lang "cpp" -}}
 extern "C"
 {
+{{ end -}}
 #include <ntddk.h>
 #include <wdm.h>
 #include <ntstrsafe.h>
 #include "..\Common\Common.h"
+{{- if eq .Features.lang "cpp" }}
 }
+{{- end }}
+{{- if eq .Features.cppruntime "true" }}
+
+#include "KernelCpp.h"
+{{- end }}
 
 #ifndef _FN_
 #define _FN_	__FUNCTION__
@@ -26,8 +34,7 @@ extern "C"
 #define DEVICE_NAME		L"\\Device\\$PROJECTNAME_SYS$"
 #define DOS_DEVICE_NAME	L"\\DosDevices\\$PROJECTNAME_SYS$"
 
-#define TAG_NAME        'TSET'
-
+` + POOL_TAG_TEMPLATE + `
 
 void
 UnloadRoutine(
@@ -47,7 +54,27 @@ DeviceIoControlRoutine(
 );
 `
 
-const DRIVER_CPP_TEMPLATE =
+// POOL_TAG_TEMPLATE defines the pool tag of the driver.
+const POOL_TAG_TEMPLATE =
+`#define TAG_NAME        '{{ poolTag (or .Vars.PoolTag .ProjectName) }}'
+`
+
+// POOL_HEADER_TEMPLATE adds the allocation macros the alloc feature picks to
+// the pool tag. Models whose driver allocates nothing are NoAlloc and leave
+// them out.
+const POOL_HEADER_TEMPLATE = POOL_TAG_TEMPLATE +
+`{{ if eq .Features.alloc "pool2" }}
+#define ALLOC_NONPAGED(size)	ExAllocatePool2(POOL_FLAG_NON_PAGED, (size), TAG_NAME)
+{{- else }}
+#define ALLOC_NONPAGED(size)	ExAllocatePoolWithTag(NonPagedPoolNx, (size), TAG_NAME)
+{{- end }}
+#define FREE_POOL(p)			ExFreePoolWithTag((p), TAG_NAME)
+`
+
+// Driver sources are emitted as .c or .cpp as the lang feature asks, so the
+// source templates of every model, and the sections they share, stick to C
+// that also compiles as C++.
+const DRIVER_SOURCE_TEMPLATE =
 `#include "$PROJECTNAME_SYS$.h"
 
 
@@ -58,14 +85,15 @@ DriverEntry(
 	IN	PUNICODE_STRING		pRegistryPath
 )
 {
-	UNREFERENCED_PARAMETER(pRegistryPath);
-
-	KdPrint(("[%s:%d]\n", _FN_, _LN_));
-
 	NTSTATUS status = STATUS_UNSUCCESSFUL;
 
 	PDEVICE_OBJECT pDeviceObject = NULL;
 	UNICODE_STRING symbolicLinkName = { 0 };
+	ULONG i = 0;
+
+	UNREFERENCED_PARAMETER(pRegistryPath);
+
+	KdPrint(("[%s:%d]\n", _FN_, _LN_));
 
 	do
 	{		
@@ -83,7 +111,7 @@ DriverEntry(
 			pDriverObject,
 			0,
 			&deviceName,
-			FILE_DEVICE_UNKNOWN,
+			{{ .Vars.DeviceType }},
 			0,
 			TRUE,
 			&pDeviceObject
@@ -93,7 +121,7 @@ DriverEntry(
 			break;
 		}
 
-		for (int i = 0; i <= IRP_MJ_MAXIMUM_FUNCTION; ++i)
+		for (i = 0; i <= IRP_MJ_MAXIMUM_FUNCTION; ++i)
 		{
 			pDriverObject->MajorFunction[i] = PassRoutine;
 		}
@@ -103,7 +131,7 @@ DriverEntry(
 
 		status = STATUS_SUCCESS;
 
-	} while (false);
+	} while (FALSE);
 
 	if (!NT_SUCCESS(status))
 	{
@@ -128,9 +156,10 @@ UnloadRoutine(
 	IN	PDRIVER_OBJECT		pDriverObject
 )
 {
+	UNICODE_STRING symbolicLinkName;
+
 	KdPrint(("[%s:%d]\n", _FN_, _LN_));
 
-	UNICODE_STRING symbolicLinkName;
 	RtlInitUnicodeString(&symbolicLinkName, DOS_DEVICE_NAME);
 	IoDeleteSymbolicLink(&symbolicLinkName);
 
@@ -142,24 +171,12 @@ UnloadRoutine(
 
 	return;
 }
+` + DRIVER_DISPATCH_TEMPLATE
 
-
-NTSTATUS
-PassRoutine(
-	IN	PDEVICE_OBJECT		pDeviceObject,
-	IN	PIRP				pIrp
-)
-{
-	UNREFERENCED_PARAMETER(pDeviceObject);
-
-	pIrp->IoStatus.Status = STATUS_SUCCESS;
-	pIrp->IoStatus.Information = 0;
-
-	IoCompleteRequest(pIrp, IO_NO_INCREMENT);
-
-	return STATUS_SUCCESS;
-}
-
+// DRIVER_DISPATCH_TEMPLATE completes every IRP and handles the IOCTLs of the
+// common header, for drivers with a control device.
+const DRIVER_DISPATCH_TEMPLATE = PASS_ROUTINE_TEMPLATE +
+`
 
 NTSTATUS
 DeviceIoControlRoutine(
@@ -167,8 +184,6 @@ DeviceIoControlRoutine(
 	IN	PIRP				pIrp
 )
 {
-	UNREFERENCED_PARAMETER(pDeviceObject);
-
 	NTSTATUS status = STATUS_UNSUCCESSFUL;
 	ULONG_PTR information = 0;
 
@@ -179,12 +194,14 @@ DeviceIoControlRoutine(
 	PVOID pInBuffer = pIrp->AssociatedIrp.SystemBuffer;
 	PVOID pOutBuffer = pIrp->AssociatedIrp.SystemBuffer;
 
+	UNREFERENCED_PARAMETER(pDeviceObject);
+
 	switch (ioCtlCode)
 	{
-	case IOCTL_MYDRIVER_1:
+	case IOCTL_{{ upperSnake .ProjectName }}_1:
 	{
-		KdPrint(("[%s:%d] IOCTL_MYDRIVER_1. inSize : 0x%X\n", _FN_, _LN_, inSize));
-		if (pInBuffer == NULL || inSize != sizeof(MYDRIVER_DATA_1))
+		KdPrint(("[%s:%d] IOCTL_{{ upperSnake .ProjectName }}_1. inSize : 0x%X\n", _FN_, _LN_, inSize));
+		if (pInBuffer == NULL || inSize != sizeof({{ upperSnake .ProjectName }}_DATA_1))
 		{
 			status = STATUS_INVALID_PARAMETER;
 			break;
@@ -207,6 +224,141 @@ DeviceIoControlRoutine(
 }
 `
 
+// PASS_ROUTINE_TEMPLATE completes every IRP a control device gets.
+const PASS_ROUTINE_TEMPLATE =
+`
+
+NTSTATUS
+PassRoutine(
+	IN	PDEVICE_OBJECT		pDeviceObject,
+	IN	PIRP				pIrp
+)
+{
+	UNREFERENCED_PARAMETER(pDeviceObject);
+
+	pIrp->IoStatus.Status = STATUS_SUCCESS;
+	pIrp->IoStatus.Information = 0;
+
+	IoCompleteRequest(pIrp, IO_NO_INCREMENT);
+
+	return STATUS_SUCCESS;
+}
+`
+
+// CONTROL_DEVICE_HEADER_TEMPLATE declares DriverEntry, UnloadRoutine and the
+// routines of CONTROL_DEVICE_SOURCE_TEMPLATE. Models including it link
+// wdmsec.lib, see wdmsecSettings, and provide DeviceIoControlRoutine.
+const CONTROL_DEVICE_HEADER_TEMPLATE =
+`
+#define DEVICE_NAME		L"\\Device\\$PROJECTNAME_SYS$"
+#define DOS_DEVICE_NAME	L"\\DosDevices\\$PROJECTNAME_SYS$"
+
+
+EXTERN_C DRIVER_INITIALIZE DriverEntry;
+
+void
+UnloadRoutine(
+	IN	PDRIVER_OBJECT		pDriverObject
+);
+
+NTSTATUS
+CreateControlDevice(
+	IN	PDRIVER_OBJECT		pDriverObject
+);
+
+void
+DeleteControlDevice(
+	IN	PDRIVER_OBJECT		pDriverObject
+);
+
+NTSTATUS
+PassRoutine(
+	IN	PDEVICE_OBJECT		pDeviceObject,
+	IN	PIRP				pIrp
+);
+
+NTSTATUS
+DeviceIoControlRoutine(
+	IN	PDEVICE_OBJECT		pDeviceObject,
+	IN	PIRP				pIrp
+);
+`
+
+// CONTROL_DEVICE_SOURCE_TEMPLATE creates the device clients open as
+// \\.\<name>, for models whose driver is managed through IOCTLs. Only the
+// system and administrators may open it.
+const CONTROL_DEVICE_SOURCE_TEMPLATE =
+`
+
+// CreateControlDevice routes IRP_MJ_DEVICE_CONTROL to DeviceIoControlRoutine
+// and completes every other IRP in PassRoutine.
+NTSTATUS
+CreateControlDevice(
+	IN	PDRIVER_OBJECT		pDriverObject
+)
+{
+	NTSTATUS status = STATUS_UNSUCCESSFUL;
+
+	PDEVICE_OBJECT pDeviceObject = NULL;
+	UNICODE_STRING deviceName = RTL_CONSTANT_STRING(DEVICE_NAME);
+	UNICODE_STRING symbolicLinkName = RTL_CONSTANT_STRING(DOS_DEVICE_NAME);
+	ULONG i = 0;
+
+	status = IoCreateDeviceSecure(
+		pDriverObject,
+		0,
+		&deviceName,
+		{{ .Vars.DeviceType }},
+		FILE_DEVICE_SECURE_OPEN,
+		FALSE,
+		&SDDL_DEVOBJ_SYS_ALL_ADM_ALL,
+		NULL,
+		&pDeviceObject
+	);
+	if (!NT_SUCCESS(status))
+	{
+		return status;
+	}
+
+	status = IoCreateSymbolicLink(&symbolicLinkName, &deviceName);
+	if (!NT_SUCCESS(status))
+	{
+		IoDeleteDevice(pDeviceObject);
+		return status;
+	}
+
+	for (i = 0; i <= IRP_MJ_MAXIMUM_FUNCTION; ++i)
+	{
+		pDriverObject->MajorFunction[i] = PassRoutine;
+	}
+
+	pDriverObject->MajorFunction[IRP_MJ_DEVICE_CONTROL] = DeviceIoControlRoutine;
+
+	return STATUS_SUCCESS;
+}
+
+
+// DeleteControlDevice does nothing when CreateControlDevice failed, so error
+// paths can call it unconditionally.
+void
+DeleteControlDevice(
+	IN	PDRIVER_OBJECT		pDriverObject
+)
+{
+	UNICODE_STRING symbolicLinkName = RTL_CONSTANT_STRING(DOS_DEVICE_NAME);
+
+	if (pDriverObject->DeviceObject == NULL)
+	{
+		return;
+	}
+
+	IoDeleteSymbolicLink(&symbolicLinkName);
+
+	IoDeleteDevice(pDriverObject->DeviceObject);
+	pDriverObject->DeviceObject = NULL;
+}
+` + PASS_ROUTINE_TEMPLATE
+
 const EXE_CPP_TEMPLATE = 
 `#include <iostream>
 #include <Windows.h>
@@ -225,10 +377,10 @@ int main()
 		}
 
 		DWORD retSize = 0;
-		MYDRIVER_DATA_1 ioctlData = { 0 };
+		{{ upperSnake .ProjectName }}_DATA_1 ioctlData = { 0 };
 		ioctlData.totalSize = sizeof(ioctlData);
 
-		if (!DeviceIoControl(deviceHandle, IOCTL_MYDRIVER_1, (LPVOID)&ioctlData, sizeof(ioctlData), nullptr, 0, &retSize, nullptr))
+		if (!DeviceIoControl(deviceHandle, IOCTL_{{ upperSnake .ProjectName }}_1, (LPVOID)&ioctlData, sizeof(ioctlData), nullptr, 0, &retSize, nullptr))
 		{
 			break;
 		}
@@ -248,15 +400,261 @@ int main()
 const COMMON_HEADER_TEMPLATE = 
 `#pragma once
 
-#define IOCTL_MYDRIVER_1		CTL_CODE(FILE_DEVICE_UNKNOWN, 0x800, METHOD_BUFFERED, FILE_ANY_ACCESS)
+#define IOCTL_{{ upperSnake .ProjectName }}_1		CTL_CODE({{ .Vars.DeviceType }}, {{ ctlFunction 0 }}, METHOD_BUFFERED, FILE_ANY_ACCESS)
 
 #pragma pack (push, 1)
 
-typedef struct _MYDRIVER_DATA_1
+typedef struct _{{ upperSnake .ProjectName }}_DATA_1
 {
     ULONG               totalSize;
 	   
-} MYDRIVER_DATA_1, *PMYDRIVER_DATA_1;
+} {{ upperSnake .ProjectName }}_DATA_1, *P{{ upperSnake .ProjectName }}_DATA_1;
 
 #pragma pack (pop)
-`
\ No newline at end of file
+`
+const KERNEL_CPP_HEADER_TEMPLATE =
+`#pragma once
+
+//
+// Kernel C++ support for $PROJECTNAME_SYS$.
+//
+// There is no C++ runtime in the kernel: no exceptions, no RTTI and no global
+// objects with constructors. Function-local statics are initialized without
+// locks (/Zc:threadSafeInit-), so do not rely on them from several threads.
+//
+
+#include <ntddk.h>
+
+#define KCPP_POOL_TAG	'{{ poolTag (or .Vars.PoolTag .ProjectName) }}'
+
+//
+// Allocations are zeroed and return nullptr on failure:
+//   MyObject* p = new (NonPagedPoolNx) MyObject();
+//   if (p == nullptr) ...
+//   delete p;
+//
+void* __cdecl operator new(size_t size, POOL_TYPE poolType) noexcept;
+void* __cdecl operator new[](size_t size, POOL_TYPE poolType) noexcept;
+void __cdecl operator delete(void* p) noexcept;
+void __cdecl operator delete(void* p, size_t size) noexcept;
+void __cdecl operator delete[](void* p) noexcept;
+void __cdecl operator delete[](void* p, size_t size) noexcept;
+
+
+class KSpinLockGuard
+{
+public:
+	explicit KSpinLockGuard(PKSPIN_LOCK pLock) : m_pLock(pLock)
+	{
+		KeAcquireSpinLock(m_pLock, &m_oldIrql);
+	}
+
+	~KSpinLockGuard()
+	{
+		KeReleaseSpinLock(m_pLock, m_oldIrql);
+	}
+
+	KSpinLockGuard(const KSpinLockGuard&) = delete;
+	KSpinLockGuard& operator=(const KSpinLockGuard&) = delete;
+
+private:
+	PKSPIN_LOCK m_pLock;
+	KIRQL m_oldIrql;
+};
+
+
+class KFastMutexGuard
+{
+public:
+	explicit KFastMutexGuard(PFAST_MUTEX pMutex) : m_pMutex(pMutex)
+	{
+		ExAcquireFastMutex(m_pMutex);
+	}
+
+	~KFastMutexGuard()
+	{
+		ExReleaseFastMutex(m_pMutex);
+	}
+
+	KFastMutexGuard(const KFastMutexGuard&) = delete;
+	KFastMutexGuard& operator=(const KFastMutexGuard&) = delete;
+
+private:
+	PFAST_MUTEX m_pMutex;
+};
+
+
+// KUnicodeString owns a pool buffer for a UNICODE_STRING.
+class KUnicodeString
+{
+public:
+	KUnicodeString()
+	{
+		RtlZeroMemory(&m_string, sizeof(m_string));
+	}
+
+	~KUnicodeString()
+	{
+		Free();
+	}
+
+	KUnicodeString(const KUnicodeString&) = delete;
+	KUnicodeString& operator=(const KUnicodeString&) = delete;
+
+	NTSTATUS Allocate(USHORT maximumLength, POOL_TYPE poolType = PagedPool)
+	{
+		Free();
+
+		m_string.Buffer = static_cast<PWCH>(operator new[](maximumLength, poolType));
+		if (m_string.Buffer == nullptr)
+		{
+			return STATUS_INSUFFICIENT_RESOURCES;
+		}
+
+		m_string.Length = 0;
+		m_string.MaximumLength = maximumLength;
+		return STATUS_SUCCESS;
+	}
+
+	NTSTATUS Copy(PCUNICODE_STRING pSource, POOL_TYPE poolType = PagedPool)
+	{
+		NTSTATUS status = Allocate(pSource->Length, poolType);
+		if (NT_SUCCESS(status))
+		{
+			RtlCopyUnicodeString(&m_string, pSource);
+		}
+		return status;
+	}
+
+	void Free()
+	{
+		if (m_string.Buffer != nullptr)
+		{
+			operator delete[](m_string.Buffer);
+		}
+		RtlZeroMemory(&m_string, sizeof(m_string));
+	}
+
+	PUNICODE_STRING Get()
+	{
+		return &m_string;
+	}
+
+private:
+	UNICODE_STRING m_string;
+};
+
+
+// KObjectRef dereferences the object it holds, e.g. one returned by
+// ObReferenceObjectByHandle or PsLookupProcessByProcessId.
+template <typename T>
+class KObjectRef
+{
+public:
+	KObjectRef() : m_pObject(nullptr)
+	{
+	}
+
+	explicit KObjectRef(T* pObject) : m_pObject(pObject)
+	{
+	}
+
+	~KObjectRef()
+	{
+		Reset();
+	}
+
+	KObjectRef(const KObjectRef&) = delete;
+	KObjectRef& operator=(const KObjectRef&) = delete;
+
+	void Reset(T* pObject = nullptr)
+	{
+		if (m_pObject != nullptr)
+		{
+			ObDereferenceObject(m_pObject);
+		}
+		m_pObject = pObject;
+	}
+
+	T* Detach()
+	{
+		T* pObject = m_pObject;
+		m_pObject = nullptr;
+		return pObject;
+	}
+
+	T* Get() const
+	{
+		return m_pObject;
+	}
+
+	T** operator&()
+	{
+		Reset();
+		return &m_pObject;
+	}
+
+private:
+	T* m_pObject;
+};
+`
+
+const KERNEL_CPP_SOURCE_TEMPLATE =
+`#include "KernelCpp.h"
+
+
+static void* KcppAllocate(size_t size, POOL_TYPE poolType)
+{
+{{- if eq .Features.alloc "pool2" }}
+	return ExAllocatePool2(poolType == PagedPool ? POOL_FLAG_PAGED : POOL_FLAG_NON_PAGED, size, KCPP_POOL_TAG);
+{{- else }}
+	void* p = ExAllocatePoolWithTag(poolType, size, KCPP_POOL_TAG);
+	if (p != nullptr)
+	{
+		RtlZeroMemory(p, size);
+	}
+	return p;
+{{- end }}
+}
+
+
+void* __cdecl operator new(size_t size, POOL_TYPE poolType) noexcept
+{
+	return KcppAllocate(size, poolType);
+}
+
+
+void* __cdecl operator new[](size_t size, POOL_TYPE poolType) noexcept
+{
+	return KcppAllocate(size, poolType);
+}
+
+
+void __cdecl operator delete(void* p) noexcept
+{
+	if (p != nullptr)
+	{
+		ExFreePoolWithTag(p, KCPP_POOL_TAG);
+	}
+}
+
+
+void __cdecl operator delete(void* p, size_t size) noexcept
+{
+	UNREFERENCED_PARAMETER(size);
+	operator delete(p);
+}
+
+
+void __cdecl operator delete[](void* p) noexcept
+{
+	operator delete(p);
+}
+
+
+void __cdecl operator delete[](void* p, size_t size) noexcept
+{
+	UNREFERENCED_PARAMETER(size);
+	operator delete(p);
+}
+`
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v3"
+)
+
+// generatorConfig is the optional -config file. Command-line values always
+// override what it says.
+//
+//	targetOS: win10-1809
+//	kmdfVersion: "1.25"
+//	vars:
+//	  Company: Contoso
+//	features:
+//	  client: none
+//	configurations:
+//	  - name: Release-Signed
+//	    inherits: Release
+//	    driver:
+//	      properties:
+//	        SignMode: ProductionSign
+//	build:
+//	  profile: default
+//	  driver:
+//	    profile: strict
+//	output:
+//	  outDir: bin\$(Platform)\$(Configuration)
+//	  driverTargetName: contoso
+type generatorConfig struct {
+	TargetOS    string `yaml:"targetOS"`
+	KMDFVersion string `yaml:"kmdfVersion"`
+	UMDFVersion string `yaml:"umdfVersion"`
+
+	Vars     map[string]string `yaml:"vars"`
+	Features map[string]string `yaml:"features"`
+
+	Configurations []customConfiguration `yaml:"configurations"`
+	Build          buildSettings         `yaml:"build"`
+	Output         outputLayout          `yaml:"output"`
+}
+
+func loadConfig(filePath string) (*generatorConfig, error) {
+	config := &generatorConfig{}
+	if filePath == "" {
+		return config, nil
+	}
+
+	contents, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(contents))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(config); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("%s: %v", filePath, err)
+	}
+
+	return config, nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// configOverrides changes MSBuild settings of a configuration. Properties
+// replace a Configuration or plain PropertyGroup value of the same name, or
+// are added to the plain PropertyGroup.
+type configOverrides struct {
+	Defines    []string          `yaml:"defines"`
+	Properties map[string]string `yaml:"properties"`
+	Compile    map[string]string `yaml:"compile"`
+	Link       map[string]string `yaml:"link"`
+}
+
+// customConfiguration is an extra build configuration from the -config file.
+// The top-level overrides apply to every project; driver and app are applied
+// on top of them for the kernel projects or the client only.
+type customConfiguration struct {
+	Name            string `yaml:"name"`
+	Inherits        string `yaml:"inherits"`
+	configOverrides `yaml:",inline"`
+	Driver          configOverrides `yaml:"driver"`
+	App             configOverrides `yaml:"app"`
+}
+
+func mergeOverrides(base, extra configOverrides) configOverrides {
+	merged := configOverrides{
+		Defines:    append(append([]string(nil), base.Defines...), extra.Defines...),
+		Properties: map[string]string{},
+		Compile:    map[string]string{},
+		Link:       map[string]string{},
+	}
+
+	for _, pair := range []struct{ to, base, extra map[string]string }{
+		{merged.Properties, base.Properties, extra.Properties},
+		{merged.Compile, base.Compile, extra.Compile},
+		{merged.Link, base.Link, extra.Link},
+	} {
+		for name, value := range pair.base {
+			pair.to[name] = value
+		}
+		for name, value := range pair.extra {
+			pair.to[name] = value
+		}
+	}
+
+	return merged
+}
+
+// buildConfigurations returns Debug, Release and the custom configurations.
+func buildConfigurations(custom []customConfiguration) ([]buildConfiguration, error) {
+	result := []buildConfiguration{
+		{Name: "Debug", Debug: true},
+		{Name: "Release", Debug: false},
+	}
+
+	seen := map[string]bool{}
+	for _, config := range result {
+		seen[strings.ToLower(config.Name)] = true
+	}
+
+	for _, config := range custom {
+		if config.Name == "" || strings.ContainsAny(config.Name, `|'"<>&=.`) {
+			return nil, fmt.Errorf("invalid configuration name %q", config.Name)
+		}
+		if seen[strings.ToLower(config.Name)] {
+			return nil, fmt.Errorf("configuration %s defined twice", config.Name)
+		}
+		seen[strings.ToLower(config.Name)] = true
+
+		built := buildConfiguration{
+			Name:   config.Name,
+			Driver: mergeOverrides(config.configOverrides, config.Driver),
+			App:    mergeOverrides(config.configOverrides, config.App),
+		}
+
+		switch config.Inherits {
+		case "Debug":
+			built.Debug = true
+		case "Release":
+			built.Debug = false
+		default:
+			return nil, fmt.Errorf("configuration %s must inherit from Debug or Release, not %q", config.Name, config.Inherits)
+		}
+
+		result = append(result, built)
+	}
+
+	return result, nil
+}
+
+// overrideProperties replaces the values of properties named in overrides
+// and returns the overrides it did not find.
+func overrideProperties(properties []msbuildProperty, overrides map[string]string) ([]msbuildProperty, map[string]string) {
+	rest := map[string]string{}
+	for name, value := range overrides {
+		rest[name] = value
+	}
+
+	result := append([]msbuildProperty(nil), properties...)
+	for i, property := range result {
+		if value, ok := rest[property.name]; ok {
+			result[i].value = value
+			delete(rest, property.name)
+		}
+	}
+
+	return result, rest
+}
+
+// appendProperties adds properties in name order so output stays stable.
+func appendProperties(properties []msbuildProperty, extra map[string]string) []msbuildProperty {
+	names := make([]string, 0, len(extra))
+	for name := range extra {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		properties = append(properties, msbuildProperty{name, extra[name]})
+	}
+	return properties
+}
+
+func applyOverrides(settings projectSettings, overrides configOverrides) projectSettings {
+	var rest map[string]string
+
+	settings.configuration, rest = overrideProperties(settings.configuration, overrides.Properties)
+	settings.properties, rest = overrideProperties(settings.properties, rest)
+	settings.properties = appendProperties(settings.properties, rest)
+
+	compile := overrides.Compile
+	if len(overrides.Defines) > 0 {
+		compile = map[string]string{}
+		for name, value := range overrides.Compile {
+			compile[name] = value
+		}
+
+		defines := strings.Join(overrides.Defines, ";") + ";%(PreprocessorDefinitions)"
+		for _, property := range settings.clCompile {
+			if property.name == "PreprocessorDefinitions" {
+				defines = strings.Join(overrides.Defines, ";") + ";" + property.value
+			}
+		}
+		if _, ok := compile["PreprocessorDefinitions"]; !ok {
+			compile["PreprocessorDefinitions"] = defines
+		}
+	}
+
+	settings.clCompile, rest = overrideProperties(settings.clCompile, compile)
+	settings.clCompile = appendProperties(settings.clCompile, rest)
+
+	settings.link, rest = overrideProperties(settings.link, overrides.Link)
+	settings.link = appendProperties(settings.link, rest)
+
+	return settings
+}
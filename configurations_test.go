@@ -0,0 +1,104 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuildConfigurations(t *testing.T) {
+	built, err := buildConfigurations([]customConfiguration{
+		{Name: "Release-Signed", Inherits: "Release", Driver: configOverrides{Properties: map[string]string{"SignMode": "ProductionSign"}}},
+		{Name: "Checked", Inherits: "Debug", configOverrides: configOverrides{Defines: []string{"CHECKED"}}, App: configOverrides{Defines: []string{"CHECKED_APP"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, config := range built {
+		names = append(names, config.Name)
+	}
+	if strings.Join(names, ",") != "Debug,Release,Release-Signed,Checked" {
+		t.Fatalf("configurations %q, want Debug, Release and the custom ones in order", names)
+	}
+
+	signed, checked := built[2], built[3]
+	if signed.Debug || !checked.Debug {
+		t.Errorf("Release-Signed Debug=%v, Checked Debug=%v, want them to follow what they inherit", signed.Debug, checked.Debug)
+	}
+	if signed.Driver.Properties["SignMode"] != "ProductionSign" || signed.App.Properties["SignMode"] != "" {
+		t.Errorf("driver overrides leak into the app or get lost: driver %v, app %v", signed.Driver.Properties, signed.App.Properties)
+	}
+	if strings.Join(checked.Driver.Defines, ";") != "CHECKED" || strings.Join(checked.App.Defines, ";") != "CHECKED;CHECKED_APP" {
+		t.Errorf("top-level defines are not under the project ones: driver %q, app %q", checked.Driver.Defines, checked.App.Defines)
+	}
+
+	tests := []struct {
+		name   string
+		custom []customConfiguration
+		want   string
+	}{
+		{"no name", []customConfiguration{{Inherits: "Debug"}}, `invalid configuration name ""`},
+		{"bad name", []customConfiguration{{Name: "Release|Signed", Inherits: "Release"}}, `invalid configuration name "Release|Signed"`},
+		{"builtin name", []customConfiguration{{Name: "debug", Inherits: "Debug"}}, "configuration debug defined twice"},
+		{"twice", []customConfiguration{{Name: "Checked", Inherits: "Debug"}, {Name: "CHECKED", Inherits: "Debug"}}, "configuration CHECKED defined twice"},
+		{"no parent", []customConfiguration{{Name: "Checked"}}, `must inherit from Debug or Release, not ""`},
+		{"custom parent", []customConfiguration{{Name: "A", Inherits: "Release"}, {Name: "B", Inherits: "A"}}, `must inherit from Debug or Release, not "A"`},
+	}
+
+	for _, test := range tests {
+		if _, err := buildConfigurations(test.custom); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: error %v, want one mentioning %q", test.name, err, test.want)
+		}
+	}
+}
+
+func TestCustomConfigurationSettings(t *testing.T) {
+	built, err := buildConfigurations([]customConfiguration{
+		{
+			Name:            "Release-Signed",
+			Inherits:        "Release",
+			configOverrides: configOverrides{Defines: []string{"SIGNED"}},
+			Driver:          configOverrides{Properties: map[string]string{"SignMode": "ProductionSign"}},
+			App:             configOverrides{Link: map[string]string{"GenerateDebugInformation": "false"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := built[2]
+
+	driver := driverSettings(signed, "x64")
+	if value := propertyValue(driver.configuration, "UseDebugLibraries"); value != "false" {
+		t.Errorf("driver UseDebugLibraries = %q, want the Release value", value)
+	}
+	if value := propertyValue(driver.properties, "SignMode"); value != "ProductionSign" {
+		t.Errorf("driver SignMode = %q, want ProductionSign", value)
+	}
+	if value := propertyValue(driver.clCompile, "PreprocessorDefinitions"); !strings.HasPrefix(value, "SIGNED;") {
+		t.Errorf("driver PreprocessorDefinitions = %q, want SIGNED first", value)
+	}
+
+	app := appSettings(signed, "x64")
+	if value := propertyValue(app.properties, "SignMode"); value != "" {
+		t.Errorf("app SignMode = %q, want none", value)
+	}
+	if value := propertyValue(app.clCompile, "RuntimeLibrary"); value != "MultiThreaded" {
+		t.Errorf("app RuntimeLibrary = %q, want the Release value", value)
+	}
+	if value := propertyValue(app.link, "GenerateDebugInformation"); value != "false" {
+		t.Errorf("app GenerateDebugInformation = %q, want the override", value)
+	}
+	if value := propertyValue(app.clCompile, "PreprocessorDefinitions"); !strings.HasPrefix(value, "SIGNED;NDEBUG;") {
+		t.Errorf("app PreprocessorDefinitions = %q, want SIGNED before the Release defines", value)
+	}
+}
+
+func propertyValue(properties []msbuildProperty, name string) string {
+	for _, property := range properties {
+		if property.name == name {
+			return property.value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// packFeature is a feature flag declared by a template pack. Files and
+// template sections can depend on its value. MinOS maps values to the oldest
+// -target-os they work on, Requires maps values to a condition on the other
+// features that must hold when they are selected.
+type packFeature struct {
+	Name        string            `yaml:"name"`
+	Default     string            `yaml:"default"`
+	Values      []string          `yaml:"values"`
+	Description string            `yaml:"description"`
+	MinOS       map[string]string `yaml:"minOS"`
+	Requires    map[string]string `yaml:"requires"`
+}
+
+func (f *packFeature) allows(value string) bool {
+	if len(f.Values) == 0 {
+		return true
+	}
+	for _, allowed := range f.Values {
+		if allowed == value {
+			return true
+		}
+	}
+	return false
+}
+
+func findFeature(pack *templatePack, name string) *packFeature {
+	for i := range pack.Features {
+		if pack.Features[i].Name == name {
+			return &pack.Features[i]
+		}
+	}
+	return nil
+}
+
+// checkPackFeatures validates the feature declarations and every file
+// condition that refers to them.
+func checkPackFeatures(pack *templatePack) error {
+	seen := map[string]bool{}
+	for _, feature := range pack.Features {
+		if feature.Name == "" {
+			return fmt.Errorf("pack %s: feature without a name", pack.Name)
+		}
+		if seen[feature.Name] {
+			return fmt.Errorf("pack %s: feature %s declared twice", pack.Name, feature.Name)
+		}
+		seen[feature.Name] = true
+
+		if !feature.allows(feature.Default) {
+			return fmt.Errorf("pack %s: feature %s default %q is not one of %s", pack.Name, feature.Name, feature.Default, strings.Join(feature.Values, "|"))
+		}
+
+		for value, minOS := range feature.MinOS {
+			if !feature.allows(value) {
+				return fmt.Errorf("pack %s: feature %s minOS value %q is not one of %s", pack.Name, feature.Name, value, strings.Join(feature.Values, "|"))
+			}
+			if _, err := findTargetOS(minOS); err != nil {
+				return fmt.Errorf("pack %s: feature %s: %v", pack.Name, feature.Name, err)
+			}
+		}
+
+		for value, when := range feature.Requires {
+			if !feature.allows(value) {
+				return fmt.Errorf("pack %s: feature %s requires value %q is not one of %s", pack.Name, feature.Name, value, strings.Join(feature.Values, "|"))
+			}
+			if _, err := parseCondition(pack, when); err != nil {
+				return fmt.Errorf("pack %s: feature %s: %v", pack.Name, feature.Name, err)
+			}
+		}
+	}
+
+	for _, file := range pack.Files {
+		if _, err := parseCondition(pack, file.When); err != nil {
+			return fmt.Errorf("pack %s: %s: %v", pack.Name, file.Path, err)
+		}
+	}
+
+	for i, settings := range pack.Settings {
+		switch settings.Project {
+		case SETTINGS_PROJECT_DRIVER, SETTINGS_PROJECT_APP, SETTINGS_PROJECT_LIBRARY:
+		default:
+			return fmt.Errorf("pack %s: settings %d: project must be %s, %s or %s, not %q", pack.Name, i, SETTINGS_PROJECT_DRIVER, SETTINGS_PROJECT_APP, SETTINGS_PROJECT_LIBRARY, settings.Project)
+		}
+		if _, err := parseCondition(pack, settings.When); err != nil {
+			return fmt.Errorf("pack %s: settings %d: %v", pack.Name, i, err)
+		}
+	}
+
+	return nil
+}
+
+// resolveFeatures merges config and -feature values (the latter win) and
+// fills in defaults.
+func resolveFeatures(pack *templatePack, configFeatures, cliFeatures map[string]string) (map[string]string, error) {
+	values := map[string]string{}
+	for name, value := range configFeatures {
+		values[name] = value
+	}
+	for name, value := range cliFeatures {
+		values[name] = value
+	}
+
+	for name, value := range values {
+		feature := findFeature(pack, name)
+		if feature == nil {
+			return nil, fmt.Errorf("pack %s does not declare feature %s", pack.Name, name)
+		}
+		if !feature.allows(value) {
+			return nil, fmt.Errorf("feature %s: %q is not one of %s", name, value, strings.Join(feature.Values, "|"))
+		}
+	}
+
+	result := map[string]string{}
+	for _, feature := range pack.Features {
+		if value, ok := values[feature.Name]; ok {
+			result[feature.Name] = value
+		} else {
+			result[feature.Name] = feature.Default
+		}
+	}
+
+	for _, feature := range pack.Features {
+		value := result[feature.Name]
+		when, ok := feature.Requires[value]
+		if !ok {
+			continue
+		}
+		if matched, _ := matchCondition(pack, when, result); !matched {
+			return nil, fmt.Errorf("feature %s=%s needs %s", feature.Name, value, when)
+		}
+	}
+
+	return result, nil
+}
+
+// requiredFeatures returns feature values that satisfy what name=value
+// requires, for rendering it without picking the rest by hand.
+func requiredFeatures(pack *templatePack, name, value string) map[string]string {
+	values := map[string]string{name: value}
+
+	feature := findFeature(pack, name)
+	terms, _ := parseCondition(pack, feature.Requires[value])
+	for _, term := range terms {
+		if term.negate {
+			continue
+		}
+		values[term.name] = term.values[0]
+	}
+
+	return values
+}
+
+type conditionTerm struct {
+	name   string
+	negate bool
+	values []string
+}
+
+// parseCondition parses a file's "when" expression: comma-separated terms
+// that must all hold, each "name=a|b" or "name!=a|b".
+func parseCondition(pack *templatePack, when string) ([]conditionTerm, error) {
+	var terms []conditionTerm
+	if strings.TrimSpace(when) == "" {
+		return terms, nil
+	}
+
+	for _, expr := range strings.Split(when, ",") {
+		expr = strings.TrimSpace(expr)
+
+		term := conditionTerm{}
+		pos := strings.Index(expr, "=")
+		if pos <= 0 {
+			return nil, fmt.Errorf("bad condition %q", expr)
+		}
+		term.name = expr[:pos]
+		if strings.HasSuffix(term.name, "!") {
+			term.negate = true
+			term.name = term.name[:len(term.name)-1]
+		}
+		term.name = strings.TrimSpace(term.name)
+		term.values = strings.Split(strings.TrimSpace(expr[pos+1:]), "|")
+
+		feature := findFeature(pack, term.name)
+		if feature == nil {
+			return nil, fmt.Errorf("condition %q uses undeclared feature %s", expr, term.name)
+		}
+		for _, value := range term.values {
+			if !feature.allows(value) {
+				return nil, fmt.Errorf("condition %q: %q is not one of %s", expr, value, strings.Join(feature.Values, "|"))
+			}
+		}
+
+		terms = append(terms, term)
+	}
+
+	return terms, nil
+}
+
+func matchCondition(pack *templatePack, when string, features map[string]string) (bool, error) {
+	terms, err := parseCondition(pack, when)
+	if err != nil {
+		return false, err
+	}
+
+	for _, term := range terms {
+		matched := false
+		for _, value := range term.values {
+			if features[term.name] == value {
+				matched = true
+				break
+			}
+		}
+		if matched == term.negate {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+var conditionTestPack = &templatePack{
+	Name: "test",
+	Features: []packFeature{
+		{Name: "client", Default: "app", Values: []string{"app", "none"}},
+		{Name: "lang", Default: "cpp", Values: []string{"cpp", "c"}},
+		{Name: "props", Default: "inline", Values: []string{"inline", "shared", "project"}},
+		{Name: "cppruntime", Default: "false", Values: []string{"true", "false"}, Requires: map[string]string{"true": "lang=cpp"}},
+		{Name: "free"},
+	},
+}
+
+func TestMatchCondition(t *testing.T) {
+	features := map[string]string{"client": "app", "lang": "c", "props": "shared", "cppruntime": "false", "free": "anything"}
+
+	tests := []struct {
+		when string
+		want bool
+	}{
+		{"", true},
+		{"   ", true},
+		{"lang=c", true},
+		{"lang=cpp", false},
+		{"lang!=cpp", true},
+		{"lang!=c", false},
+		{"props=shared|project", true},
+		{"props=inline|project", false},
+		{"props!=inline|project", true},
+		{"client=app,props=shared|project", true},
+		{"client=app,lang=cpp", false},
+		{" client = app , lang != cpp ", true},
+		{"free=anything", true},
+		{"free=other", false},
+	}
+
+	for _, test := range tests {
+		got, err := matchCondition(conditionTestPack, test.when, features)
+		if err != nil {
+			t.Errorf("matchCondition(%q): %v", test.when, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("matchCondition(%q) = %v, want %v", test.when, got, test.want)
+		}
+	}
+}
+
+func TestParseConditionErrors(t *testing.T) {
+	tests := []struct {
+		when string
+		want string
+	}{
+		{"lang", "bad condition"},
+		{"=c", "bad condition"},
+		{"lang=c,", "bad condition"},
+		{"arch=x64", "undeclared feature arch"},
+		{"lang=rust", `"rust" is not one of cpp|c`},
+		{"props=shared|", `"" is not one of inline|shared|project`},
+	}
+
+	for _, test := range tests {
+		_, err := parseCondition(conditionTestPack, test.when)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("parseCondition(%q) = %v, want an error mentioning %q", test.when, err, test.want)
+		}
+	}
+}
+
+func TestResolveFeatures(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  map[string]string
+		cli     map[string]string
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name: "defaults",
+			want: map[string]string{"client": "app", "lang": "cpp", "props": "inline", "cppruntime": "false", "free": ""},
+		},
+		{
+			name:   "cli wins over config",
+			config: map[string]string{"lang": "c", "props": "shared"},
+			cli:    map[string]string{"lang": "cpp"},
+			want:   map[string]string{"client": "app", "lang": "cpp", "props": "shared", "cppruntime": "false", "free": ""},
+		},
+		{
+			name:    "undeclared",
+			cli:     map[string]string{"arch": "x64"},
+			wantErr: "does not declare feature arch",
+		},
+		{
+			name:    "bad value",
+			cli:     map[string]string{"client": "gui"},
+			wantErr: `"gui" is not one of app|none`,
+		},
+		{
+			name:    "unmet requirement",
+			cli:     map[string]string{"cppruntime": "true", "lang": "c"},
+			wantErr: "cppruntime=true needs lang=cpp",
+		},
+	}
+
+	for _, test := range tests {
+		got, err := resolveFeatures(conditionTestPack, test.config, test.cli)
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("%s: error %v, want one mentioning %q", test.name, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		for name, value := range test.want {
+			if got[name] != value {
+				t.Errorf("%s: %s = %q, want %q", test.name, name, got[name], value)
+			}
+		}
+	}
+}
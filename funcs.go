@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"github.com/google/uuid"
+)
+
+const (
+	CTL_CODE_FUNCTION_BASE = 0x800
+	CTL_CODE_FUNCTION_MAX  = 0xFFF
+	POOL_TAG_LENGTH        = 4
+)
+
+// templateFuncs are available to every template pack.
+var templateFuncs = template.FuncMap{
+	"pascal":      toPascal,
+	"upperSnake":  toUpperSnake,
+	"lowerCamel":  toLowerCamel,
+	"poolTag":     toPoolTag,
+	"ctlFunction": toCtlFunction,
+	"guidFrom":    nameGuid,
+	"guidStruct":  toGuidStruct,
+	"cString":     toCString,
+	"list":        toList,
+	"contains":    listContains,
+	"union":       listUnion,
+	"regInfo":     toRegInfo,
+}
+
+// splitWords breaks an identifier into words at separators, lower-to-upper
+// transitions and the end of an acronym ("IOCtlHTTPServer2" -> IO Ctl HTTP Server2).
+func splitWords(s string) []string {
+	var words []string
+	var current []rune
+
+	runes := []rune(s)
+	for i, r := range runes {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			if len(current) > 0 {
+				words = append(words, string(current))
+				current = nil
+			}
+			continue
+		}
+
+		if len(current) > 0 && unicode.IsUpper(r) {
+			prev := current[len(current)-1]
+			nextIsLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextIsLower) {
+				words = append(words, string(current))
+				current = nil
+			}
+		}
+
+		current = append(current, r)
+	}
+
+	if len(current) > 0 {
+		words = append(words, string(current))
+	}
+
+	return words
+}
+
+func capitalize(word string) string {
+	runes := []rune(strings.ToLower(word))
+	runes[0] = unicode.ToUpper(runes[0])
+	return string(runes)
+}
+
+func toPascal(s string) string {
+	words := splitWords(s)
+	for i, word := range words {
+		words[i] = capitalize(word)
+	}
+	return strings.Join(words, "")
+}
+
+func toLowerCamel(s string) string {
+	words := splitWords(s)
+	for i, word := range words {
+		if i == 0 {
+			words[i] = strings.ToLower(word)
+		} else {
+			words[i] = capitalize(word)
+		}
+	}
+	return strings.Join(words, "")
+}
+
+func toUpperSnake(s string) string {
+	words := splitWords(s)
+	for i, word := range words {
+		words[i] = strings.ToUpper(word)
+	}
+	return strings.Join(words, "_")
+}
+
+// toPoolTag takes the first four letters or digits of s, pads with spaces and
+// reverses them, so that '...' as a multi-character literal reads as s in
+// pool dumps ("MyDriver" -> "rDyM").
+func toPoolTag(s string) (string, error) {
+	var tag []rune
+	for _, r := range s {
+		if r < 0x80 && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			tag = append(tag, r)
+		}
+		if len(tag) == POOL_TAG_LENGTH {
+			break
+		}
+	}
+
+	if len(tag) == 0 {
+		return "", fmt.Errorf("poolTag: %q has no usable characters", s)
+	}
+
+	for len(tag) < POOL_TAG_LENGTH {
+		tag = append(tag, ' ')
+	}
+
+	for i, j := 0, len(tag)-1; i < j; i, j = i+1, j-1 {
+		tag[i], tag[j] = tag[j], tag[i]
+	}
+
+	return string(tag), nil
+}
+
+// toCtlFunction returns the CTL_CODE function number of the n-th custom
+// IOCTL; values below 0x800 are reserved for Microsoft.
+func toCtlFunction(n int) (string, error) {
+	function := CTL_CODE_FUNCTION_BASE + n
+	if n < 0 || function > CTL_CODE_FUNCTION_MAX {
+		return "", fmt.Errorf("ctlFunction: %d is out of range", n)
+	}
+	return fmt.Sprintf("0x%03X", function), nil
+}
+
+// toCString escapes s for use inside a C string literal. Non-printable bytes
+// use three-digit octal escapes so a following digit can't extend them.
+func toCString(s string) string {
+	var builder strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '\\':
+			builder.WriteString(`\\`)
+		case '"':
+			builder.WriteString(`\"`)
+		case '\n':
+			builder.WriteString(`\n`)
+		case '\r':
+			builder.WriteString(`\r`)
+		case '\t':
+			builder.WriteString(`\t`)
+		case '?':
+			// keep "??x" from turning into a trigraph
+			builder.WriteString(`\?`)
+		default:
+			if c < 0x20 || c >= 0x7F {
+				fmt.Fprintf(&builder, `\%03o`, c)
+			} else {
+				builder.WriteByte(c)
+			}
+		}
+	}
+	return builder.String()
+}
+
+// toGuidStruct formats a GUID such as "{12345678-...}" as the argument list
+// of DEFINE_GUID.
+func toGuidStruct(guid string) (string, error) {
+	id, err := uuid.Parse(strings.Trim(guid, "{}"))
+	if err != nil {
+		return "", fmt.Errorf("guidStruct: %v", err)
+	}
+
+	parts := []string{
+		fmt.Sprintf("0x%02x%02x%02x%02x", id[0], id[1], id[2], id[3]),
+		fmt.Sprintf("0x%02x%02x", id[4], id[5]),
+		fmt.Sprintf("0x%02x%02x", id[6], id[7]),
+	}
+	for _, b := range id[8:] {
+		parts = append(parts, fmt.Sprintf("0x%02x", b))
+	}
+
+	return strings.Join(parts, ", "), nil
+}
+
+// toList splits a comma-separated variable such as "CREATE,WRITE" into its
+// non-empty items. Repeated items are kept once, templates emit a function or
+// case label per item.
+func toList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" && !listContains(items, item) {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func listContains(items []string, item string) bool {
+	for _, candidate := range items {
+		if candidate == item {
+			return true
+		}
+	}
+	return false
+}
+
+// listUnion returns the items of a followed by those of b, each once.
+func listUnion(a, b []string) []string {
+	var result []string
+	for _, item := range append(append([]string(nil), a...), b...) {
+		if !listContains(result, item) {
+			result = append(result, item)
+		}
+	}
+	return result
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitWords(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"MyDriver", []string{"My", "Driver"}},
+		{"my-driver", []string{"my", "driver"}},
+		{"my_driver 2", []string{"my", "driver", "2"}},
+		{"IOCtlHTTPServer2", []string{"IO", "Ctl", "HTTP", "Server2"}},
+		{"HTTPServer", []string{"HTTP", "Server"}},
+		{"Win32Driver", []string{"Win32", "Driver"}},
+		{"ABC", []string{"ABC"}},
+		{"x", []string{"x"}},
+		{"--", nil},
+		{"", nil},
+	}
+
+	for _, test := range tests {
+		if got := splitWords(test.in); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("splitWords(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestCaseConversion(t *testing.T) {
+	tests := []struct {
+		in         string
+		pascal     string
+		lowerCamel string
+		upperSnake string
+	}{
+		{"MyDriver", "MyDriver", "myDriver", "MY_DRIVER"},
+		{"my-driver", "MyDriver", "myDriver", "MY_DRIVER"},
+		{"my_driver", "MyDriver", "myDriver", "MY_DRIVER"},
+		{"IOCtlHTTPServer2", "IoCtlHttpServer2", "ioCtlHttpServer2", "IO_CTL_HTTP_SERVER2"},
+		{"NetFilter", "NetFilter", "netFilter", "NET_FILTER"},
+		{"X", "X", "x", "X"},
+	}
+
+	for _, test := range tests {
+		if got := toPascal(test.in); got != test.pascal {
+			t.Errorf("toPascal(%q) = %q, want %q", test.in, got, test.pascal)
+		}
+		if got := toLowerCamel(test.in); got != test.lowerCamel {
+			t.Errorf("toLowerCamel(%q) = %q, want %q", test.in, got, test.lowerCamel)
+		}
+		if got := toUpperSnake(test.in); got != test.upperSnake {
+			t.Errorf("toUpperSnake(%q) = %q, want %q", test.in, got, test.upperSnake)
+		}
+	}
+}
+
+func TestPoolTag(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"MyDriver", "rDyM", false},
+		{"ab", "  ba", false},
+		{"a-b_c.d", "dcba", false},
+		{"Äbc", "  cb", false},
+		{"--", "", true},
+	}
+
+	for _, test := range tests {
+		got, err := toPoolTag(test.in)
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Errorf("toPoolTag(%q) = %q, %v, want %q, error %v", test.in, got, err, test.want, test.wantErr)
+		}
+	}
+}
+
+func TestCtlFunction(t *testing.T) {
+	tests := []struct {
+		in      int
+		want    string
+		wantErr bool
+	}{
+		{0, "0x800", false},
+		{10, "0x80A", false},
+		{0x7FF, "0xFFF", false},
+		{0x800, "", true},
+		{-1, "", true},
+	}
+
+	for _, test := range tests {
+		got, err := toCtlFunction(test.in)
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Errorf("toCtlFunction(%d) = %q, %v, want %q, error %v", test.in, got, err, test.want, test.wantErr)
+		}
+	}
+}
+
+func TestCString(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{`\Device\KeyboardClass0`, `\\Device\\KeyboardClass0`},
+		{`say "hi"`, `say \"hi\"`},
+		{"a\tb\r\n", `a\tb\r\n`},
+		{"??=", `\?\?=`},
+		{"\x01" + "9", `\0019`},
+	}
+
+	for _, test := range tests {
+		if got := toCString(test.in); got != test.want {
+			t.Errorf("toCString(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestList(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"CREATE", []string{"CREATE"}},
+		{" CREATE , WRITE ,", []string{"CREATE", "WRITE"}},
+		{"CREATE,CREATE", []string{"CREATE"}},
+		{"PreSetValueKey,PreDeleteKey,PreSetValueKey", []string{"PreSetValueKey", "PreDeleteKey"}},
+		{"", nil},
+		{" , ", nil},
+	}
+
+	for _, test := range tests {
+		if got := toList(test.in); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("toList(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestListUnion(t *testing.T) {
+	tests := []struct {
+		a, b []string
+		want []string
+	}{
+		{[]string{"CREATE"}, []string{"CREATE"}, []string{"CREATE"}},
+		{[]string{"CREATE", "READ"}, []string{"WRITE", "CREATE"}, []string{"CREATE", "READ", "WRITE"}},
+		{[]string{"CREATE", "CREATE"}, nil, []string{"CREATE"}},
+		{nil, []string{"READ", "READ"}, []string{"READ"}},
+		{nil, nil, nil},
+	}
+
+	for _, test := range tests {
+		if got := listUnion(test.a, test.b); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("listUnion(%q, %q) = %q, want %q", test.a, test.b, got, test.want)
+		}
+	}
+}
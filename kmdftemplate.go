@@ -0,0 +1,359 @@
+package main
+
+const KMDF_PACK_NAME = `kmdf`
+
+var kmdfModel = driverModel{
+	Name:        KMDF_PACK_NAME,
+	Description: "KMDF driver with a default I/O queue and a console client",
+	NoAlloc:     true,
+	Features: []packFeature{
+		{Name: "device", Default: "control", Values: []string{"control", "pnp"}, Description: "non-PnP control device, or a root-enumerated PnP device added in EvtDriverDeviceAdd", Requires: map[string]string{"pnp": "inf=true"}},
+	},
+	Settings: []packSettings{
+		{Project: SETTINGS_PROJECT_DRIVER, configOverrides: configOverrides{
+			Properties: map[string]string{"DriverType": "KMDF"},
+		}},
+		wdmsecSettings,
+	},
+	Header: KMDF_DRIVER_HEADER_TEMPLATE,
+	Source: KMDF_DRIVER_SOURCE_TEMPLATE,
+	Inf:    KMDF_DRIVER_INF_TEMPLATE,
+	Client: EXE_CPP_TEMPLATE,
+}
+
+const KMDF_DRIVER_HEADER_TEMPLATE =
+`#pragma once
+
+{{ if eq .Features.lang "cpp" -}}
+extern "C"
+{
+{{ end -}}
+#include <ntddk.h>
+#include <wdf.h>
+#include <wdmsec.h>
+#include "..\Common\Common.h"
+{{- if eq .Features.lang "cpp" }}
+}
+{{- end }}
+{{- if eq .Features.cppruntime "true" }}
+
+#include "KernelCpp.h"
+{{- end }}
+
+#ifndef _FN_
+#define _FN_	__FUNCTION__
+#endif
+
+#ifndef _LN_
+#define _LN_	__LINE__
+#endif
+
+#define DEVICE_NAME		L"\\Device\\$PROJECTNAME_SYS$"
+#define DOS_DEVICE_NAME	L"\\DosDevices\\$PROJECTNAME_SYS$"
+
+EXTERN_C DRIVER_INITIALIZE DriverEntry;
+{{- if eq .Features.device "pnp" }}
+EVT_WDF_DRIVER_DEVICE_ADD EvtDriverDeviceAdd;
+{{- else }}
+EVT_WDF_DRIVER_UNLOAD EvtDriverUnload;
+{{- end }}
+EVT_WDF_IO_QUEUE_IO_DEVICE_CONTROL EvtIoDeviceControl;
+{{- if ne .Features.device "pnp" }}
+
+NTSTATUS
+CreateControlDevice(
+	IN	WDFDRIVER			driver
+);
+{{- end }}
+
+NTSTATUS
+CreateQueue(
+	IN	WDFDEVICE			device
+);
+`
+
+const KMDF_DRIVER_SOURCE_TEMPLATE =
+`#include "$PROJECTNAME_SYS$.h"
+
+
+EXTERN_C
+NTSTATUS
+DriverEntry(
+	IN	PDRIVER_OBJECT		pDriverObject,
+	IN	PUNICODE_STRING		pRegistryPath
+)
+{
+	NTSTATUS status = STATUS_UNSUCCESSFUL;
+	WDF_DRIVER_CONFIG config;
+{{- if ne .Features.device "pnp" }}
+	WDFDRIVER driver = NULL;
+{{- end }}
+
+	KdPrint(("[%s:%d]\n", _FN_, _LN_));
+{{ if eq .Features.device "pnp" }}
+	WDF_DRIVER_CONFIG_INIT(&config, EvtDriverDeviceAdd);
+
+	status = WdfDriverCreate(pDriverObject, pRegistryPath, WDF_NO_OBJECT_ATTRIBUTES, &config, WDF_NO_HANDLE);
+	if (!NT_SUCCESS(status))
+	{
+		KdPrint(("[%s:%d] WdfDriverCreate failed. status : 0x%X\n", _FN_, _LN_, status));
+	}
+
+	return status;
+{{- else }}
+	WDF_DRIVER_CONFIG_INIT(&config, WDF_NO_EVENT_CALLBACK);
+	config.DriverInitFlags |= WdfDriverInitNonPnpDriver;
+	config.EvtDriverUnload = EvtDriverUnload;
+
+	status = WdfDriverCreate(pDriverObject, pRegistryPath, WDF_NO_OBJECT_ATTRIBUTES, &config, &driver);
+	if (!NT_SUCCESS(status))
+	{
+		KdPrint(("[%s:%d] WdfDriverCreate failed. status : 0x%X\n", _FN_, _LN_, status));
+		return status;
+	}
+
+	return CreateControlDevice(driver);
+{{- end }}
+}
+
+{{ if eq .Features.device "pnp" }}
+NTSTATUS
+EvtDriverDeviceAdd(
+	IN	WDFDRIVER			driver,
+	IN	PWDFDEVICE_INIT		pDeviceInit
+)
+{
+	NTSTATUS status = STATUS_UNSUCCESSFUL;
+	WDFDEVICE device = NULL;
+	DECLARE_CONST_UNICODE_STRING(symbolicLinkName, DOS_DEVICE_NAME);
+
+	UNREFERENCED_PARAMETER(driver);
+
+	KdPrint(("[%s:%d]\n", _FN_, _LN_));
+
+	WdfDeviceInitSetDeviceType(pDeviceInit, {{ .Vars.DeviceType }});
+
+	status = WdfDeviceCreate(&pDeviceInit, WDF_NO_OBJECT_ATTRIBUTES, &device);
+	if (!NT_SUCCESS(status))
+	{
+		return status;
+	}
+
+	status = WdfDeviceCreateSymbolicLink(device, &symbolicLinkName);
+	if (!NT_SUCCESS(status))
+	{
+		return status;
+	}
+
+	return CreateQueue(device);
+}
+{{- else }}
+void
+EvtDriverUnload(
+	IN	WDFDRIVER			driver
+)
+{
+	UNREFERENCED_PARAMETER(driver);
+
+	// The framework deletes the control device and its symbolic link.
+	KdPrint(("[%s:%d]\n", _FN_, _LN_));
+}
+
+
+NTSTATUS
+CreateControlDevice(
+	IN	WDFDRIVER			driver
+)
+{
+	NTSTATUS status = STATUS_UNSUCCESSFUL;
+	PWDFDEVICE_INIT pDeviceInit = NULL;
+	WDFDEVICE device = NULL;
+	DECLARE_CONST_UNICODE_STRING(deviceName, DEVICE_NAME);
+	DECLARE_CONST_UNICODE_STRING(symbolicLinkName, DOS_DEVICE_NAME);
+
+	pDeviceInit = WdfControlDeviceInitAllocate(driver, &SDDL_DEVOBJ_SYS_ALL_ADM_ALL);
+	if (pDeviceInit == NULL)
+	{
+		return STATUS_INSUFFICIENT_RESOURCES;
+	}
+
+	do
+	{
+		WdfDeviceInitSetDeviceType(pDeviceInit, {{ .Vars.DeviceType }});
+		WdfDeviceInitSetExclusive(pDeviceInit, FALSE);
+
+		status = WdfDeviceInitAssignName(pDeviceInit, &deviceName);
+		if (!NT_SUCCESS(status))
+		{
+			break;
+		}
+
+		status = WdfDeviceCreate(&pDeviceInit, WDF_NO_OBJECT_ATTRIBUTES, &device);
+		if (!NT_SUCCESS(status))
+		{
+			break;
+		}
+
+		status = WdfDeviceCreateSymbolicLink(device, &symbolicLinkName);
+		if (!NT_SUCCESS(status))
+		{
+			break;
+		}
+
+		status = CreateQueue(device);
+		if (!NT_SUCCESS(status))
+		{
+			break;
+		}
+
+		WdfControlFinishInitializing(device);
+
+	} while (FALSE);
+
+	if (!NT_SUCCESS(status))
+	{
+		KdPrint(("[%s:%d] failed. status : 0x%X\n", _FN_, _LN_, status));
+
+		// WdfDeviceCreate frees pDeviceInit and sets it to NULL on success.
+		if (pDeviceInit != NULL)
+		{
+			WdfDeviceInitFree(pDeviceInit);
+		}
+
+		if (device != NULL)
+		{
+			WdfObjectDelete(device);
+		}
+	}
+
+	return status;
+}
+{{- end }}
+
+
+NTSTATUS
+CreateQueue(
+	IN	WDFDEVICE			device
+)
+{
+	WDF_IO_QUEUE_CONFIG queueConfig;
+	WDFQUEUE queue = NULL;
+
+	WDF_IO_QUEUE_CONFIG_INIT_DEFAULT_QUEUE(&queueConfig, WdfIoQueueDispatchSequential);
+	queueConfig.EvtIoDeviceControl = EvtIoDeviceControl;
+
+	return WdfIoQueueCreate(device, &queueConfig, WDF_NO_OBJECT_ATTRIBUTES, &queue);
+}
+
+
+void
+EvtIoDeviceControl(
+	IN	WDFQUEUE			queue,
+	IN	WDFREQUEST			request,
+	IN	size_t				outputBufferLength,
+	IN	size_t				inputBufferLength,
+	IN	ULONG				ioControlCode
+)
+{
+	NTSTATUS status = STATUS_UNSUCCESSFUL;
+	ULONG_PTR information = 0;
+	P{{ upperSnake .ProjectName }}_DATA_1 pData = NULL;
+
+	UNREFERENCED_PARAMETER(queue);
+	UNREFERENCED_PARAMETER(outputBufferLength);
+
+	switch (ioControlCode)
+	{
+	case IOCTL_{{ upperSnake .ProjectName }}_1:
+	{
+		KdPrint(("[%s:%d] IOCTL_{{ upperSnake .ProjectName }}_1. inSize : 0x%IX\n", _FN_, _LN_, inputBufferLength));
+		if (inputBufferLength != sizeof({{ upperSnake .ProjectName }}_DATA_1))
+		{
+			status = STATUS_INVALID_PARAMETER;
+			break;
+		}
+
+		status = WdfRequestRetrieveInputBuffer(request, sizeof({{ upperSnake .ProjectName }}_DATA_1), (PVOID*)&pData, NULL);
+		if (!NT_SUCCESS(status))
+		{
+			break;
+		}
+
+		status = STATUS_SUCCESS;
+		break;
+	}
+	default:
+		status = STATUS_INVALID_DEVICE_REQUEST;
+		information = 0;
+		break;
+	}
+
+	WdfRequestCompleteWithInformation(request, status, information);
+}
+`
+
+// KMDF_DRIVER_INF_TEMPLATE installs the PnP variant as a root-enumerated
+// device; the control device uses the legacy service INF.
+const KMDF_DRIVER_INF_TEMPLATE =
+`{{ if eq .Features.device "pnp" -}}
+;
+; $PROJECTNAME_SYS$.inf
+;
+; Installs $TARGETNAME_SYS$.sys for a root-enumerated device.
+;   devcon install $PROJECTNAME_SYS$.inf Root\$PROJECTNAME_SYS$
+;
+
+[Version]
+Signature   = "$WINDOWS NT$"
+Class       = System
+ClassGuid   = {4d36e97d-e325-11ce-bfc1-08002be10318}
+Provider    = %ManufacturerName%
+DriverVer   =
+CatalogFile = $PROJECTNAME_SYS$.cat
+PnpLockdown = 1
+
+[DestinationDirs]
+DefaultDestDir = 12
+
+[SourceDisksNames]
+1 = %DiskName%,,,""
+
+[SourceDisksFiles]
+$TARGETNAME_SYS$.sys = 1,,
+
+[Manufacturer]
+%ManufacturerName% = Standard,NT$ARCH$
+
+[Standard.NT$ARCH$]
+%DeviceDesc% = Device_Install, Root\$PROJECTNAME_SYS$
+
+[Device_Install.NT]
+CopyFiles = Drivers_Dir
+
+[Drivers_Dir]
+$TARGETNAME_SYS$.sys
+
+[Device_Install.NT.Services]
+AddService = $PROJECTNAME_SYS$,0x00000002,Service_Install
+
+[Service_Install]
+DisplayName    = %ServiceName%
+ServiceType    = 1               ; SERVICE_KERNEL_DRIVER
+StartType      = 3               ; SERVICE_DEMAND_START
+ErrorControl   = 1               ; SERVICE_ERROR_NORMAL
+ServiceBinary  = %12%\$TARGETNAME_SYS$.sys
+
+[Device_Install.NT.Wdf]
+KmdfService = $PROJECTNAME_SYS$, Wdf_Install
+
+[Wdf_Install]
+KmdfLibraryVersion = $KMDFVERSION$
+
+[Strings]
+ManufacturerName = "{{ or .Vars.Company .ProjectName }}"
+DiskName         = "$PROJECTNAME_SYS$ Installation Disk"
+DeviceDesc       = "$PROJECTNAME_SYS$ Device"
+ServiceName      = "$PROJECTNAME_SYS$ Service"
+{{ else -}}
+` + DRIVER_INF_TEMPLATE + `{{- end }}
+`
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestKmdfLint(t *testing.T) {
+	pack := builtinPacks[KMDF_PACK_NAME]
+	vars := map[string]string{"DeviceType": "FILE_DEVICE_NETWORK", "PoolTag": "Kmdf"}
+
+	for _, problem := range lintPack(pack, vars) {
+		t.Error(problem)
+	}
+
+	for _, device := range []string{"control", "pnp"} {
+		features := map[string]string{"device": device, "inf": "true"}
+		files := renderTestPack(t, pack, vars, features)
+
+		project := files[LINT_SAMPLE_NAME+"/"+LINT_SAMPLE_NAME+".vcxproj"]
+		if !strings.Contains(project, "wdmsec.lib;") {
+			t.Errorf("device=%s: the driver does not link wdmsec.lib", device)
+		}
+		if strings.Contains(files[LINT_SAMPLE_NAME+"/"+LINT_SAMPLE_NAME+".h"], "ALLOC_NONPAGED") {
+			t.Errorf("device=%s: the driver allocates nothing, but its header defines ALLOC_NONPAGED", device)
+		}
+	}
+}
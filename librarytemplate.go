@@ -0,0 +1,220 @@
+package main
+
+const VCXPROJ_LIB_TEMPLATE =
+`<?xml version="1.0" encoding="utf-8"?>
+<Project DefaultTargets="Build" ToolsVersion="12.0" xmlns="http://schemas.microsoft.com/developer/msbuild/2003">
+  <ItemGroup Label="ProjectConfigurations">
+$LIB_PROJECT_CONFIGURATIONS$
+  </ItemGroup>
+  <PropertyGroup Label="Globals">
+    <ProjectGuid>$GUID_LIB$</ProjectGuid>
+    <TargetFrameworkVersion>v4.5</TargetFrameworkVersion>
+    <MinimumVisualStudioVersion>12.0</MinimumVisualStudioVersion>
+    <Configuration>Debug</Configuration>
+    <Platform Condition="'$(Platform)' == ''">$SYS_DEFAULT_PLATFORM$</Platform>
+    <RootNamespace>$PROJECTNAME_LIB$</RootNamespace>
+{{- if ne .Features.props "inline" }}
+    <!-- Directory.Build.props is imported with the property sheets below. -->
+    <ImportDirectoryBuildProps>false</ImportDirectoryBuildProps>
+{{- end }}
+  </PropertyGroup>
+  <Import Project="$(VCTargetsPath)\Microsoft.Cpp.Default.props" />
+$LIB_CONFIGURATION_GROUPS$
+  <Import Project="$(VCTargetsPath)\Microsoft.Cpp.props" />
+  <ImportGroup Label="ExtensionSettings">
+  </ImportGroup>
+  <ImportGroup Label="PropertySheets">
+    <Import Project="$(UserRootDir)\Microsoft.Cpp.$(Platform).user.props" Condition="exists('$(UserRootDir)\Microsoft.Cpp.$(Platform).user.props')" Label="LocalAppDataPlatform" />
+{{- if ne .Features.props "inline" }}
+    <Import Project="..\Directory.Build.props" />
+{{- end }}
+{{- if eq .Features.props "project" }}
+    <Import Project="$PROJECTNAME_LIB$.props" />
+{{- end }}
+  </ImportGroup>
+  <PropertyGroup Label="UserMacros" />
+  <PropertyGroup />
+{{- if eq .Features.props "inline" }}
+$LIB_PROPERTY_GROUPS$
+$LIB_ITEM_DEFINITION_GROUPS$
+{{- else if eq .Features.props "shared" }}
+$LIB_PROJECT_PROPERTY_GROUPS$
+$LIB_PROJECT_ITEM_DEFINITION_GROUPS$
+{{- end }}
+  <ItemGroup>
+    <ClInclude Include="$PROJECTNAME_LIB$.h" />
+  </ItemGroup>
+  <ItemGroup>
+    <ClCompile Include="$PROJECTNAME_LIB$.{{ .Features.lang }}" />
+  </ItemGroup>
+{{- if eq .Features.library "export" }}
+  <ItemGroup>
+    <None Include="$PROJECTNAME_LIB$.def" />
+  </ItemGroup>
+{{- end }}
+  <Import Project="$(VCTargetsPath)\Microsoft.Cpp.targets" />
+  <ImportGroup Label="ExtensionTargets">
+  </ImportGroup>
+</Project>
+`
+
+const VCXPROJFILTER_LIB_TEMPLATE =
+`<?xml version="1.0" encoding="utf-8"?>
+<Project ToolsVersion="4.0" xmlns="http://schemas.microsoft.com/developer/msbuild/2003">
+  <ItemGroup>
+    <Filter Include="Source Files">
+      <UniqueIdentifier>{4FC737F1-C7A5-4376-A066-2A32D752A2FF}</UniqueIdentifier>
+      <Extensions>cpp;c;cc;cxx;def;odl;idl;hpj;bat;asm;asmx</Extensions>
+    </Filter>
+    <Filter Include="Header Files">
+      <UniqueIdentifier>{93995380-89BD-4b04-88EB-625FBE52EBFB}</UniqueIdentifier>
+      <Extensions>h;hpp;hxx;hm;inl;inc;xsd</Extensions>
+    </Filter>
+  </ItemGroup>
+  <ItemGroup>
+    <ClInclude Include="$PROJECTNAME_LIB$.h">
+      <Filter>Header Files</Filter>
+    </ClInclude>
+  </ItemGroup>
+  <ItemGroup>
+    <ClCompile Include="$PROJECTNAME_LIB$.{{ .Features.lang }}">
+      <Filter>Source Files</Filter>
+    </ClCompile>
+  </ItemGroup>
+{{- if eq .Features.library "export" }}
+  <ItemGroup>
+    <None Include="$PROJECTNAME_LIB$.def">
+      <Filter>Source Files</Filter>
+    </None>
+  </ItemGroup>
+{{- end }}
+</Project>
+`
+
+const PROPS_LIB_TEMPLATE =
+`<?xml version="1.0" encoding="utf-8"?>
+<!-- $PROJECTNAME_LIB$ library settings, imported after Directory.Build.props. -->
+<Project ToolsVersion="4.0" xmlns="http://schemas.microsoft.com/developer/msbuild/2003">
+$LIB_PROJECT_PROPERTY_GROUPS$
+$LIB_PROJECT_ITEM_DEFINITION_GROUPS$
+</Project>
+`
+
+// LIBRARY_HEADER_TEMPLATE is included by the driver as well, through the
+// include directory its project adds.
+const LIBRARY_HEADER_TEMPLATE =
+`#pragma once
+{{- $name := upperSnake .ProjectName }}
+
+{{ if eq .Features.lang "cpp" -}}
+extern "C"
+{
+{{ end -}}
+#include <ntddk.h>
+{{- if eq .Features.lang "cpp" }}
+}
+{{- end }}
+
+#ifndef _FN_
+#define _FN_	__FUNCTION__
+#endif
+
+#ifndef _LN_
+#define _LN_	__LINE__
+#endif
+{{ if eq .Features.library "export" }}
+// Drivers import the functions from $PROJECTNAME_LIB$.sys, the library
+// source defines {{ $name }}_LIB_EXPORTS before including this header.
+#ifdef {{ $name }}_LIB_EXPORTS
+#define {{ $name }}_LIB_API
+#else
+#define {{ $name }}_LIB_API	DECLSPEC_IMPORT
+#endif
+{{- else }}
+// Linked into every driver of the solution that references the project.
+#define {{ $name }}_LIB_API
+{{- end }}
+
+#define {{ $name }}_LIB_VERSION	0x00010000
+
+
+EXTERN_C
+{{ $name }}_LIB_API
+ULONG
+{{ pascal .ProjectName }}LibGetVersion(
+);
+`
+
+const LIBRARY_SOURCE_TEMPLATE =
+`{{ if eq .Features.library "export" -}}
+#define {{ upperSnake .ProjectName }}_LIB_EXPORTS
+{{ end -}}
+#include "$PROJECTNAME_LIB$.h"
+{{- if eq .Features.library "export" }}
+
+
+// DriverEntry is needed to link, but an export driver is loaded as a DLL
+// by the first driver importing it, so it is never called.
+EXTERN_C
+NTSTATUS
+DriverEntry(
+	IN	PDRIVER_OBJECT		pDriverObject,
+	IN	PUNICODE_STRING		pRegistryPath
+)
+{
+	UNREFERENCED_PARAMETER(pDriverObject);
+	UNREFERENCED_PARAMETER(pRegistryPath);
+
+	return STATUS_SUCCESS;
+}
+
+
+EXTERN_C
+NTSTATUS
+DllInitialize(
+	IN	PUNICODE_STRING		pRegistryPath
+)
+{
+	UNREFERENCED_PARAMETER(pRegistryPath);
+
+	KdPrint(("[%s:%d]\n", _FN_, _LN_));
+
+	return STATUS_SUCCESS;
+}
+
+
+// DllUnload runs once the last importing driver has unloaded.
+EXTERN_C
+NTSTATUS
+DllUnload(
+)
+{
+	KdPrint(("[%s:%d]\n", _FN_, _LN_));
+
+	return STATUS_SUCCESS;
+}
+{{- end }}
+
+
+EXTERN_C
+ULONG
+{{ pascal .ProjectName }}LibGetVersion(
+)
+{
+	return {{ upperSnake .ProjectName }}_LIB_VERSION;
+}
+`
+
+const LIBRARY_DEF_TEMPLATE =
+`;
+; $PROJECTNAME_LIB$.def
+;
+; DllInitialize and DllUnload are exported PRIVATE, so they stay out of the
+; import library drivers link against.
+;
+
+EXPORTS
+	DllInitialize PRIVATE
+	DllUnload PRIVATE
+	{{ pascal .ProjectName }}LibGetVersion
+`
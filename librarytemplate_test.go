@@ -0,0 +1,56 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestLibraryFeature(t *testing.T) {
+	libName := libraryName(LINT_SAMPLE_NAME)
+	libPrefix := libName + "/" + libName
+	sysProject := LINT_SAMPLE_NAME + "/" + LINT_SAMPLE_NAME + ".vcxproj"
+	pack := builtinPacks[DEFAULT_PACK_NAME]
+
+	files := renderTestPack(t, pack, nil, nil)
+	if _, ok := files[libPrefix+".vcxproj"]; ok {
+		t.Errorf("library=none: %s.vcxproj is emitted", libPrefix)
+	}
+	if strings.Contains(files[sysProject], "<ProjectReference") {
+		t.Errorf("library=none: the driver project references a library")
+	}
+
+	for _, test := range []struct {
+		library           string
+		configurationType string
+		def               bool
+	}{
+		{"static", "StaticLibrary", false},
+		{"export", "Driver", true},
+	} {
+		files := renderTestPack(t, pack, nil, map[string]string{"library": test.library})
+		project := files[libPrefix+".vcxproj"]
+		if project == "" {
+			t.Fatalf("library=%s: %s.vcxproj is not emitted", test.library, libPrefix)
+		}
+
+		if !strings.Contains(project, "<ConfigurationType>"+test.configurationType+"</ConfigurationType>") {
+			t.Errorf("library=%s: the library project is not a %s", test.library, test.configurationType)
+		}
+		if hasDef := strings.Contains(project, "<ModuleDefinitionFile>$(ProjectName).def</ModuleDefinitionFile>"); hasDef != test.def {
+			t.Errorf("library=%s: the library project has ModuleDefinitionFile %v", test.library, hasDef)
+		}
+		if _, hasDef := files[libPrefix+".def"]; hasDef != test.def {
+			t.Errorf("library=%s: %s.def emitted %v", test.library, libPrefix, hasDef)
+		}
+
+		guid := regexp.MustCompile(`<ProjectGuid>(\{[0-9A-F-]+\})</ProjectGuid>`).FindStringSubmatch(project)
+		if guid == nil {
+			t.Fatalf("library=%s: the library project has no ProjectGuid", test.library)
+		}
+		reference := `<ProjectReference Include="..\` + libName + `\` + libName + `.vcxproj">` + "\n      <Project>" + guid[1] + "</Project>"
+		if !strings.Contains(files[sysProject], reference) {
+			t.Errorf("library=%s: the driver project does not reference the library project %s", test.library, guid[1])
+		}
+	}
+}
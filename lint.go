@@ -0,0 +1,383 @@
+package main
+
+import (
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	LINT_SAMPLE_NAME      = `SampleDriver`
+	LINT_SAMPLE_VSVERSION = `16.0.0.0`
+	MSBUILD_NAMESPACE     = `http://schemas.microsoft.com/developer/msbuild/2003`
+	SLN_HEADER            = `Microsoft Visual Studio Solution File, Format Version`
+)
+
+var (
+	leftoverMarkRegex  = regexp.MustCompile(`\$[A-Z][A-Z0-9_]*\$`)
+	slnProjectRegex    = regexp.MustCompile(`^Project\("(\{[0-9A-Fa-f-]{36}\})"\) = "([^"]+)", "([^"]+)", "(\{[0-9A-Fa-f-]{36}\})"$`)
+	slnProjectCfgRegex = regexp.MustCompile(`^(\{[0-9A-Fa-f-]{36}\})\.`)
+	slnPropertyRegex   = regexp.MustCompile(`^[A-Za-z]+ = \S`)
+
+	// Markers owned by other tools (stampinf) that must survive rendering.
+	foreignMarks = map[string]bool{
+		`$ARCH$`:        true,
+		`$KMDFVERSION$`: true,
+		`$UMDFVERSION$`: true,
+	}
+)
+
+// runTemplatesCommand handles "drivercodegen templates <subcommand> ...".
+// It returns the process exit code.
+func runTemplatesCommand(args []string) int {
+	if len(args) == 0 || args[0] != "lint" {
+		log.Println("[-] ex) drivercodegen.exe templates lint [-var Name=Value] [pack]")
+		return 2
+	}
+
+	lintVars := varFlags{}
+	flags := flag.NewFlagSet("templates lint", flag.ContinueOnError)
+	flags.Var(lintVars, "var", "template variable as Name=Value (repeatable)")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	names := flags.Args()
+	if len(names) == 0 {
+		for name := range builtinPacks {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	failed := false
+	for _, name := range names {
+		pack, err := loadPack(name)
+		if err != nil {
+			log.Printf("[-] %s : %v\n", name, err)
+			failed = true
+			continue
+		}
+
+		problems := lintPack(pack, lintVars)
+		for _, problem := range problems {
+			log.Printf("[-] %s : %s\n", pack.Name, problem)
+		}
+
+		if len(problems) > 0 {
+			failed = true
+			continue
+		}
+		log.Printf("[+] %s : OK\n", pack.Name)
+	}
+
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// sampleVariables fills in a value for every required variable that has no
+// default and was not given on the command line.
+func sampleVariables(pack *templatePack, given map[string]string) map[string]string {
+	values := map[string]string{}
+	for _, decl := range pack.Variables {
+		if _, ok := given[decl.Name]; ok || !decl.Required || decl.Default != "" {
+			continue
+		}
+
+		switch decl.Type {
+		case VAR_TYPE_INT:
+			values[decl.Name] = "1"
+		case VAR_TYPE_BOOL:
+			values[decl.Name] = "true"
+		default:
+			values[decl.Name] = "Sample"
+		}
+	}
+	return values
+}
+
+// lintPack renders pack with sample inputs, once with the default features
+// and once for every other value of each feature, and returns everything
+// wrong with the results.
+func lintPack(pack *templatePack, given map[string]string) []string {
+	problems := lintPackWith(pack, given, nil)
+
+	for _, feature := range pack.Features {
+		for _, value := range feature.Values {
+			if value == feature.Default {
+				continue
+			}
+
+			for _, problem := range lintPackWith(pack, given, requiredFeatures(pack, feature.Name, value)) {
+				problems = append(problems, fmt.Sprintf("[%s=%s] %s", feature.Name, value, problem))
+			}
+		}
+	}
+
+	return problems
+}
+
+func lintPackWith(pack *templatePack, given map[string]string, featureValues map[string]string) []string {
+	vars, err := resolveVariables(pack, sampleVariables(pack, given), given)
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	features, err := resolveFeatures(pack, nil, featureValues)
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	if err := applyPackSettings(pack, features); err != nil {
+		return []string{err.Error()}
+	}
+
+	data := &templateData{
+		ProjectName: LINT_SAMPLE_NAME,
+		Vars:        vars,
+		Features:    features,
+	}
+
+	files, err := renderPackFiles(pack, makeMarks(LINT_SAMPLE_NAME, LINT_SAMPLE_VSVERSION), data)
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	emitted := map[string]bool{}
+	for _, file := range files {
+		key := strings.ToLower(file.path)
+		if emitted[key] {
+			return []string{fmt.Sprintf("%s is emitted twice", file.path)}
+		}
+		emitted[key] = true
+	}
+
+	var problems []string
+	projectGuids := map[string]string{}
+
+	for _, file := range files {
+		for _, mark := range leftoverMarkRegex.FindAllString(file.contents, -1) {
+			if !foreignMarks[mark] {
+				problems = append(problems, fmt.Sprintf("%s: unreplaced marker %s", file.path, mark))
+				break
+			}
+		}
+		if strings.Contains(file.contents, "{{") || strings.Contains(file.contents, "}}") {
+			problems = append(problems, fmt.Sprintf("%s: leftover template delimiters", file.path))
+		}
+
+		if isMSBuildFile(file.path) {
+			guid, fileProblems := lintMSBuildFile(file, emitted)
+			problems = append(problems, fileProblems...)
+			if guid != "" {
+				projectGuids[strings.ToLower(file.path)] = guid
+			}
+		}
+	}
+
+	for _, file := range files {
+		if strings.HasSuffix(strings.ToLower(file.path), ".sln") {
+			problems = append(problems, lintSolutionFile(file, emitted, projectGuids)...)
+		}
+	}
+
+	return problems
+}
+
+func isMSBuildFile(filePath string) bool {
+	switch strings.ToLower(path.Ext(filePath)) {
+	case ".vcxproj", ".filters", ".props", ".targets":
+		return true
+	}
+	return false
+}
+
+// resolveInclude turns an Include attribute into a pack-relative path, or ""
+// when it points somewhere the pack cannot know about.
+func resolveInclude(baseDir, include string) string {
+	if strings.Contains(include, "$(") || strings.ContainsAny(include, "*?%") {
+		return ""
+	}
+	return path.Join(baseDir, strings.Replace(include, `\`, `/`, -1))
+}
+
+// lintMSBuildFile checks that file is well-formed MSBuild XML and that its
+// ClCompile/ClInclude/Inf items, project references and imported files exist.
+// It returns the ProjectGuid, if any.
+func lintMSBuildFile(file renderedFile, emitted map[string]bool) (string, []string) {
+	var problems []string
+	var guid string
+	var stack []string
+
+	decoder := xml.NewDecoder(strings.NewReader(file.contents))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", append(problems, fmt.Sprintf("%s: %v", file.path, err))
+		}
+
+		switch element := token.(type) {
+		case xml.StartElement:
+			if len(stack) == 0 {
+				if element.Name.Local != "Project" || element.Name.Space != MSBUILD_NAMESPACE {
+					problems = append(problems, fmt.Sprintf("%s: root element is not an MSBuild <Project>", file.path))
+				}
+			}
+			stack = append(stack, element.Name.Local)
+
+			reference := "Include"
+			switch element.Name.Local {
+			case "ClCompile", "ClInclude", "Inf", "ProjectReference":
+			case "Import":
+				reference = "Project"
+			default:
+				continue
+			}
+			for _, attr := range element.Attr {
+				if attr.Name.Local != reference {
+					continue
+				}
+				target := resolveInclude(path.Dir(file.path), attr.Value)
+				if target != "" && !emitted[strings.ToLower(target)] {
+					problems = append(problems, fmt.Sprintf("%s: %s %s is not emitted by the pack", file.path, element.Name.Local, attr.Value))
+				}
+			}
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) > 0 && stack[len(stack)-1] == "ProjectGuid" {
+				guid = strings.TrimSpace(string(element))
+			}
+		}
+	}
+
+	return guid, problems
+}
+
+// lintSolutionFile does a line-oriented parse of a .sln file.
+func lintSolutionFile(file renderedFile, emitted map[string]bool, projectGuids map[string]string) []string {
+	var problems []string
+	fail := func(lineNo int, format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf("%s:%d: %s", file.path, lineNo, fmt.Sprintf(format, args...)))
+	}
+
+	lines := strings.Split(strings.Replace(file.contents, "\r\n", "\n", -1), "\n")
+	seenHeader := false
+	inProject, inGlobal, inSection := false, false, false
+	globals := 0
+	guids := map[string]bool{}
+	var configRefs []int
+
+	for i, rawLine := range lines {
+		lineNo := i + 1
+		line := strings.TrimSpace(rawLine)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if !seenHeader {
+			if !strings.HasPrefix(line, SLN_HEADER) {
+				fail(lineNo, "missing solution file header")
+				return problems
+			}
+			seenHeader = true
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "Project("):
+			if inProject || inGlobal {
+				fail(lineNo, "Project inside another block")
+			}
+			inProject = true
+
+			match := slnProjectRegex.FindStringSubmatch(line)
+			if match == nil {
+				fail(lineNo, "malformed Project line")
+				continue
+			}
+
+			guid := strings.ToUpper(match[4])
+			if guids[guid] {
+				fail(lineNo, "duplicate project guid %s", match[4])
+			}
+			guids[guid] = true
+
+			target := resolveInclude(path.Dir(file.path), match[3])
+			if target == "" {
+				continue
+			}
+			if !emitted[strings.ToLower(target)] {
+				fail(lineNo, "project %s is not emitted by the pack", match[3])
+			} else if projectGuid, ok := projectGuids[strings.ToLower(target)]; ok && !strings.EqualFold(projectGuid, guid) {
+				fail(lineNo, "project %s has ProjectGuid %s", match[3], projectGuid)
+			}
+		case line == "EndProject":
+			if !inProject {
+				fail(lineNo, "EndProject without Project")
+			}
+			inProject = false
+		case line == "Global":
+			if inProject || inGlobal {
+				fail(lineNo, "Global inside another block")
+			}
+			inGlobal = true
+			globals++
+		case line == "EndGlobal":
+			if !inGlobal || inSection {
+				fail(lineNo, "unbalanced EndGlobal")
+			}
+			inGlobal = false
+		case strings.HasPrefix(line, "GlobalSection("):
+			if !inGlobal || inSection {
+				fail(lineNo, "GlobalSection outside Global")
+			}
+			inSection = true
+		case line == "EndGlobalSection":
+			if !inSection {
+				fail(lineNo, "EndGlobalSection without GlobalSection")
+			}
+			inSection = false
+		case inSection:
+			if slnProjectCfgRegex.MatchString(line) {
+				configRefs = append(configRefs, lineNo)
+			}
+		case inProject:
+			// ProjectSection contents are left alone.
+		case !inGlobal && slnPropertyRegex.MatchString(line):
+			// VisualStudioVersion and friends.
+		default:
+			fail(lineNo, "unexpected line %q", line)
+		}
+	}
+
+	if !seenHeader {
+		fail(len(lines), "missing solution file header")
+	}
+	if inProject || inGlobal || inSection {
+		fail(len(lines), "unterminated block")
+	}
+	if globals != 1 {
+		fail(len(lines), "expected exactly one Global block, found %d", globals)
+	}
+
+	for _, lineNo := range configRefs {
+		guid := strings.ToUpper(slnProjectCfgRegex.FindStringSubmatch(strings.TrimSpace(lines[lineNo-1]))[1])
+		if !guids[guid] {
+			fail(lineNo, "configuration for unknown project %s", guid)
+		}
+	}
+
+	return problems
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const (
+	lintTestProjectGuid = `{0A1B2C3D-4E5F-4061-8273-94A5B6C7D8E9}`
+	lintTestOtherGuid   = `{11111111-2222-4333-8444-555555555555}`
+	lintTestTypeGuid    = `{8BC9CEB8-8B4A-11D0-8D11-00A0C91BC942}`
+)
+
+var lintTestEmitted = map[string]bool{
+	"mydriver/mydriver.vcxproj": true,
+	"mydriver/mydriver.h":       true,
+	"mydriver/mydriver.c":       true,
+	"directory.build.props":     true,
+}
+
+func TestLintSolutionFile(t *testing.T) {
+	project := `Project("` + lintTestTypeGuid + `") = "MyDriver", "MyDriver\MyDriver.vcxproj", "` + lintTestProjectGuid + `"`
+	good := strings.Join([]string{
+		``,
+		`Microsoft Visual Studio Solution File, Format Version 12.00`,
+		`# Visual Studio Version 16`,
+		`VisualStudioVersion = 16.0.0.0`,
+		project,
+		`EndProject`,
+		`Global`,
+		`	GlobalSection(SolutionConfigurationPlatforms) = preSolution`,
+		`		Debug|x64 = Debug|x64`,
+		`	EndGlobalSection`,
+		`	GlobalSection(ProjectConfigurationPlatforms) = postSolution`,
+		`		` + lintTestProjectGuid + `.Debug|x64.ActiveCfg = Debug|x64`,
+		`	EndGlobalSection`,
+		`EndGlobal`,
+	}, "\r\n")
+
+	tests := []struct {
+		name     string
+		contents string
+		guids    map[string]string
+		want     []string
+	}{
+		{"good", good, map[string]string{"mydriver/mydriver.vcxproj": lintTestProjectGuid}, nil},
+		{"no header", strings.Replace(good, "Microsoft Visual Studio Solution File", "Solution", 1), nil, []string{"missing solution file header"}},
+		{"malformed project", strings.Replace(good, `"MyDriver", `, `"MyDriver" `, 1), nil, []string{"malformed Project line", "configuration for unknown project"}},
+		{"missing project file", strings.Replace(good, `MyDriver\MyDriver.vcxproj`, `Other\Other.vcxproj`, 1), nil, []string{"project Other\\Other.vcxproj is not emitted"}},
+		{"guid mismatch", good, map[string]string{"mydriver/mydriver.vcxproj": lintTestOtherGuid}, []string{"has ProjectGuid " + lintTestOtherGuid}},
+		{"unknown configuration", strings.Replace(good, lintTestProjectGuid+`.Debug`, lintTestOtherGuid+`.Debug`, 1), nil, []string{"configuration for unknown project"}},
+		{"unterminated", strings.TrimSuffix(good, "EndGlobal"), nil, []string{"unterminated block"}},
+		{"stray line", strings.Replace(good, "Global\r\n", "Global\r\nbogus\r\n", 1), nil, []string{`unexpected line "bogus"`}},
+	}
+
+	for _, test := range tests {
+		problems := lintSolutionFile(renderedFile{path: "MyDriver.sln", contents: test.contents}, lintTestEmitted, test.guids)
+		checkLintProblems(t, test.name, problems, test.want)
+	}
+}
+
+func TestLintMSBuildFile(t *testing.T) {
+	good := `<?xml version="1.0" encoding="utf-8"?>
+<Project DefaultTargets="Build" xmlns="http://schemas.microsoft.com/developer/msbuild/2003">
+  <PropertyGroup Label="Globals">
+    <ProjectGuid>` + lintTestProjectGuid + `</ProjectGuid>
+  </PropertyGroup>
+  <Import Project="$(VCTargetsPath)\Microsoft.Cpp.Default.props" />
+  <Import Project="..\Directory.Build.props" />
+  <ItemGroup>
+    <ClInclude Include="MyDriver.h" />
+    <ClCompile Include="MyDriver.c" />
+  </ItemGroup>
+</Project>
+`
+
+	tests := []struct {
+		name     string
+		contents string
+		want     []string
+	}{
+		{"good", good, nil},
+		{"unclosed element", strings.Replace(good, "</ItemGroup>", "", 1), []string{"XML syntax error"}},
+		{"not msbuild", strings.Replace(good, MSBUILD_NAMESPACE, "urn:other", 1), []string{"root element is not an MSBuild <Project>"}},
+		{"missing source", strings.Replace(good, "MyDriver.c", "Missing.c", 1), []string{"ClCompile Missing.c is not emitted"}},
+		{"missing import", strings.Replace(good, `..\Directory.Build.props`, `..\Missing.props`, 1), []string{`Import ..\Missing.props is not emitted`}},
+	}
+
+	for _, test := range tests {
+		guid, problems := lintMSBuildFile(renderedFile{path: "MyDriver/MyDriver.vcxproj", contents: test.contents}, lintTestEmitted)
+		checkLintProblems(t, test.name, problems, test.want)
+		if test.want == nil && guid != lintTestProjectGuid {
+			t.Errorf("%s: ProjectGuid = %q, want %q", test.name, guid, lintTestProjectGuid)
+		}
+	}
+}
+
+// checkLintProblems wants one problem per entry of want, each containing it.
+func checkLintProblems(t *testing.T, name string, problems, want []string) {
+	t.Helper()
+
+	if len(problems) != len(want) {
+		t.Errorf("%s: got problems %q, want %d matching %q", name, problems, len(want), want)
+		return
+	}
+	for i := range want {
+		if !strings.Contains(problems[i], want[i]) {
+			t.Errorf("%s: problem %q does not mention %q", name, problems[i], want[i])
+		}
+	}
+}
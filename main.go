@@ -3,12 +3,35 @@
 // go get golang.org/x/sys/windows/registry
 // go get -u github.com/gonutz/w32
 // go get github.com/google/uuid
+// go get gopkg.in/yaml.v3
 //
 // build:
-// go build -o drivercodegen.exe main.go projecttemplate.go codetemplate.go
+// go build -o drivercodegen.exe .
 //
 // usage : 
 // codetemplate.go -name MyDriver -path d:\codebase
+// drivercodegen.exe -name MyDriver -path d:\codebase -var PoolTag=Cdrv -vars vars.yaml
+// drivercodegen.exe -name MyDriver -path d:\codebase -pack d:\packs\mypack
+// drivercodegen.exe -name MyDriver -path d:\codebase -seed MyDriver
+// drivercodegen.exe -name MyDriver -path d:\codebase -feature client=none -feature inf=true
+// drivercodegen.exe -name MyDriver -path d:\codebase -config drivercodegen.yaml
+// drivercodegen.exe -name MyDriver -path d:\codebase -platforms x64,arm64,arm64ec
+// drivercodegen.exe -name MyDriver -path d:\codebase -profile strict
+// drivercodegen.exe -name MyDriver -path d:\codebase -target-os win11 -feature alloc=pool2
+// drivercodegen.exe -name MyDriver -path d:\codebase -lang c
+// drivercodegen.exe -name MyDriver -path d:\codebase -model kmdf -kmdf-version 1.27 -target-os win10-1809
+// drivercodegen.exe -name MyDriver -path d:\codebase -model umdf2
+// drivercodegen.exe -name MyDriver -path d:\codebase -model minifilter -var PreOperations=CREATE,WRITE -var Altitude=370030
+// drivercodegen.exe -name MyDriver -path d:\codebase -model wfp-callout -var Layers=ALE_AUTH_CONNECT_V4,ALE_AUTH_CONNECT_V6
+// drivercodegen.exe -name MyDriver -path d:\codebase -model ndis-lwf -var FilterClass=diagnostic -feature filtertype=monitoring
+// drivercodegen.exe -name MyDriver -path d:\codebase -model monitor -var QueueDepth=4096
+// drivercodegen.exe -name MyDriver -path d:\codebase -model obcallbacks -var Altitude=321000
+// drivercodegen.exe -name MyDriver -path d:\codebase -model regfilter -var Operations=PreSetValueKey,PostCreateKeyEx
+// drivercodegen.exe -name MyDriver -path d:\codebase -model wdm-pnp
+// drivercodegen.exe -name MyDriver -path d:\codebase -model wdm-filter -attach-to \Device\KeyboardClass0 -var Completions=READ
+// drivercodegen.exe -name MyDriver -path d:\codebase -feature library=export
+// drivercodegen.exe -name MyDriver -path d:\codebase -outdir bin\$(Platform)\$(Configuration) -target-name mydrv
+// drivercodegen.exe templates lint [pack]
 
 package main
 
@@ -32,6 +55,7 @@ const (
 	SUBPATH_DEVENV = `Common7\IDE\devenv.exe`
 	EXE_NAME = `MyApp`
 	COMMON_NAME = `Common`
+	GUID_NAMESPACE = `f55d8155-8b99-4d8d-8344-97657b898a03`
 )
 
 const (
@@ -41,21 +65,31 @@ const (
 	MARK_GUID_SYS = `$GUID_SYS$`
 	MARK_GUID_EXE = `$GUID_EXE$`
 	MARK_GUID_RANDOM = `$GUID_RANDOM$`
+	MARK_GUID_INTERFACE = `$GUID_INTERFACE$`
+	MARK_PROJECTNAME_LIB = `$PROJECTNAME_LIB$`
+	MARK_GUID_LIB = `$GUID_LIB$`
 )
 
 var (
 	solutionName string
 	outputBasePath string
 	outputPath string
-	solutionFilePath string
-	sysVcxprojFilePath string
-	exeVcxprojFilePath string
-	sysVcxprojFilterFilePath string
-	exeVcxprojFilterFilePath string
-	sysHeaderFilePath string
-	sysCppFilePath string
-	exeCppFilePath string
-	commonFilePath string
+	packName string
+	varsFilePath string
+	configFilePath string
+	cliVars = varFlags{}
+	cliFeatures = varFlags{}
+	guidSeed string
+	reproducible bool
+	platformList string
+	profileName string
+	cliOutput outputLayout
+	targetOSName string
+	langName string
+	attachTo string
+	modelName string
+	kmdfVersionFlag string
+	umdfVersionFlag string
 	sysGuid string
 	exeGuid string
 )
@@ -151,7 +185,7 @@ func prepareDirectories() error {
 	outputPath = filepath.Join(outputBasePath, solutionName)
 	if _, err := os.Stat(outputPath); err == nil {
 		log.Printf("[-] %s Already Exsits...\n", outputPath)
-		return err
+		return os.ErrExist
 	}
 
 	if err := os.Mkdir(outputPath, 0755); err != nil {
@@ -159,55 +193,17 @@ func prepareDirectories() error {
 		return err
 	}
 
-	sysPath := filepath.Join(outputPath, solutionName)
-	if _, err := os.Stat(sysPath); err == nil {
-		log.Printf("[-] %s Already Exsits...\n", sysPath)
-		return err
-	}
-
-	if err := os.Mkdir(sysPath, 0755); err != nil {
-		log.Printf("[-] Failed to mkdir %s\n", sysPath)
-		return err
-	}
-
-	exePath := filepath.Join(outputPath, EXE_NAME)
-	if _, err := os.Stat(exePath); err == nil {
-		log.Printf("[-] %s Already Exsits...\n", exePath)
-		return err
-	}
-
-	if err := os.Mkdir(exePath, 0755); err != nil {
-		log.Printf("[-] Failed to mkdir %s\n", exePath)
-		return err
-	}
-
-	commPath := filepath.Join(outputPath, COMMON_NAME)
-	if _, err := os.Stat(commPath); err == nil {
-		log.Printf("[-] %s Already Exsits...\n", commPath)
-		return err
-	}
-
-	if err := os.Mkdir(commPath, 0755); err != nil {
-		log.Printf("[-] Failed to mkdir %s\n", commPath)
-		return err
-	}
-
-	solutionFilePath = filepath.Join(outputPath, solutionName + `.sln`)
-	sysVcxprojFilePath = filepath.Join(sysPath, solutionName + `.vcxproj`)
-	exeVcxprojFilePath = filepath.Join(exePath, EXE_NAME + `.vcxproj`)
-	sysVcxprojFilterFilePath = filepath.Join(sysPath, solutionName + `.vcxproj.filters`)
-	exeVcxprojFilterFilePath = filepath.Join(exePath, EXE_NAME + `.vcxproj.filters`)
-	commonFilePath = filepath.Join(commPath, COMMON_NAME + `.h`)
-
-	sysHeaderFilePath = filepath.Join(sysPath, solutionName + `.h`)
-	sysCppFilePath = filepath.Join(sysPath, solutionName + `.cpp`)
-	exeCppFilePath = filepath.Join(exePath, EXE_NAME + `.cpp`)
-	
 	return nil
 }
 
-func genGuid() string {
-	id, err := uuid.NewUUID()
+// genGuid returns a random GUID, or with -seed a name-based one derived from
+// the seed and key so that identical inputs produce identical output.
+func genGuid(key string) string {
+	if guidSeed != "" {
+		return nameGuid(guidSeed + `/` + key)
+	}
+
+	id, err := uuid.NewRandom()
     if err != nil {
         return ""
 	}
@@ -215,86 +211,62 @@ func genGuid() string {
 	return guid
 }
 
-func makeSolutionFile(vsVersion string) error {
-	sysGuid = genGuid()
-	exeGuid = genGuid()
-	solutionGuid := genGuid()
-
-	contents := replaceContents(SOLUTION_TEMPLATE, MARK_VSVERSION, vsVersion)
-	contents = replaceContents(contents, MARK_PROJECTNAME_SYS, solutionName)
-	contents = replaceContents(contents, MARK_GUID_SOLUTION, solutionGuid)
-	contents = replaceContents(contents, MARK_GUID_SYS, sysGuid)
-	contents = replaceContents(contents, MARK_GUID_EXE, exeGuid)
-
-	if err := makeFile(solutionFilePath, contents); err != nil {
-		return err
-	}
-	return nil
-}
-
-func makeSysVcxprojFile() error {
-	contents := replaceContents(VCXPROJ_SYS_TEMPLATE, MARK_GUID_SYS, sysGuid)
-	contents = replaceContents(contents, MARK_PROJECTNAME_SYS, solutionName)
-	if err := makeFile(sysVcxprojFilePath, contents); err != nil {
-		return err
-	}
-	return nil
-}
-
-func makeExeVcxprojFile() error {
-	contents := replaceContents(VCXPROJ_EXE_TEMPLATE, MARK_GUID_EXE, exeGuid)
-	if err := makeFile(exeVcxprojFilePath, contents); err != nil {
-		return err
-	}
-	return nil
+// nameGuid returns a name-based (v5) GUID, so the same name always maps to
+// the same GUID.
+func nameGuid(name string) string {
+	id := uuid.NewSHA1(uuid.MustParse(GUID_NAMESPACE), []byte(name))
+	guid := fmt.Sprintf(`{%s}`, strings.ToUpper(id.String()))
+	return guid
 }
 
-func makeSysVcxprojFilterFile() error {
-	guid := genGuid()
-	contents := replaceContents(VCXPROJFILTER_SYS_TEMPLATE, MARK_GUID_RANDOM, guid)
-	contents = replaceContents(contents , MARK_PROJECTNAME_SYS, solutionName)
-	if err := makeFile(sysVcxprojFilterFilePath, contents); err != nil {
-		return err
-	}
-	return nil
+func makeMarks(projectName, vsVersion string) map[string]string {
+	sysGuid = genGuid(projectName + `/sys`)
+	exeGuid = genGuid(projectName + `/exe`)
+	solutionGuid := genGuid(projectName + `/solution`)
+
+	marks := map[string]string{
+		MARK_VSVERSION:       vsVersion,
+		MARK_PROJECTNAME_SYS: projectName,
+		MARK_TARGETNAME_SYS:  driverTargetName(projectName),
+		MARK_GUID_SOLUTION:   solutionGuid,
+		MARK_GUID_SYS:        sysGuid,
+		MARK_GUID_EXE:        exeGuid,
+		MARK_GUID_INTERFACE:  genGuid(projectName + `/interface`),
+		MARK_PROJECTNAME_LIB: libraryName(projectName),
+		MARK_GUID_LIB:        genGuid(projectName + `/lib`),
+	}
+	addVcxprojMarks(marks)
+	addSolutionMarks(marks)
+
+	return marks
 }
 
-func makeExeVcxprojFilterFile() error {
-	guid := genGuid()
-	contents := replaceContents(VCXPROJFILTER_EXE_TEMPLATE, MARK_GUID_RANDOM, guid)
-	if err := makeFile(exeVcxprojFilterFilePath, contents); err != nil {
-		return err
-	}
-	return nil
-}
-
-func makeCodeFiles() error {
-	contents_sys_h := replaceContents(DRIVER_HEADER_TEMPLATE, MARK_PROJECTNAME_SYS, solutionName)
-	if err := makeFile(sysHeaderFilePath, contents_sys_h); err != nil {
-		return err
-	}
-
-	contents_sys_cpp := replaceContents(DRIVER_CPP_TEMPLATE, MARK_PROJECTNAME_SYS, solutionName)
-	if err := makeFile(sysCppFilePath, contents_sys_cpp); err != nil {
-		return err
-	}
-
-	contents_exe_cpp := replaceContents(EXE_CPP_TEMPLATE, MARK_PROJECTNAME_SYS, solutionName)
-	if err := makeFile(exeCppFilePath, contents_exe_cpp); err != nil {
-		return err
-	}
-
-	contents_common_h := replaceContents(COMMON_HEADER_TEMPLATE, MARK_PROJECTNAME_SYS, solutionName)
-	if err := makeFile(commonFilePath, contents_common_h); err != nil {
-		return err
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "templates" {
+		os.Exit(runTemplatesCommand(os.Args[2:]))
 	}
 
-	return nil
-}
-
-func main() {
 	flag.StringVar(&solutionName, "name", "", "solution name")
 	flag.StringVar(&outputBasePath, "path", "", "output base path")
+	flag.StringVar(&packName, "pack", DEFAULT_PACK_NAME, "builtin template pack name or pack directory")
+	flag.StringVar(&modelName, "model", "", "driver model, a builtin pack: "+builtinModelNames())
+	flag.StringVar(&varsFilePath, "vars", "", "YAML file with template variables")
+	flag.Var(cliVars, "var", "template variable as Name=Value (repeatable)")
+	flag.Var(cliFeatures, "feature", "template pack feature as name=value (repeatable)")
+	flag.StringVar(&configFilePath, "config", "", "YAML config file")
+	flag.StringVar(&platformList, "platforms", DEFAULT_PLATFORMS, "comma-separated platforms: win32, x64, arm64, arm64ec (app only)")
+	flag.StringVar(&langName, "lang", "", "driver source language: c or cpp (same as -feature lang=...)")
+	flag.StringVar(&attachTo, "attach-to", "", `device a wdm-filter attaches to, e.g. \Device\KeyboardClass0 (same as -var AttachTo=...)`)
+	flag.StringVar(&targetOSName, "target-os", "", "driver target OS: "+targetOSNames()+" (default "+DEFAULT_TARGET_OS+")")
+	flag.StringVar(&kmdfVersionFlag, "kmdf-version", "", "KMDF version such as 1.15, defaults to the one the target OS ships")
+	flag.StringVar(&umdfVersionFlag, "umdf-version", "", "UMDF version such as 2.15, defaults to the one the target OS ships")
+	flag.StringVar(&profileName, "profile", "", "solution-wide build profile: default, strict or legacy")
+	flag.StringVar(&cliOutput.OutDir, "outdir", "", `OutDir pattern relative to the solution, e.g. bin\$(Platform)\$(Configuration)`)
+	flag.StringVar(&cliOutput.IntDir, "intdir", "", "IntDir pattern relative to the solution")
+	flag.StringVar(&cliOutput.DriverTargetName, "target-name", "", "driver TargetName if different from the project name")
+	flag.StringVar(&cliOutput.AppTargetName, "app-target-name", "", "client TargetName if different from the project name")
+	flag.StringVar(&guidSeed, "seed", "", "seed for reproducible name-based GUIDs")
+	flag.BoolVar(&reproducible, "reproducible", false, "use the solution name as -seed")
 	
 	flag.Parse()
 	if solutionName == "" || outputBasePath == "" {
@@ -303,6 +275,28 @@ func main() {
 		return
 	}
 
+	var err error
+	if platforms, err = parsePlatforms(platformList); err != nil {
+		log.Printf("[-] Invalid platforms : %v\n", err)
+		return
+	}
+
+	if modelName != "" {
+		if packName != DEFAULT_PACK_NAME {
+			log.Println("[-] Use either -model or -pack")
+			return
+		}
+		if _, ok := builtinPacks[modelName]; !ok {
+			log.Printf("[-] Unknown model %s, use one of %s\n", modelName, builtinModelNames())
+			return
+		}
+		packName = modelName
+	}
+
+	if reproducible && guidSeed == "" {
+		guidSeed = solutionName
+	}
+
 	vs2019Path := getVisualStudioInstallLocationPath()
 	if vs2019Path == "" {
 		log.Println("[-] Not found Visual Studio 2019....")
@@ -317,39 +311,121 @@ func main() {
 	}
 	log.Println("[+] Visual Studio 2019 Version : ", vsVersion)
 
-	//log.Println(SOLUTION_TEMPLATE)
-	if err := prepareDirectories(); err != nil {
-		log.Println("[-] Failed to prepareDirectories....")
+	pack, err := loadPack(packName)
+	if err != nil {
+		log.Printf("[-] Failed to load template pack %s : %v\n", packName, err)
 		return
 	}
+	log.Println("[+] Template Pack : ", pack.Name)
 
-	if err := makeSolutionFile(vsVersion); err != nil {
-		log.Println("[-] Failed to makeSolutionFile....")
+	config, err := loadConfig(configFilePath)
+	if err != nil {
+		log.Printf("[-] Failed to load config : %v\n", err)
 		return
 	}
 
-	if err := makeSysVcxprojFile(); err != nil {
-		log.Println("[-] Failed to makeSysVcxprojFile....")
+	if configurations, err = buildConfigurations(config.Configurations); err != nil {
+		log.Printf("[-] Invalid configurations : %v\n", err)
 		return
 	}
 
-	if err := makeExeVcxprojFile(); err != nil {
-		log.Println("[-] Failed to makeExeVcxprojFile....")
+	if targetOSName == "" {
+		targetOSName = config.TargetOS
+	}
+	if targetOSName == "" {
+		targetOSName = DEFAULT_TARGET_OS
+	}
+	if driverTarget, err = findTargetOS(targetOSName); err != nil {
+		log.Printf("[-] Invalid target OS : %v\n", err)
 		return
 	}
 
-	if err := makeSysVcxprojFilterFile(); err != nil {
-		log.Println("[-] Failed to makeSysVcxprojFilterFile....")
+	if kmdfVersionFlag == "" {
+		kmdfVersionFlag = config.KMDFVersion
+	}
+	if kmdfMinor, err = resolveWdfVersion("KMDF", 1, kmdfVersionFlag, driverTarget); err != nil {
+		log.Printf("[-] Invalid KMDF version : %v\n", err)
 		return
 	}
 
-	if err := makeExeVcxprojFilterFile(); err != nil {
-		log.Println("[-] Failed to makeExeVcxprojFilterFile....")
+	if umdfVersionFlag == "" {
+		umdfVersionFlag = config.UMDFVersion
+	}
+	if umdfMinor, err = resolveWdfVersion("UMDF", 2, umdfVersionFlag, driverTarget); err != nil {
+		log.Printf("[-] Invalid UMDF version : %v\n", err)
 		return
 	}
 
-	if err := makeCodeFiles(); err != nil {
-		log.Println("[-] Failed to makeSysCodeFile....")
+	if err := resolveProfiles(config.Build, profileName); err != nil {
+		log.Printf("[-] Invalid build profile : %v\n", err)
+		return
+	}
+
+	output = overlayOutput(config.Output, cliOutput)
+	if err := checkOutputLayout(output); err != nil {
+		log.Printf("[-] Invalid output layout : %v\n", err)
+		return
+	}
+
+	fileVars := map[string]string{}
+	for name, value := range config.Vars {
+		fileVars[name] = value
+	}
+	if varsFilePath != "" {
+		vars, err := loadVariableFile(varsFilePath)
+		if err != nil {
+			log.Printf("[-] Failed to load variables : %v\n", err)
+			return
+		}
+		for name, value := range vars {
+			fileVars[name] = value
+		}
+	}
+
+	if attachTo != "" {
+		cliVars["AttachTo"] = attachTo
+	}
+
+	vars, err := resolveVariables(pack, fileVars, cliVars)
+	if err != nil {
+		log.Printf("[-] Invalid variables : %v\n", err)
+		return
+	}
+
+	if langName != "" {
+		cliFeatures["lang"] = langName
+	}
+
+	features, err := resolveFeatures(pack, config.Features, cliFeatures)
+	if err != nil {
+		log.Printf("[-] Invalid features : %v\n", err)
+		return
+	}
+
+	if err := checkTargetOS(pack, features, driverTarget); err != nil {
+		log.Printf("[-] Invalid features : %v\n", err)
+		return
+	}
+
+	if err := applyPackSettings(pack, features); err != nil {
+		log.Printf("[-] Invalid pack settings : %v\n", err)
+		return
+	}
+
+	//log.Println(SOLUTION_TEMPLATE)
+	if err := prepareDirectories(); err != nil {
+		log.Println("[-] Failed to prepareDirectories....")
+		return
+	}
+
+	data := &templateData{
+		ProjectName: solutionName,
+		Vars: vars,
+		Features: features,
+	}
+
+	if err := renderPack(pack, outputPath, makeMarks(solutionName, vsVersion), data); err != nil {
+		log.Printf("[-] Failed to renderPack : %v\n", err)
 		return
 	}
 
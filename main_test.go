@@ -0,0 +1,57 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+var guidRegex = regexp.MustCompile(`^\{[0-9A-F]{8}-[0-9A-F]{4}-5[0-9A-F]{3}-[89AB][0-9A-F]{3}-[0-9A-F]{12}\}$`)
+
+func TestNameGuid(t *testing.T) {
+	// Generated projects keep these GUIDs across runs and releases, so they
+	// must never change for a given name.
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"x", "{A60D07E5-96DC-52C0-A038-46D0D5A904EE}"},
+		{"MyDriver/MyDriver/sys", "{F95C7A06-4D98-5340-9C44-20FB6FAF6FA1}"},
+		{"MyDriver/MyDriver/exe", "{F74D208E-1193-5A2B-A99A-A9AF3E7D0CE7}"},
+	}
+
+	for _, test := range tests {
+		got := nameGuid(test.name)
+		if got != test.want {
+			t.Errorf("nameGuid(%q) = %s, want %s", test.name, got, test.want)
+		}
+		if !guidRegex.MatchString(got) {
+			t.Errorf("nameGuid(%q) = %s is not a braced upper-case v5 GUID", test.name, got)
+		}
+	}
+}
+
+func TestGenGuidSeed(t *testing.T) {
+	defer func(seed string) { guidSeed = seed }(guidSeed)
+
+	guidSeed = "MyDriver"
+	sys := genGuid("MyDriver/sys")
+	if sys != "{F95C7A06-4D98-5340-9C44-20FB6FAF6FA1}" {
+		t.Errorf("genGuid with -seed MyDriver = %s, want the name-based GUID of MyDriver/MyDriver/sys", sys)
+	}
+	if again := genGuid("MyDriver/sys"); again != sys {
+		t.Errorf("genGuid is not stable for a fixed seed: %s, then %s", sys, again)
+	}
+	if exe := genGuid("MyDriver/exe"); exe == sys {
+		t.Errorf("genGuid gives the same GUID %s for different keys", exe)
+	}
+
+	guidSeed = "OtherSeed"
+	if other := genGuid("MyDriver/sys"); other == sys {
+		t.Errorf("genGuid gives the same GUID %s for different seeds", other)
+	}
+
+	guidSeed = ""
+	if first, second := genGuid("MyDriver/sys"), genGuid("MyDriver/sys"); first == second {
+		t.Errorf("genGuid without -seed repeats %s", first)
+	}
+}
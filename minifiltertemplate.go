@@ -0,0 +1,609 @@
+package main
+
+import "strings"
+
+const MINIFILTER_PACK_NAME = `minifilter`
+
+// minifilterOperations are the IRP_MJ_* names PreOperations and
+// PostOperations may list.
+var minifilterOperations = []string{
+	"CREATE", "CREATE_NAMED_PIPE", "CLOSE", "READ", "WRITE",
+	"QUERY_INFORMATION", "SET_INFORMATION", "QUERY_EA", "SET_EA",
+	"FLUSH_BUFFERS", "QUERY_VOLUME_INFORMATION", "SET_VOLUME_INFORMATION",
+	"DIRECTORY_CONTROL", "FILE_SYSTEM_CONTROL", "DEVICE_CONTROL",
+	"INTERNAL_DEVICE_CONTROL", "SHUTDOWN", "LOCK_CONTROL", "CLEANUP",
+	"CREATE_MAILSLOT", "QUERY_SECURITY", "SET_SECURITY",
+	"ACQUIRE_FOR_SECTION_SYNCHRONIZATION", "RELEASE_FOR_SECTION_SYNCHRONIZATION",
+	"NETWORK_QUERY_OPEN", "MDL_READ", "MDL_READ_COMPLETE",
+}
+
+var minifilterOperationsRegex = `^((` + strings.Join(minifilterOperations, "|") + `)(,|$))*$`
+
+var minifilterModel = driverModel{
+	Name:        MINIFILTER_PACK_NAME,
+	Description: "file system minifilter with a communication port and a port client",
+	RequireInf:  true,
+	NoAlloc:     true,
+	Drop:        []string{"DeviceType"},
+	Variables: []packVariable{
+		{Name: "Altitude", Type: VAR_TYPE_STRING, Default: "370030", Regex: `^3[6-8][0-9]{4}(\.[0-9]+)?$`, Description: "instance altitude in the FSFilter Activity Monitor range 360000-389999 the INF installs the filter in"},
+		{Name: "PreOperations", Type: VAR_TYPE_STRING, Default: "CREATE", Regex: minifilterOperationsRegex, Description: "comma-separated IRP_MJ_* names without the prefix that get a pre-operation callback"},
+		{Name: "PostOperations", Type: VAR_TYPE_STRING, Default: "CREATE", Regex: minifilterOperationsRegex, Description: "comma-separated IRP_MJ_* names without the prefix that get a post-operation callback"},
+	},
+	Settings: []packSettings{
+		{Project: SETTINGS_PROJECT_DRIVER, configOverrides: configOverrides{
+			Link: map[string]string{"AdditionalDependencies": "fltMgr.lib;%(AdditionalDependencies)"},
+		}},
+		{Project: SETTINGS_PROJECT_APP, configOverrides: configOverrides{
+			Link: map[string]string{"AdditionalDependencies": "fltLib.lib;%(AdditionalDependencies)"},
+		}},
+	},
+	Header: MINIFILTER_DRIVER_HEADER_TEMPLATE,
+	Source: MINIFILTER_DRIVER_SOURCE_TEMPLATE,
+	Inf:    MINIFILTER_DRIVER_INF_TEMPLATE,
+	Client: MINIFILTER_EXE_CPP_TEMPLATE,
+	Common: MINIFILTER_COMMON_HEADER_TEMPLATE,
+}
+
+const MINIFILTER_DRIVER_HEADER_TEMPLATE =
+`#pragma once
+
+{{ if eq .Features.lang "cpp" -}}
+extern "C"
+{
+{{ end -}}
+#include <fltKernel.h>
+#include "..\Common\Common.h"
+{{- if eq .Features.lang "cpp" }}
+}
+{{- end }}
+{{- if eq .Features.cppruntime "true" }}
+
+#include "KernelCpp.h"
+{{- end }}
+
+#ifndef _FN_
+#define _FN_	__FUNCTION__
+#endif
+
+#ifndef _LN_
+#define _LN_	__LINE__
+#endif
+
+// How long the filter waits for the client to take a notification.
+#define NOTIFY_TIMEOUT_MS	100
+
+
+EXTERN_C DRIVER_INITIALIZE DriverEntry;
+
+NTSTATUS
+FLTAPI
+FilterUnload(
+	IN	FLT_FILTER_UNLOAD_FLAGS		flags
+);
+
+NTSTATUS
+FLTAPI
+InstanceSetup(
+	IN	PCFLT_RELATED_OBJECTS		pFltObjects,
+	IN	FLT_INSTANCE_SETUP_FLAGS	flags,
+	IN	DEVICE_TYPE					volumeDeviceType,
+	IN	FLT_FILESYSTEM_TYPE			volumeFilesystemType
+);
+
+NTSTATUS
+FLTAPI
+InstanceQueryTeardown(
+	IN	PCFLT_RELATED_OBJECTS				pFltObjects,
+	IN	FLT_INSTANCE_QUERY_TEARDOWN_FLAGS	flags
+);
+{{- $pre := list .Vars.PreOperations }}{{ $post := list .Vars.PostOperations }}
+{{- range $pre }}
+
+FLT_PREOP_CALLBACK_STATUS
+FLTAPI
+PreOperation{{ pascal . }}(
+	IN OUT	PFLT_CALLBACK_DATA			pData,
+	IN		PCFLT_RELATED_OBJECTS		pFltObjects,
+	OUT		PVOID*						ppCompletionContext
+);
+{{- end }}
+{{- range $post }}
+
+FLT_POSTOP_CALLBACK_STATUS
+FLTAPI
+PostOperation{{ pascal . }}(
+	IN OUT	PFLT_CALLBACK_DATA			pData,
+	IN		PCFLT_RELATED_OBJECTS		pFltObjects,
+	IN		PVOID						pCompletionContext,
+	IN		FLT_POST_OPERATION_FLAGS	flags
+);
+{{- end }}
+
+NTSTATUS
+FLTAPI
+PortConnect(
+	IN	PFLT_PORT		clientPort,
+	IN	PVOID			pServerPortCookie,
+	IN	PVOID			pConnectionContext,
+	IN	ULONG			sizeOfContext,
+	OUT	PVOID*			ppConnectionCookie
+);
+
+VOID
+FLTAPI
+PortDisconnect(
+	IN	PVOID			pConnectionCookie
+);
+
+NTSTATUS
+FLTAPI
+PortMessage(
+	IN	PVOID			pPortCookie,
+	IN	PVOID			pInputBuffer,
+	IN	ULONG			inputBufferLength,
+	OUT	PVOID			pOutputBuffer,
+	IN	ULONG			outputBufferLength,
+	OUT	PULONG			pReturnOutputBufferLength
+);
+
+void
+NotifyClient(
+	IN	PFLT_CALLBACK_DATA			pData
+);
+`
+
+const MINIFILTER_DRIVER_SOURCE_TEMPLATE =
+`#include "$PROJECTNAME_SYS$.h"
+{{- $pre := list .Vars.PreOperations }}{{ $post := list .Vars.PostOperations }}
+
+
+PFLT_FILTER gFilterHandle = NULL;
+PFLT_PORT gServerPort = NULL;
+PFLT_PORT gClientPort = NULL;
+
+CONST FLT_OPERATION_REGISTRATION Callbacks[] =
+{
+{{- range union $pre $post }}
+	{ IRP_MJ_{{ . }}, 0, {{ if contains $pre . }}PreOperation{{ pascal . }}{{ else }}NULL{{ end }}, {{ if contains $post . }}PostOperation{{ pascal . }}{{ else }}NULL{{ end }} },
+{{- end }}
+	{ IRP_MJ_OPERATION_END }
+};
+
+CONST FLT_REGISTRATION FilterRegistration =
+{
+	sizeof(FLT_REGISTRATION),		// Size
+	FLT_REGISTRATION_VERSION,		// Version
+	0,								// Flags
+	NULL,							// ContextRegistration
+	Callbacks,						// OperationRegistration
+	FilterUnload,					// FilterUnloadCallback
+	InstanceSetup,					// InstanceSetupCallback
+	InstanceQueryTeardown,			// InstanceQueryTeardownCallback
+	NULL,							// InstanceTeardownStartCallback
+	NULL,							// InstanceTeardownCompleteCallback
+	NULL,							// GenerateFileNameCallback
+	NULL,							// NormalizeNameComponentCallback
+	NULL							// NormalizeContextCleanupCallback
+};
+
+
+EXTERN_C
+NTSTATUS
+DriverEntry(
+	IN	PDRIVER_OBJECT		pDriverObject,
+	IN	PUNICODE_STRING		pRegistryPath
+)
+{
+	NTSTATUS status = STATUS_UNSUCCESSFUL;
+	PSECURITY_DESCRIPTOR pSecurityDescriptor = NULL;
+	OBJECT_ATTRIBUTES objectAttributes;
+	UNICODE_STRING portName;
+
+	UNREFERENCED_PARAMETER(pRegistryPath);
+
+	KdPrint(("[%s:%d]\n", _FN_, _LN_));
+
+	status = FltRegisterFilter(pDriverObject, &FilterRegistration, &gFilterHandle);
+	if (!NT_SUCCESS(status))
+	{
+		return status;
+	}
+
+	do
+	{
+		status = FltBuildDefaultSecurityDescriptor(&pSecurityDescriptor, FLT_PORT_ALL_ACCESS);
+		if (!NT_SUCCESS(status))
+		{
+			break;
+		}
+
+		RtlInitUnicodeString(&portName, {{ upperSnake .ProjectName }}_PORT_NAME);
+		InitializeObjectAttributes(&objectAttributes, &portName, OBJ_CASE_INSENSITIVE | OBJ_KERNEL_HANDLE, NULL, pSecurityDescriptor);
+
+		status = FltCreateCommunicationPort(gFilterHandle, &gServerPort, &objectAttributes, NULL, PortConnect, PortDisconnect, PortMessage, 1);
+		FltFreeSecurityDescriptor(pSecurityDescriptor);
+		if (!NT_SUCCESS(status))
+		{
+			break;
+		}
+
+		status = FltStartFiltering(gFilterHandle);
+
+	} while (FALSE);
+
+	if (!NT_SUCCESS(status))
+	{
+		KdPrint(("[%s:%d] failed. status : 0x%X\n", _FN_, _LN_, status));
+
+		if (gServerPort != NULL)
+		{
+			FltCloseCommunicationPort(gServerPort);
+			gServerPort = NULL;
+		}
+
+		FltUnregisterFilter(gFilterHandle);
+		gFilterHandle = NULL;
+	}
+
+	return status;
+}
+
+
+NTSTATUS
+FLTAPI
+FilterUnload(
+	IN	FLT_FILTER_UNLOAD_FLAGS		flags
+)
+{
+	UNREFERENCED_PARAMETER(flags);
+
+	KdPrint(("[%s:%d]\n", _FN_, _LN_));
+
+	FltCloseCommunicationPort(gServerPort);
+	FltUnregisterFilter(gFilterHandle);
+
+	return STATUS_SUCCESS;
+}
+
+
+NTSTATUS
+FLTAPI
+InstanceSetup(
+	IN	PCFLT_RELATED_OBJECTS		pFltObjects,
+	IN	FLT_INSTANCE_SETUP_FLAGS	flags,
+	IN	DEVICE_TYPE					volumeDeviceType,
+	IN	FLT_FILESYSTEM_TYPE			volumeFilesystemType
+)
+{
+	UNREFERENCED_PARAMETER(pFltObjects);
+	UNREFERENCED_PARAMETER(flags);
+	UNREFERENCED_PARAMETER(volumeFilesystemType);
+
+	// Local volumes only.
+	if (volumeDeviceType == FILE_DEVICE_NETWORK_FILE_SYSTEM)
+	{
+		return STATUS_FLT_DO_NOT_ATTACH;
+	}
+
+	return STATUS_SUCCESS;
+}
+
+
+NTSTATUS
+FLTAPI
+InstanceQueryTeardown(
+	IN	PCFLT_RELATED_OBJECTS				pFltObjects,
+	IN	FLT_INSTANCE_QUERY_TEARDOWN_FLAGS	flags
+)
+{
+	UNREFERENCED_PARAMETER(pFltObjects);
+	UNREFERENCED_PARAMETER(flags);
+
+	return STATUS_SUCCESS;
+}
+{{- range $pre }}
+
+
+FLT_PREOP_CALLBACK_STATUS
+FLTAPI
+PreOperation{{ pascal . }}(
+	IN OUT	PFLT_CALLBACK_DATA			pData,
+	IN		PCFLT_RELATED_OBJECTS		pFltObjects,
+	OUT		PVOID*						ppCompletionContext
+)
+{
+	UNREFERENCED_PARAMETER(pFltObjects);
+
+	*ppCompletionContext = NULL;
+
+	NotifyClient(pData);
+
+	return {{ if contains $post . }}FLT_PREOP_SUCCESS_WITH_CALLBACK{{ else }}FLT_PREOP_SUCCESS_NO_CALLBACK{{ end }};
+}
+{{- end }}
+{{- range $post }}
+
+
+FLT_POSTOP_CALLBACK_STATUS
+FLTAPI
+PostOperation{{ pascal . }}(
+	IN OUT	PFLT_CALLBACK_DATA			pData,
+	IN		PCFLT_RELATED_OBJECTS		pFltObjects,
+	IN		PVOID						pCompletionContext,
+	IN		FLT_POST_OPERATION_FLAGS	flags
+)
+{
+	UNREFERENCED_PARAMETER(pData);
+	UNREFERENCED_PARAMETER(pFltObjects);
+	UNREFERENCED_PARAMETER(pCompletionContext);
+	UNREFERENCED_PARAMETER(flags);
+
+	return FLT_POSTOP_FINISHED_PROCESSING;
+}
+{{- end }}
+
+
+NTSTATUS
+FLTAPI
+PortConnect(
+	IN	PFLT_PORT		clientPort,
+	IN	PVOID			pServerPortCookie,
+	IN	PVOID			pConnectionContext,
+	IN	ULONG			sizeOfContext,
+	OUT	PVOID*			ppConnectionCookie
+)
+{
+	UNREFERENCED_PARAMETER(pServerPortCookie);
+	UNREFERENCED_PARAMETER(pConnectionContext);
+	UNREFERENCED_PARAMETER(sizeOfContext);
+
+	KdPrint(("[%s:%d]\n", _FN_, _LN_));
+
+	// The port allows a single connection.
+	gClientPort = clientPort;
+	*ppConnectionCookie = NULL;
+
+	return STATUS_SUCCESS;
+}
+
+
+VOID
+FLTAPI
+PortDisconnect(
+	IN	PVOID			pConnectionCookie
+)
+{
+	UNREFERENCED_PARAMETER(pConnectionCookie);
+
+	KdPrint(("[%s:%d]\n", _FN_, _LN_));
+
+	FltCloseClientPort(gFilterHandle, &gClientPort);
+}
+
+
+NTSTATUS
+FLTAPI
+PortMessage(
+	IN	PVOID			pPortCookie,
+	IN	PVOID			pInputBuffer,
+	IN	ULONG			inputBufferLength,
+	OUT	PVOID			pOutputBuffer,
+	IN	ULONG			outputBufferLength,
+	OUT	PULONG			pReturnOutputBufferLength
+)
+{
+	{{ upperSnake .ProjectName }}_MESSAGE message = { 0 };
+
+	UNREFERENCED_PARAMETER(pPortCookie);
+	UNREFERENCED_PARAMETER(pOutputBuffer);
+	UNREFERENCED_PARAMETER(outputBufferLength);
+
+	*pReturnOutputBufferLength = 0;
+
+	if (pInputBuffer == NULL || inputBufferLength != sizeof(message))
+	{
+		return STATUS_INVALID_PARAMETER;
+	}
+
+	// The buffer is the client's user-mode memory.
+	__try
+	{
+		RtlCopyMemory(&message, pInputBuffer, sizeof(message));
+	}
+	__except (EXCEPTION_EXECUTE_HANDLER)
+	{
+		return GetExceptionCode();
+	}
+
+	KdPrint(("[%s:%d] command : %u\n", _FN_, _LN_, message.command));
+
+	return STATUS_SUCCESS;
+}
+
+
+void
+NotifyClient(
+	IN	PFLT_CALLBACK_DATA			pData
+)
+{
+	{{ upperSnake .ProjectName }}_NOTIFICATION notification = { 0 };
+	LARGE_INTEGER timeout;
+
+	// FltSendMessage may block, keep it away from paging I/O and raised IRQL.
+	if (gClientPort == NULL || KeGetCurrentIrql() != PASSIVE_LEVEL || FlagOn(pData->Iopb->IrpFlags, IRP_PAGING_IO))
+	{
+		return;
+	}
+
+	notification.totalSize = sizeof(notification);
+	notification.processId = FltGetRequestorProcessId(pData);
+	notification.majorFunction = pData->Iopb->MajorFunction;
+
+	timeout.QuadPart = -10000LL * NOTIFY_TIMEOUT_MS;
+	FltSendMessage(gFilterHandle, &gClientPort, &notification, sizeof(notification), NULL, NULL, &timeout);
+}
+`
+
+const MINIFILTER_DRIVER_INF_TEMPLATE =
+`;
+; $PROJECTNAME_SYS$.inf
+;
+; Installs the $PROJECTNAME_SYS$ minifilter.
+;   rundll32 setupapi.dll,InstallHinfSection DefaultInstall 132 $PROJECTNAME_SYS$.inf
+;   fltmc load $PROJECTNAME_SYS$
+;
+; Class and LoadOrderGroup are those of the FSFilter Activity Monitor
+; altitude range, 360000-389999, so Instance1.Altitude must stay in it.
+;
+
+[Version]
+Signature   = "$WINDOWS NT$"
+Class       = "ActivityMonitor"
+ClassGuid   = {b86dff51-a31e-4bac-b3cf-e8cfe75c9fc2}
+Provider    = %ManufacturerName%
+DriverVer   =
+CatalogFile = $PROJECTNAME_SYS$.cat
+PnpLockdown = 1
+
+[DestinationDirs]
+DefaultDestDir         = 12
+MiniFilter.DriverFiles = 12
+
+[SourceDisksNames]
+1 = %DiskName%,,,""
+
+[SourceDisksFiles]
+$TARGETNAME_SYS$.sys = 1,,
+
+[DefaultInstall.NT$ARCH$]
+OptionDesc = %ServiceDescription%
+CopyFiles  = MiniFilter.DriverFiles
+
+[DefaultInstall.NT$ARCH$.Services]
+AddService = %ServiceName%,,MiniFilter.Service
+
+[DefaultUninstall.NT$ARCH$]
+LegacyUninstall = 1
+DelFiles        = MiniFilter.DriverFiles
+
+[DefaultUninstall.NT$ARCH$.Services]
+DelService = %ServiceName%,0x200
+
+[MiniFilter.Service]
+DisplayName    = %ServiceName%
+Description    = %ServiceDescription%
+ServiceBinary  = %12%\$TARGETNAME_SYS$.sys
+Dependencies   = "FltMgr"
+ServiceType    = 2               ; SERVICE_FILE_SYSTEM_DRIVER
+StartType      = 3               ; SERVICE_DEMAND_START
+ErrorControl   = 1               ; SERVICE_ERROR_NORMAL
+LoadOrderGroup = "FSFilter Activity Monitor"
+AddReg         = MiniFilter.AddRegistry
+
+[MiniFilter.AddRegistry]
+HKR,,"DebugFlags",0x00010001,0x0
+HKR,,"SupportedFeatures",0x00010001,0x3
+HKR,"Instances","DefaultInstance",0x00000000,%DefaultInstance%
+HKR,"Instances\"%Instance1.Name%,"Altitude",0x00000000,%Instance1.Altitude%
+HKR,"Instances\"%Instance1.Name%,"Flags",0x00010001,%Instance1.Flags%
+
+[MiniFilter.DriverFiles]
+$TARGETNAME_SYS$.sys
+
+[Strings]
+ManufacturerName   = "{{ or .Vars.Company .ProjectName }}"
+DiskName           = "$PROJECTNAME_SYS$ Installation Disk"
+ServiceName        = "$PROJECTNAME_SYS$"
+ServiceDescription = "$PROJECTNAME_SYS$ Mini-Filter Driver"
+DefaultInstance    = "$PROJECTNAME_SYS$ Instance"
+Instance1.Name     = "$PROJECTNAME_SYS$ Instance"
+Instance1.Altitude = "{{ .Vars.Altitude }}"
+Instance1.Flags    = 0x0
+`
+
+const MINIFILTER_COMMON_HEADER_TEMPLATE =
+`#pragma once
+
+#define {{ upperSnake .ProjectName }}_PORT_NAME		L"\\{{ pascal .ProjectName }}Port"
+
+#pragma pack (push, 1)
+
+// Client -> filter, FilterSendMessage.
+typedef struct _{{ upperSnake .ProjectName }}_MESSAGE
+{
+    ULONG               totalSize;
+    ULONG               command;
+
+} {{ upperSnake .ProjectName }}_MESSAGE, *P{{ upperSnake .ProjectName }}_MESSAGE;
+
+// Filter -> client, FilterGetMessage.
+typedef struct _{{ upperSnake .ProjectName }}_NOTIFICATION
+{
+    ULONG               totalSize;
+    ULONG               processId;
+    ULONG               majorFunction;
+
+} {{ upperSnake .ProjectName }}_NOTIFICATION, *P{{ upperSnake .ProjectName }}_NOTIFICATION;
+
+#pragma pack (pop)
+`
+
+const MINIFILTER_EXE_CPP_TEMPLATE =
+`#include <iostream>
+#include <Windows.h>
+#include <fltUser.h>
+#include "../Common/Common.h"
+
+#define NOTIFICATION_COUNT	10
+
+typedef struct _NOTIFICATION_BUFFER
+{
+	FILTER_MESSAGE_HEADER header;
+	{{ upperSnake .ProjectName }}_NOTIFICATION notification;
+
+} NOTIFICATION_BUFFER;
+
+int main()
+{
+	HANDLE port = INVALID_HANDLE_VALUE;
+
+	HRESULT hr = FilterConnectCommunicationPort({{ upperSnake .ProjectName }}_PORT_NAME, 0, nullptr, 0, nullptr, &port);
+	if (FAILED(hr))
+	{
+		std::wcerr << L"FilterConnectCommunicationPort failed. hr : 0x" << std::hex << hr << std::endl;
+		return 1;
+	}
+
+	do
+	{
+		DWORD returned = 0;
+		{{ upperSnake .ProjectName }}_MESSAGE message = { 0 };
+		message.totalSize = sizeof(message);
+
+		hr = FilterSendMessage(port, &message, sizeof(message), nullptr, 0, &returned);
+		if (FAILED(hr))
+		{
+			break;
+		}
+
+		for (int i = 0; i < NOTIFICATION_COUNT; ++i)
+		{
+			NOTIFICATION_BUFFER buffer = { 0 };
+
+			hr = FilterGetMessage(port, &buffer.header, sizeof(buffer), nullptr);
+			if (FAILED(hr))
+			{
+				break;
+			}
+
+			std::wcout << L"pid " << buffer.notification.processId << L" major function " << buffer.notification.majorFunction << std::endl;
+		}
+
+	} while (false);
+
+	CloseHandle(port);
+
+	return 0;
+}
+`
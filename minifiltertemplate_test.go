@@ -0,0 +1,47 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMinifilterLint(t *testing.T) {
+	pack := builtinPacks[MINIFILTER_PACK_NAME]
+	vars := map[string]string{"Altitude": "385100", "PreOperations": "CREATE,WRITE,CLEANUP", "PostOperations": "READ"}
+
+	for _, problem := range lintPack(pack, vars) {
+		t.Error(problem)
+	}
+
+	files := renderTestPack(t, pack, vars, map[string]string{"lang": "c"})
+	if !strings.Contains(files[LINT_SAMPLE_NAME+"/"+LINT_SAMPLE_NAME+".inf"], `Instance1.Altitude = "385100"`) {
+		t.Errorf("the INF does not install the filter at Altitude")
+	}
+	if strings.Contains(files[LINT_SAMPLE_NAME+"/"+LINT_SAMPLE_NAME+".h"], "ALLOC_NONPAGED") {
+		t.Errorf("the minifilter allocates nothing, but its header defines ALLOC_NONPAGED")
+	}
+}
+
+func TestMinifilterAltitude(t *testing.T) {
+	pack := builtinPacks[MINIFILTER_PACK_NAME]
+
+	tests := []struct {
+		altitude string
+		valid    bool
+	}{
+		{"360000", true},
+		{"370030", true},
+		{"389999.5", true},
+		{"359999", false},
+		{"390000", false},
+		{"320000", false},
+		{"3700300", false},
+	}
+
+	for _, test := range tests {
+		_, err := resolveVariables(pack, nil, map[string]string{"Altitude": test.altitude})
+		if (err == nil) != test.valid {
+			t.Errorf("Altitude=%s: error %v, want valid %v", test.altitude, err, test.valid)
+		}
+	}
+}
@@ -0,0 +1,585 @@
+package main
+
+const MONITOR_PACK_NAME = `monitor`
+
+var monitorModel = driverModel{
+	Name:        MONITOR_PACK_NAME,
+	Description: "process, thread and image-load notify routines queueing events for a client that drains them",
+	Variables: []packVariable{
+		{Name: "QueueDepth", Type: VAR_TYPE_INT, Default: "1024", Min: 1, Max: 4096, Description: "events kept until the client drains them, the oldest are dropped beyond it, at most 4096 to bound the nonpaged pool the queue holds"},
+	},
+	Settings: []packSettings{integrityCheckSettings, wdmsecSettings},
+	Header:   MONITOR_DRIVER_HEADER_TEMPLATE,
+	Source:   MONITOR_DRIVER_SOURCE_TEMPLATE,
+	Inf:      DRIVER_INF_TEMPLATE,
+	Client:   MONITOR_EXE_CPP_TEMPLATE,
+	Common:   MONITOR_COMMON_HEADER_TEMPLATE,
+}
+
+const MONITOR_DRIVER_HEADER_TEMPLATE =
+`#pragma once
+
+#ifndef NTSTRSAFE_LIB
+#define NTSTRSAFE_LIB
+#endif
+
+{{ if eq .Features.lang "cpp" -}}
+extern "C"
+{
+{{ end -}}
+#include <ntddk.h>
+#include <wdm.h>
+#include <wdmsec.h>
+#include <ntstrsafe.h>
+#include "..\Common\Common.h"
+{{- if eq .Features.lang "cpp" }}
+}
+{{- end }}
+{{- if eq .Features.cppruntime "true" }}
+
+#include "KernelCpp.h"
+{{- end }}
+
+#ifndef _FN_
+#define _FN_	__FUNCTION__
+#endif
+
+#ifndef _LN_
+#define _LN_	__LINE__
+#endif
+
+` + POOL_HEADER_TEMPLATE + `
+#define MAX_QUEUED_EVENTS		{{ .Vars.QueueDepth }}
+
+typedef struct _EVENT_ENTRY
+{
+	LIST_ENTRY			entry;
+	{{ upperSnake .ProjectName }}_EVENT		event;
+
+} EVENT_ENTRY, *PEVENT_ENTRY;
+` + CONTROL_DEVICE_HEADER_TEMPLATE + `
+NTSTATUS
+RegisterNotifyRoutines(
+);
+
+void
+UnregisterNotifyRoutines(
+);
+
+void
+ProcessNotifyRoutine(
+	IN OUT		PEPROCESS				pProcess,
+	IN			HANDLE					processId,
+	IN OUT OPTIONAL	PPS_CREATE_NOTIFY_INFO	pCreateInfo
+);
+
+void
+ThreadNotifyRoutine(
+	IN	HANDLE				processId,
+	IN	HANDLE				threadId,
+	IN	BOOLEAN				create
+);
+
+void
+LoadImageNotifyRoutine(
+	IN OPTIONAL	PUNICODE_STRING		pFullImageName,
+	IN			HANDLE				processId,
+	IN			PIMAGE_INFO			pImageInfo
+);
+
+PEVENT_ENTRY
+AllocateEvent(
+	IN	ULONG				type,
+	IN	HANDLE				processId
+);
+
+void
+CopyImageName(
+	OUT	{{ upperSnake .ProjectName }}_EVENT*	pEvent,
+	IN	PCUNICODE_STRING	pImageName
+);
+
+void
+PushEvent(
+	IN	PEVENT_ENTRY		pEntry
+);
+
+void
+FreeEvents(
+	IN	PLIST_ENTRY			pList
+);
+`
+
+const MONITOR_DRIVER_SOURCE_TEMPLATE =
+`#include "$PROJECTNAME_SYS$.h"
+{{- $name := upperSnake .ProjectName }}
+
+
+// Events wait here until the client drains them with IOCTL_{{ $name }}_GET_EVENTS.
+LIST_ENTRY gEventList;
+KSPIN_LOCK gEventLock;
+ULONG gEventCount = 0;
+ULONG gDroppedCount = 0;
+
+BOOLEAN gProcessNotifyRegistered = FALSE;
+BOOLEAN gThreadNotifyRegistered = FALSE;
+BOOLEAN gImageNotifyRegistered = FALSE;
+
+
+EXTERN_C
+NTSTATUS
+DriverEntry(
+	IN	PDRIVER_OBJECT		pDriverObject,
+	IN	PUNICODE_STRING		pRegistryPath
+)
+{
+	NTSTATUS status = STATUS_UNSUCCESSFUL;
+
+	UNREFERENCED_PARAMETER(pRegistryPath);
+
+	KdPrint(("[%s:%d]\n", _FN_, _LN_));
+
+	InitializeListHead(&gEventList);
+	KeInitializeSpinLock(&gEventLock);
+
+	do
+	{
+		// Only administrators may drain the events of every user.
+		status = CreateControlDevice(pDriverObject);
+		if (!NT_SUCCESS(status))
+		{
+			break;
+		}
+
+		pDriverObject->DriverUnload = UnloadRoutine;
+
+		status = RegisterNotifyRoutines();
+
+	} while (FALSE);
+
+	if (!NT_SUCCESS(status))
+	{
+		KdPrint(("[%s:%d] failed. status : 0x%X\n", _FN_, _LN_, status));
+
+		UnregisterNotifyRoutines();
+		DeleteControlDevice(pDriverObject);
+	}
+
+	return status;
+}
+
+
+void
+UnloadRoutine(
+	IN	PDRIVER_OBJECT		pDriverObject
+)
+{
+	KdPrint(("[%s:%d] dropped events : %u\n", _FN_, _LN_, gDroppedCount));
+
+	// No routine runs after this, so the queue can go.
+	UnregisterNotifyRoutines();
+	FreeEvents(&gEventList);
+
+	DeleteControlDevice(pDriverObject);
+
+	return;
+}
+
+
+NTSTATUS
+RegisterNotifyRoutines(
+)
+{
+	NTSTATUS status = STATUS_UNSUCCESSFUL;
+
+	// STATUS_ACCESS_DENIED here means the driver was linked without /INTEGRITYCHECK.
+	status = PsSetCreateProcessNotifyRoutineEx(ProcessNotifyRoutine, FALSE);
+	if (!NT_SUCCESS(status))
+	{
+		return status;
+	}
+	gProcessNotifyRegistered = TRUE;
+
+	status = PsSetCreateThreadNotifyRoutine(ThreadNotifyRoutine);
+	if (!NT_SUCCESS(status))
+	{
+		return status;
+	}
+	gThreadNotifyRegistered = TRUE;
+
+	status = PsSetLoadImageNotifyRoutine(LoadImageNotifyRoutine);
+	if (!NT_SUCCESS(status))
+	{
+		return status;
+	}
+	gImageNotifyRegistered = TRUE;
+
+	return STATUS_SUCCESS;
+}
+
+
+void
+UnregisterNotifyRoutines(
+)
+{
+	if (gImageNotifyRegistered)
+	{
+		PsRemoveLoadImageNotifyRoutine(LoadImageNotifyRoutine);
+		gImageNotifyRegistered = FALSE;
+	}
+
+	if (gThreadNotifyRegistered)
+	{
+		PsRemoveCreateThreadNotifyRoutine(ThreadNotifyRoutine);
+		gThreadNotifyRegistered = FALSE;
+	}
+
+	if (gProcessNotifyRegistered)
+	{
+		PsSetCreateProcessNotifyRoutineEx(ProcessNotifyRoutine, TRUE);
+		gProcessNotifyRegistered = FALSE;
+	}
+}
+
+
+void
+ProcessNotifyRoutine(
+	IN OUT		PEPROCESS				pProcess,
+	IN			HANDLE					processId,
+	IN OUT OPTIONAL	PPS_CREATE_NOTIFY_INFO	pCreateInfo
+)
+{
+	PEVENT_ENTRY pEntry = NULL;
+
+	UNREFERENCED_PARAMETER(pProcess);
+
+	pEntry = AllocateEvent(pCreateInfo != NULL ? {{ $name }}_EVENT_PROCESS_CREATE : {{ $name }}_EVENT_PROCESS_EXIT, processId);
+	if (pEntry == NULL)
+	{
+		return;
+	}
+
+	if (pCreateInfo != NULL)
+	{
+		pEntry->event.parentProcessId = HandleToULong(pCreateInfo->ParentProcessId);
+		if (pCreateInfo->ImageFileName != NULL)
+		{
+			CopyImageName(&pEntry->event, pCreateInfo->ImageFileName);
+		}
+	}
+
+	PushEvent(pEntry);
+}
+
+
+void
+ThreadNotifyRoutine(
+	IN	HANDLE				processId,
+	IN	HANDLE				threadId,
+	IN	BOOLEAN				create
+)
+{
+	PEVENT_ENTRY pEntry = NULL;
+
+	pEntry = AllocateEvent(create ? {{ $name }}_EVENT_THREAD_CREATE : {{ $name }}_EVENT_THREAD_EXIT, processId);
+	if (pEntry == NULL)
+	{
+		return;
+	}
+
+	pEntry->event.threadId = HandleToULong(threadId);
+
+	PushEvent(pEntry);
+}
+
+
+void
+LoadImageNotifyRoutine(
+	IN OPTIONAL	PUNICODE_STRING		pFullImageName,
+	IN			HANDLE				processId,
+	IN			PIMAGE_INFO			pImageInfo
+)
+{
+	PEVENT_ENTRY pEntry = NULL;
+
+	pEntry = AllocateEvent({{ $name }}_EVENT_IMAGE_LOAD, processId);
+	if (pEntry == NULL)
+	{
+		return;
+	}
+
+	pEntry->event.imageBase = (ULONGLONG)(ULONG_PTR)pImageInfo->ImageBase;
+	if (pFullImageName != NULL)
+	{
+		CopyImageName(&pEntry->event, pFullImageName);
+	}
+
+	PushEvent(pEntry);
+}
+
+
+PEVENT_ENTRY
+AllocateEvent(
+	IN	ULONG				type,
+	IN	HANDLE				processId
+)
+{
+	LARGE_INTEGER time;
+	PEVENT_ENTRY pEntry = (PEVENT_ENTRY)ALLOC_NONPAGED(sizeof(EVENT_ENTRY));
+	if (pEntry == NULL)
+	{
+		return NULL;
+	}
+
+	RtlZeroMemory(pEntry, sizeof(EVENT_ENTRY));
+	KeQuerySystemTimePrecise(&time);
+
+	pEntry->event.type = type;
+	pEntry->event.processId = HandleToULong(processId);
+	pEntry->event.timestamp = time.QuadPart;
+
+	return pEntry;
+}
+
+
+// CopyImageName keeps the tail of names that don't fit, it tells more than
+// the head.
+void
+CopyImageName(
+	OUT	{{ $name }}_EVENT*	pEvent,
+	IN	PCUNICODE_STRING	pImageName
+)
+{
+	size_t length = pImageName->Length / sizeof(WCHAR);
+	size_t skip = 0;
+
+	if (length >= {{ $name }}_IMAGE_NAME_LENGTH)
+	{
+		skip = length - ({{ $name }}_IMAGE_NAME_LENGTH - 1);
+	}
+
+	RtlStringCchCopyNW(pEvent->imageName, {{ $name }}_IMAGE_NAME_LENGTH, pImageName->Buffer + skip, length - skip);
+}
+
+
+void
+PushEvent(
+	IN	PEVENT_ENTRY		pEntry
+)
+{
+	KIRQL oldIrql;
+	PLIST_ENTRY pDropped = NULL;
+
+	KeAcquireSpinLock(&gEventLock, &oldIrql);
+
+	if (gEventCount >= MAX_QUEUED_EVENTS)
+	{
+		pDropped = RemoveHeadList(&gEventList);
+		--gEventCount;
+		++gDroppedCount;
+	}
+
+	InsertTailList(&gEventList, &pEntry->entry);
+	++gEventCount;
+
+	KeReleaseSpinLock(&gEventLock, oldIrql);
+
+	if (pDropped != NULL)
+	{
+		FREE_POOL(CONTAINING_RECORD(pDropped, EVENT_ENTRY, entry));
+	}
+}
+
+
+void
+FreeEvents(
+	IN	PLIST_ENTRY			pList
+)
+{
+	while (!IsListEmpty(pList))
+	{
+		FREE_POOL(CONTAINING_RECORD(RemoveHeadList(pList), EVENT_ENTRY, entry));
+	}
+}
+` + CONTROL_DEVICE_SOURCE_TEMPLATE + `
+
+NTSTATUS
+DeviceIoControlRoutine(
+	IN	PDEVICE_OBJECT		pDeviceObject,
+	IN	PIRP				pIrp
+)
+{
+	NTSTATUS status = STATUS_UNSUCCESSFUL;
+	ULONG_PTR information = 0;
+
+	PIO_STACK_LOCATION pStack = IoGetCurrentIrpStackLocation(pIrp);
+	ULONG ioCtlCode = pStack->Parameters.DeviceIoControl.IoControlCode;
+	ULONG outSize = pStack->Parameters.DeviceIoControl.OutputBufferLength;
+	PVOID pOutBuffer = pIrp->AssociatedIrp.SystemBuffer;
+
+	UNREFERENCED_PARAMETER(pDeviceObject);
+
+	switch (ioCtlCode)
+	{
+	case IOCTL_{{ $name }}_GET_EVENTS:
+	{
+		{{ $name }}_EVENT* pEvents = ({{ $name }}_EVENT*)pOutBuffer;
+		ULONG capacity = outSize / sizeof({{ $name }}_EVENT);
+		ULONG count = 0;
+		LIST_ENTRY drained;
+		KIRQL oldIrql;
+
+		if (pOutBuffer == NULL || capacity == 0)
+		{
+			status = STATUS_BUFFER_TOO_SMALL;
+			break;
+		}
+
+		// Take the events off the queue first, the copy needs no lock.
+		InitializeListHead(&drained);
+
+		KeAcquireSpinLock(&gEventLock, &oldIrql);
+		while (count < capacity && !IsListEmpty(&gEventList))
+		{
+			InsertTailList(&drained, RemoveHeadList(&gEventList));
+			--gEventCount;
+			++count;
+		}
+		KeReleaseSpinLock(&gEventLock, oldIrql);
+
+		for (count = 0; !IsListEmpty(&drained); ++count)
+		{
+			PEVENT_ENTRY pEntry = CONTAINING_RECORD(RemoveHeadList(&drained), EVENT_ENTRY, entry);
+			pEvents[count] = pEntry->event;
+			FREE_POOL(pEntry);
+		}
+
+		information = count * sizeof({{ $name }}_EVENT);
+		status = STATUS_SUCCESS;
+		break;
+	}
+	default:
+		status = STATUS_INVALID_DEVICE_REQUEST;
+		information = 0;
+		break;
+	}
+
+	pIrp->IoStatus.Status = status;
+	pIrp->IoStatus.Information = information;
+	IoCompleteRequest(pIrp, IO_NO_INCREMENT);
+
+	return status;
+}
+`
+
+const MONITOR_COMMON_HEADER_TEMPLATE =
+`#pragma once
+{{- $name := upperSnake .ProjectName }}
+
+// Output buffer is an array of {{ $name }}_EVENT, filled with as many queued
+// events as fit.
+#define IOCTL_{{ $name }}_GET_EVENTS		CTL_CODE({{ .Vars.DeviceType }}, {{ ctlFunction 0 }}, METHOD_BUFFERED, FILE_READ_ACCESS)
+
+#define {{ $name }}_EVENT_PROCESS_CREATE	1
+#define {{ $name }}_EVENT_PROCESS_EXIT		2
+#define {{ $name }}_EVENT_THREAD_CREATE		3
+#define {{ $name }}_EVENT_THREAD_EXIT		4
+#define {{ $name }}_EVENT_IMAGE_LOAD		5
+
+#define {{ $name }}_IMAGE_NAME_LENGTH		260
+
+#pragma pack (push, 1)
+
+typedef struct _{{ $name }}_EVENT
+{
+    ULONG               type;
+    ULONG               processId;
+    ULONG               parentProcessId;    // PROCESS_CREATE
+    ULONG               threadId;           // THREAD_CREATE, THREAD_EXIT
+    LONGLONG            timestamp;          // system time, 100ns units since 1601
+    ULONGLONG           imageBase;          // IMAGE_LOAD
+    WCHAR               imageName[{{ $name }}_IMAGE_NAME_LENGTH];    // PROCESS_CREATE, IMAGE_LOAD
+
+} {{ $name }}_EVENT, *P{{ $name }}_EVENT;
+
+#pragma pack (pop)
+`
+
+const MONITOR_EXE_CPP_TEMPLATE =
+`#include <iostream>
+#include <vector>
+#include <Windows.h>
+#include "../Common/Common.h"
+{{- $name := upperSnake .ProjectName }}
+
+#define EVENT_BATCH		64
+#define POLL_INTERVAL_MS	500
+
+const wchar_t* EventName(ULONG type)
+{
+	switch (type)
+	{
+	case {{ $name }}_EVENT_PROCESS_CREATE:	return L"process create";
+	case {{ $name }}_EVENT_PROCESS_EXIT:	return L"process exit";
+	case {{ $name }}_EVENT_THREAD_CREATE:	return L"thread create";
+	case {{ $name }}_EVENT_THREAD_EXIT:		return L"thread exit";
+	case {{ $name }}_EVENT_IMAGE_LOAD:		return L"image load";
+	default:								return L"unknown";
+	}
+}
+
+// Prints events until the driver goes away or Ctrl+C.
+int main()
+{
+	HANDLE deviceHandle = INVALID_HANDLE_VALUE;
+	std::vector<{{ $name }}_EVENT> events(EVENT_BATCH);
+
+	do
+	{
+		deviceHandle = CreateFile(L"\\\\.\\$PROJECTNAME_SYS$", GENERIC_READ, 0, nullptr, OPEN_EXISTING, FILE_ATTRIBUTE_NORMAL, nullptr);
+		if (deviceHandle == INVALID_HANDLE_VALUE)
+		{
+			break;
+		}
+
+		for (;;)
+		{
+			DWORD retSize = 0;
+			if (!DeviceIoControl(deviceHandle, IOCTL_{{ $name }}_GET_EVENTS, nullptr, 0, events.data(), (DWORD)(events.size() * sizeof({{ $name }}_EVENT)), &retSize, nullptr))
+			{
+				break;
+			}
+
+			DWORD count = retSize / sizeof({{ $name }}_EVENT);
+			for (DWORD i = 0; i < count; ++i)
+			{
+				const {{ $name }}_EVENT& event = events[i];
+				std::wcout << EventName(event.type) << L" pid " << event.processId;
+				if (event.type == {{ $name }}_EVENT_THREAD_CREATE || event.type == {{ $name }}_EVENT_THREAD_EXIT)
+				{
+					std::wcout << L" tid " << event.threadId;
+				}
+				if (event.imageName[0] != L'\0')
+				{
+					std::wcout << L" " << event.imageName;
+				}
+				std::wcout << std::endl;
+			}
+
+			if (count < events.size())
+			{
+				Sleep(POLL_INTERVAL_MS);
+			}
+		}
+
+	} while (false);
+
+	if (deviceHandle != INVALID_HANDLE_VALUE)
+	{
+		CloseHandle(deviceHandle);
+		deviceHandle = INVALID_HANDLE_VALUE;
+	}
+
+	return 0;
+}
+`
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMonitorLint(t *testing.T) {
+	pack := builtinPacks[MONITOR_PACK_NAME]
+	vars := map[string]string{"QueueDepth": "4096", "DeviceType": "FILE_DEVICE_NETWORK", "PoolTag": "Mntr"}
+
+	for _, problem := range lintPack(pack, vars) {
+		t.Error(problem)
+	}
+
+	for _, depth := range []string{"0", "4097"} {
+		if _, err := resolveVariables(pack, nil, map[string]string{"QueueDepth": depth}); err == nil || !strings.Contains(err.Error(), "is not between 1 and 4096") {
+			t.Errorf("QueueDepth=%s: error %v", depth, err)
+		}
+	}
+
+	files := renderTestPack(t, pack, vars, map[string]string{"alloc": "pool2"})
+	project := files[LINT_SAMPLE_NAME+"/"+LINT_SAMPLE_NAME+".vcxproj"]
+	for _, text := range []string{"wdmsec.lib;", "/INTEGRITYCHECK"} {
+		if !strings.Contains(project, text) {
+			t.Errorf("the driver project lacks %s", text)
+		}
+	}
+
+	header := files[LINT_SAMPLE_NAME+"/"+LINT_SAMPLE_NAME+".h"]
+	if !strings.Contains(header, "ExAllocatePool2(") || !strings.Contains(header, "#define MAX_QUEUED_EVENTS\t\t4096") {
+		t.Errorf("the driver header lacks the pool2 allocator or the queue depth:\n%s", header)
+	}
+	if !strings.Contains(files[LINT_SAMPLE_NAME+"/"+LINT_SAMPLE_NAME+".cpp"], "IoCreateDeviceSecure(") {
+		t.Errorf("the control device is not created with IoCreateDeviceSecure")
+	}
+}
@@ -0,0 +1,595 @@
+package main
+
+const NDIS_LWF_PACK_NAME = `ndis-lwf`
+
+var ndisLwfModel = driverModel{
+	Name:        NDIS_LWF_PACK_NAME,
+	Description: "NDIS 6.30 lightweight filter passing packets and OID requests through, with a control device",
+	RequireInf:  true,
+	Variables: []packVariable{
+		{Name: "FilterClass", Type: VAR_TYPE_STRING, Default: "custom", Regex: `^(scheduler|encryption|compression|vpn|loadbalance|failover|diagnostic|custom|provider_address)$`, Description: "FilterClass in the INF, orders the filter in the stack"},
+		{Name: "ComponentId", Type: VAR_TYPE_STRING, Regex: `^[A-Za-z0-9_]+$`, Description: "component ID netcfg installs the filter by, defaults to the project name"},
+	},
+	Features: []packFeature{
+		{Name: "filtertype", Default: "modifying", Values: []string{"modifying", "monitoring"}, Description: "FilterType in the INF, monitoring filters may not change or originate packets"},
+	},
+	Settings: []packSettings{
+		{Project: SETTINGS_PROJECT_DRIVER, configOverrides: configOverrides{
+			Defines: []string{"NDIS_WDM=1", "NDIS630=1"},
+			Link:    map[string]string{"AdditionalDependencies": "ndis.lib;%(AdditionalDependencies)"},
+		}},
+	},
+	Header: NDIS_LWF_DRIVER_HEADER_TEMPLATE,
+	Source: NDIS_LWF_DRIVER_SOURCE_TEMPLATE,
+	Inf:    NDIS_LWF_DRIVER_INF_TEMPLATE,
+	Client: EXE_CPP_TEMPLATE,
+}
+
+const NDIS_LWF_DRIVER_HEADER_TEMPLATE =
+`#pragma once
+
+{{ if eq .Features.lang "cpp" -}}
+extern "C"
+{
+{{ end -}}
+#include <ndis.h>
+#include "..\Common\Common.h"
+{{- if eq .Features.lang "cpp" }}
+}
+{{- end }}
+{{- if eq .Features.cppruntime "true" }}
+
+#include "KernelCpp.h"
+{{- end }}
+
+#ifndef _FN_
+#define _FN_	__FUNCTION__
+#endif
+
+#ifndef _LN_
+#define _LN_	__LINE__
+#endif
+
+#define DEVICE_NAME		L"\\Device\\$PROJECTNAME_SYS$"
+#define DOS_DEVICE_NAME	L"\\DosDevices\\$PROJECTNAME_SYS$"
+
+// UniqueName must match NetCfgInstanceId in the INF.
+#define FILTER_FRIENDLY_NAME	L"$PROJECTNAME_SYS$ NDIS Filter"
+#define FILTER_UNIQUE_NAME		L"$GUID_INTERFACE$"
+#define FILTER_SERVICE_NAME		L"$PROJECTNAME_SYS$"
+
+` + POOL_HEADER_TEMPLATE + `
+// Data path handlers may already run at DISPATCH_LEVEL.
+#define FILTER_ACQUIRE_LOCK(pLock, dispatchLevel)	\
+	do { if (dispatchLevel) { NdisDprAcquireSpinLock(pLock); } else { NdisAcquireSpinLock(pLock); } } while (FALSE)
+
+#define FILTER_RELEASE_LOCK(pLock, dispatchLevel)	\
+	do { if (dispatchLevel) { NdisDprReleaseSpinLock(pLock); } else { NdisReleaseSpinLock(pLock); } } while (FALSE)
+
+typedef enum _FILTER_STATE
+{
+	FilterPaused,
+	FilterRunning
+
+} FILTER_STATE;
+
+// One per filter module, that is per adapter the filter is attached to.
+typedef struct _FILTER_CONTEXT
+{
+	NDIS_HANDLE			filterHandle;
+	NET_IFINDEX			miniportIfIndex;
+	FILTER_STATE		state;
+	NDIS_SPIN_LOCK		lock;
+
+} FILTER_CONTEXT, *PFILTER_CONTEXT;
+
+
+EXTERN_C DRIVER_INITIALIZE DriverEntry;
+
+FILTER_ATTACH FilterAttach;
+FILTER_DETACH FilterDetach;
+FILTER_RESTART FilterRestart;
+FILTER_PAUSE FilterPause;
+FILTER_SEND_NET_BUFFER_LISTS FilterSendNetBufferLists;
+FILTER_SEND_NET_BUFFER_LISTS_COMPLETE FilterSendNetBufferListsComplete;
+FILTER_RECEIVE_NET_BUFFER_LISTS FilterReceiveNetBufferLists;
+FILTER_RETURN_NET_BUFFER_LISTS FilterReturnNetBufferLists;
+FILTER_OID_REQUEST FilterOidRequest;
+FILTER_OID_REQUEST_COMPLETE FilterOidRequestComplete;
+
+void
+UnloadRoutine(
+	IN	PDRIVER_OBJECT		pDriverObject
+);
+
+NDIS_STATUS
+RegisterDevice(
+);
+
+NTSTATUS
+PassRoutine(
+	IN	PDEVICE_OBJECT		pDeviceObject,
+	IN	PIRP				pIrp
+);
+
+NTSTATUS
+DeviceIoControlRoutine(
+	IN	PDEVICE_OBJECT		pDeviceObject,
+	IN	PIRP				pIrp
+);
+`
+
+const NDIS_LWF_DRIVER_SOURCE_TEMPLATE =
+`#include "$PROJECTNAME_SYS$.h"
+
+
+NDIS_HANDLE gFilterDriverHandle = NULL;
+NDIS_HANDLE gDeviceHandle = NULL;
+PDEVICE_OBJECT gDeviceObject = NULL;
+
+
+EXTERN_C
+NTSTATUS
+DriverEntry(
+	IN	PDRIVER_OBJECT		pDriverObject,
+	IN	PUNICODE_STRING		pRegistryPath
+)
+{
+	NDIS_STATUS status = NDIS_STATUS_FAILURE;
+	NDIS_FILTER_DRIVER_CHARACTERISTICS characteristics;
+	NDIS_STRING friendlyName = RTL_CONSTANT_STRING(FILTER_FRIENDLY_NAME);
+	NDIS_STRING uniqueName = RTL_CONSTANT_STRING(FILTER_UNIQUE_NAME);
+	NDIS_STRING serviceName = RTL_CONSTANT_STRING(FILTER_SERVICE_NAME);
+
+	UNREFERENCED_PARAMETER(pRegistryPath);
+
+	KdPrint(("[%s:%d]\n", _FN_, _LN_));
+
+	NdisZeroMemory(&characteristics, sizeof(characteristics));
+	characteristics.Header.Type = NDIS_OBJECT_TYPE_FILTER_DRIVER_CHARACTERISTICS;
+	characteristics.Header.Size = NDIS_SIZEOF_FILTER_DRIVER_CHARACTERISTICS_REVISION_2;
+	characteristics.Header.Revision = NDIS_FILTER_CHARACTERISTICS_REVISION_2;
+	characteristics.MajorNdisVersion = NDIS_FILTER_MAJOR_VERSION;
+	characteristics.MinorNdisVersion = NDIS_FILTER_MINOR_VERSION;
+	characteristics.MajorDriverVersion = 1;
+	characteristics.MinorDriverVersion = 0;
+	characteristics.FriendlyName = friendlyName;
+	characteristics.UniqueName = uniqueName;
+	characteristics.ServiceName = serviceName;
+
+	characteristics.AttachHandler = FilterAttach;
+	characteristics.DetachHandler = FilterDetach;
+	characteristics.RestartHandler = FilterRestart;
+	characteristics.PauseHandler = FilterPause;
+	characteristics.SendNetBufferListsHandler = FilterSendNetBufferLists;
+	characteristics.SendNetBufferListsCompleteHandler = FilterSendNetBufferListsComplete;
+	characteristics.ReceiveNetBufferListsHandler = FilterReceiveNetBufferLists;
+	characteristics.ReturnNetBufferListsHandler = FilterReturnNetBufferLists;
+	characteristics.OidRequestHandler = FilterOidRequest;
+	characteristics.OidRequestCompleteHandler = FilterOidRequestComplete;
+
+	pDriverObject->DriverUnload = UnloadRoutine;
+
+	status = NdisFRegisterFilterDriver(pDriverObject, (NDIS_HANDLE)pDriverObject, &characteristics, &gFilterDriverHandle);
+	if (status != NDIS_STATUS_SUCCESS)
+	{
+		KdPrint(("[%s:%d] NdisFRegisterFilterDriver failed. status : 0x%X\n", _FN_, _LN_, status));
+		return status;
+	}
+
+	status = RegisterDevice();
+	if (status != NDIS_STATUS_SUCCESS)
+	{
+		NdisFDeregisterFilterDriver(gFilterDriverHandle);
+		gFilterDriverHandle = NULL;
+	}
+
+	return status;
+}
+
+
+void
+UnloadRoutine(
+	IN	PDRIVER_OBJECT		pDriverObject
+)
+{
+	UNREFERENCED_PARAMETER(pDriverObject);
+
+	KdPrint(("[%s:%d]\n", _FN_, _LN_));
+
+	if (gDeviceHandle != NULL)
+	{
+		NdisDeregisterDeviceEx(gDeviceHandle);
+		gDeviceHandle = NULL;
+	}
+
+	NdisFDeregisterFilterDriver(gFilterDriverHandle);
+	gFilterDriverHandle = NULL;
+
+	return;
+}
+
+
+// RegisterDevice creates the control device MyApp opens. NDIS dispatches its
+// IRPs, the device object of the driver belongs to NDIS.
+NDIS_STATUS
+RegisterDevice(
+)
+{
+	NDIS_DEVICE_OBJECT_ATTRIBUTES attributes;
+	PDRIVER_DISPATCH dispatchTable[IRP_MJ_MAXIMUM_FUNCTION + 1];
+	UNICODE_STRING deviceName = RTL_CONSTANT_STRING(DEVICE_NAME);
+	UNICODE_STRING symbolicLinkName = RTL_CONSTANT_STRING(DOS_DEVICE_NAME);
+
+	NdisZeroMemory(dispatchTable, sizeof(dispatchTable));
+	dispatchTable[IRP_MJ_CREATE] = PassRoutine;
+	dispatchTable[IRP_MJ_CLEANUP] = PassRoutine;
+	dispatchTable[IRP_MJ_CLOSE] = PassRoutine;
+	dispatchTable[IRP_MJ_DEVICE_CONTROL] = DeviceIoControlRoutine;
+
+	NdisZeroMemory(&attributes, sizeof(attributes));
+	attributes.Header.Type = NDIS_OBJECT_TYPE_DEVICE_OBJECT_ATTRIBUTES;
+	attributes.Header.Revision = NDIS_DEVICE_OBJECT_ATTRIBUTES_REVISION_1;
+	attributes.Header.Size = sizeof(NDIS_DEVICE_OBJECT_ATTRIBUTES);
+	attributes.DeviceName = &deviceName;
+	attributes.SymbolicName = &symbolicLinkName;
+	attributes.MajorFunctions = &dispatchTable[0];
+
+	return NdisRegisterDeviceEx(gFilterDriverHandle, &attributes, &gDeviceObject, &gDeviceHandle);
+}
+
+
+NDIS_STATUS
+FilterAttach(
+	IN	NDIS_HANDLE						ndisFilterHandle,
+	IN	NDIS_HANDLE						filterDriverContext,
+	IN	PNDIS_FILTER_ATTACH_PARAMETERS	pAttachParameters
+)
+{
+	NDIS_STATUS status = NDIS_STATUS_FAILURE;
+	NDIS_FILTER_ATTRIBUTES attributes;
+	PFILTER_CONTEXT pFilter = NULL;
+
+	UNREFERENCED_PARAMETER(filterDriverContext);
+
+	KdPrint(("[%s:%d] ifIndex : %u\n", _FN_, _LN_, pAttachParameters->BaseMiniportIfIndex));
+
+	// FilterMediaTypes in the INF.
+	if (pAttachParameters->MiniportMediaType != NdisMedium802_3)
+	{
+		return NDIS_STATUS_INVALID_PARAMETER;
+	}
+
+	pFilter = (PFILTER_CONTEXT)ALLOC_NONPAGED(sizeof(FILTER_CONTEXT));
+	if (pFilter == NULL)
+	{
+		return NDIS_STATUS_RESOURCES;
+	}
+
+	NdisZeroMemory(pFilter, sizeof(FILTER_CONTEXT));
+	pFilter->filterHandle = ndisFilterHandle;
+	pFilter->miniportIfIndex = pAttachParameters->BaseMiniportIfIndex;
+	pFilter->state = FilterPaused;
+	NdisAllocateSpinLock(&pFilter->lock);
+
+	NdisZeroMemory(&attributes, sizeof(attributes));
+	attributes.Header.Type = NDIS_OBJECT_TYPE_FILTER_ATTRIBUTES;
+	attributes.Header.Revision = NDIS_FILTER_ATTRIBUTES_REVISION_1;
+	attributes.Header.Size = sizeof(NDIS_FILTER_ATTRIBUTES);
+
+	status = NdisFSetAttributes(ndisFilterHandle, pFilter, &attributes);
+	if (status != NDIS_STATUS_SUCCESS)
+	{
+		NdisFreeSpinLock(&pFilter->lock);
+		FREE_POOL(pFilter);
+	}
+
+	return status;
+}
+
+
+void
+FilterDetach(
+	IN	NDIS_HANDLE		filterModuleContext
+)
+{
+	PFILTER_CONTEXT pFilter = (PFILTER_CONTEXT)filterModuleContext;
+
+	KdPrint(("[%s:%d] ifIndex : %u\n", _FN_, _LN_, pFilter->miniportIfIndex));
+
+	NdisFreeSpinLock(&pFilter->lock);
+	FREE_POOL(pFilter);
+}
+
+
+NDIS_STATUS
+FilterRestart(
+	IN	NDIS_HANDLE						filterModuleContext,
+	IN	PNDIS_FILTER_RESTART_PARAMETERS	pRestartParameters
+)
+{
+	PFILTER_CONTEXT pFilter = (PFILTER_CONTEXT)filterModuleContext;
+
+	UNREFERENCED_PARAMETER(pRestartParameters);
+
+	NdisAcquireSpinLock(&pFilter->lock);
+	pFilter->state = FilterRunning;
+	NdisReleaseSpinLock(&pFilter->lock);
+
+	return NDIS_STATUS_SUCCESS;
+}
+
+
+NDIS_STATUS
+FilterPause(
+	IN	NDIS_HANDLE						filterModuleContext,
+	IN	PNDIS_FILTER_PAUSE_PARAMETERS	pPauseParameters
+)
+{
+	PFILTER_CONTEXT pFilter = (PFILTER_CONTEXT)filterModuleContext;
+
+	UNREFERENCED_PARAMETER(pPauseParameters);
+
+	// The filter originates no packets, so it has nothing outstanding to wait
+	// for and pauses at once.
+	NdisAcquireSpinLock(&pFilter->lock);
+	pFilter->state = FilterPaused;
+	NdisReleaseSpinLock(&pFilter->lock);
+
+	return NDIS_STATUS_SUCCESS;
+}
+
+
+void
+FilterSendNetBufferLists(
+	IN	NDIS_HANDLE			filterModuleContext,
+	IN	PNET_BUFFER_LIST	pNetBufferLists,
+	IN	NDIS_PORT_NUMBER	portNumber,
+	IN	ULONG				sendFlags
+)
+{
+	PFILTER_CONTEXT pFilter = (PFILTER_CONTEXT)filterModuleContext;
+	BOOLEAN dispatchLevel = NDIS_TEST_SEND_AT_DISPATCH_LEVEL(sendFlags);
+	FILTER_STATE state = FilterPaused;
+	PNET_BUFFER_LIST pCurrent = NULL;
+	ULONG completeFlags = 0;
+
+	FILTER_ACQUIRE_LOCK(&pFilter->lock, dispatchLevel);
+	state = pFilter->state;
+	FILTER_RELEASE_LOCK(&pFilter->lock, dispatchLevel);
+
+	if (state != FilterRunning)
+	{
+		for (pCurrent = pNetBufferLists; pCurrent != NULL; pCurrent = NET_BUFFER_LIST_NEXT_NBL(pCurrent))
+		{
+			NET_BUFFER_LIST_STATUS(pCurrent) = NDIS_STATUS_PAUSED;
+		}
+
+		if (dispatchLevel)
+		{
+			NDIS_SET_SEND_COMPLETE_FLAG(completeFlags, NDIS_SEND_COMPLETE_FLAGS_DISPATCH_LEVEL);
+		}
+
+		NdisFSendNetBufferListsComplete(pFilter->filterHandle, pNetBufferLists, completeFlags);
+		return;
+	}
+
+	NdisFSendNetBufferLists(pFilter->filterHandle, pNetBufferLists, portNumber, sendFlags);
+}
+
+
+void
+FilterSendNetBufferListsComplete(
+	IN	NDIS_HANDLE			filterModuleContext,
+	IN	PNET_BUFFER_LIST	pNetBufferLists,
+	IN	ULONG				sendCompleteFlags
+)
+{
+	PFILTER_CONTEXT pFilter = (PFILTER_CONTEXT)filterModuleContext;
+
+	NdisFSendNetBufferListsComplete(pFilter->filterHandle, pNetBufferLists, sendCompleteFlags);
+}
+
+
+void
+FilterReceiveNetBufferLists(
+	IN	NDIS_HANDLE			filterModuleContext,
+	IN	PNET_BUFFER_LIST	pNetBufferLists,
+	IN	NDIS_PORT_NUMBER	portNumber,
+	IN	ULONG				numberOfNetBufferLists,
+	IN	ULONG				receiveFlags
+)
+{
+	PFILTER_CONTEXT pFilter = (PFILTER_CONTEXT)filterModuleContext;
+	BOOLEAN dispatchLevel = NDIS_TEST_RECEIVE_AT_DISPATCH_LEVEL(receiveFlags);
+	FILTER_STATE state = FilterPaused;
+	ULONG returnFlags = 0;
+
+	FILTER_ACQUIRE_LOCK(&pFilter->lock, dispatchLevel);
+	state = pFilter->state;
+	FILTER_RELEASE_LOCK(&pFilter->lock, dispatchLevel);
+
+	if (state != FilterRunning)
+	{
+		// With NDIS_RECEIVE_FLAGS_RESOURCES the lists stay with the miniport.
+		if (NDIS_TEST_RECEIVE_CAN_PEND(receiveFlags))
+		{
+			if (dispatchLevel)
+			{
+				NDIS_SET_RETURN_FLAG(returnFlags, NDIS_RETURN_FLAGS_DISPATCH_LEVEL);
+			}
+
+			NdisFReturnNetBufferLists(pFilter->filterHandle, pNetBufferLists, returnFlags);
+		}
+		return;
+	}
+
+	NdisFIndicateReceiveNetBufferLists(pFilter->filterHandle, pNetBufferLists, portNumber, numberOfNetBufferLists, receiveFlags);
+}
+
+
+void
+FilterReturnNetBufferLists(
+	IN	NDIS_HANDLE			filterModuleContext,
+	IN	PNET_BUFFER_LIST	pNetBufferLists,
+	IN	ULONG				returnFlags
+)
+{
+	PFILTER_CONTEXT pFilter = (PFILTER_CONTEXT)filterModuleContext;
+
+	NdisFReturnNetBufferLists(pFilter->filterHandle, pNetBufferLists, returnFlags);
+}
+
+
+// FilterOidRequest passes a clone of the request down, the original is
+// completed from FilterOidRequestComplete.
+NDIS_STATUS
+FilterOidRequest(
+	IN	NDIS_HANDLE			filterModuleContext,
+	IN	PNDIS_OID_REQUEST	pOidRequest
+)
+{
+	PFILTER_CONTEXT pFilter = (PFILTER_CONTEXT)filterModuleContext;
+	NDIS_STATUS status = NDIS_STATUS_FAILURE;
+	PNDIS_OID_REQUEST pClonedRequest = NULL;
+
+	KdPrint(("[%s:%d] type : %d, oid : 0x%X\n", _FN_, _LN_, pOidRequest->RequestType, pOidRequest->DATA.QUERY_INFORMATION.Oid));
+
+	status = NdisAllocateCloneOidRequest(pFilter->filterHandle, pOidRequest, TAG_NAME, &pClonedRequest);
+	if (status != NDIS_STATUS_SUCCESS)
+	{
+		return status;
+	}
+
+	*(PNDIS_OID_REQUEST*)pClonedRequest->SourceReserved = pOidRequest;
+	pClonedRequest->RequestId = pOidRequest->RequestId;
+
+	status = NdisFOidRequest(pFilter->filterHandle, pClonedRequest);
+	if (status != NDIS_STATUS_PENDING)
+	{
+		FilterOidRequestComplete(pFilter, pClonedRequest, status);
+		status = NDIS_STATUS_PENDING;
+	}
+
+	return status;
+}
+
+
+void
+FilterOidRequestComplete(
+	IN	NDIS_HANDLE			filterModuleContext,
+	IN	PNDIS_OID_REQUEST	pOidRequest,
+	IN	NDIS_STATUS			status
+)
+{
+	PFILTER_CONTEXT pFilter = (PFILTER_CONTEXT)filterModuleContext;
+	PNDIS_OID_REQUEST pOriginalRequest = *(PNDIS_OID_REQUEST*)pOidRequest->SourceReserved;
+
+	// Copy the results of the clone back to the original request.
+	switch (pOidRequest->RequestType)
+	{
+	case NdisRequestMethod:
+		pOriginalRequest->DATA.METHOD_INFORMATION.OutputBufferLength = pOidRequest->DATA.METHOD_INFORMATION.OutputBufferLength;
+		pOriginalRequest->DATA.METHOD_INFORMATION.BytesRead = pOidRequest->DATA.METHOD_INFORMATION.BytesRead;
+		pOriginalRequest->DATA.METHOD_INFORMATION.BytesNeeded = pOidRequest->DATA.METHOD_INFORMATION.BytesNeeded;
+		pOriginalRequest->DATA.METHOD_INFORMATION.BytesWritten = pOidRequest->DATA.METHOD_INFORMATION.BytesWritten;
+		break;
+	case NdisRequestSetInformation:
+		pOriginalRequest->DATA.SET_INFORMATION.BytesRead = pOidRequest->DATA.SET_INFORMATION.BytesRead;
+		pOriginalRequest->DATA.SET_INFORMATION.BytesNeeded = pOidRequest->DATA.SET_INFORMATION.BytesNeeded;
+		break;
+	default:
+		pOriginalRequest->DATA.QUERY_INFORMATION.BytesWritten = pOidRequest->DATA.QUERY_INFORMATION.BytesWritten;
+		pOriginalRequest->DATA.QUERY_INFORMATION.BytesNeeded = pOidRequest->DATA.QUERY_INFORMATION.BytesNeeded;
+		break;
+	}
+
+	NdisFreeCloneOidRequest(pFilter->filterHandle, pOidRequest);
+	NdisFOidRequestComplete(pFilter->filterHandle, pOriginalRequest, status);
+}
+` + DRIVER_DISPATCH_TEMPLATE
+
+const NDIS_LWF_DRIVER_INF_TEMPLATE =
+`;
+; $PROJECTNAME_SYS$.inf
+;
+; Installs $TARGETNAME_SYS$.sys as an NDIS lightweight filter.
+;   netcfg -v -l $PROJECTNAME_SYS$.inf -c s -i {{ or .Vars.ComponentId .ProjectName }}
+; and removes it again with
+;   netcfg -v -u {{ or .Vars.ComponentId .ProjectName }}
+;
+
+[Version]
+Signature   = "$WINDOWS NT$"
+Class       = NetService
+ClassGuid   = {4d36e974-e325-11ce-bfc1-08002be10318}
+Provider    = %ManufacturerName%
+DriverVer   =
+CatalogFile = $PROJECTNAME_SYS$.cat
+PnpLockdown = 1
+
+[Manufacturer]
+%ManufacturerName% = Standard,NT$ARCH$
+
+[Standard.NT$ARCH$]
+%FilterDesc% = Filter_Install, {{ or .Vars.ComponentId .ProjectName }}
+
+[SourceDisksNames]
+1 = %DiskName%,,,""
+
+[SourceDisksFiles]
+$TARGETNAME_SYS$.sys = 1,,
+
+[DestinationDirs]
+Filter_CopyFiles = 12
+
+; NetCfgInstanceId is the UniqueName the driver registers with.
+[Filter_Install]
+AddReg           = Filter_AddReg
+Characteristics  = 0x40000       ; NCF_LW_FILTER
+NetCfgInstanceId = "$GUID_INTERFACE$"
+CopyFiles        = Filter_CopyFiles
+
+[Filter_CopyFiles]
+$TARGETNAME_SYS$.sys,,,2
+
+[Filter_AddReg]
+HKR, Ndi,                 Service,          0x00000000, "$PROJECTNAME_SYS$"
+HKR, Ndi,                 CoServices,       0x00010000, "$PROJECTNAME_SYS$"
+HKR, Ndi,                 HelpText,         0x00000000, %FilterDesc%
+HKR, Ndi,                 FilterClass,      0x00000000, {{ .Vars.FilterClass }}
+{{- if eq .Features.filtertype "monitoring" }}
+HKR, Ndi,                 FilterType,       0x00010001, 1    ; monitoring
+{{- else }}
+HKR, Ndi,                 FilterType,       0x00010001, 2    ; modifying
+{{- end }}
+HKR, Ndi,                 FilterRunType,    0x00010001, 2    ; optional, the stack binds without it
+HKR, Ndi\Interfaces,      UpperRange,       0x00000000, "noupper"
+HKR, Ndi\Interfaces,      LowerRange,       0x00000000, "nolower"
+HKR, Ndi\Interfaces,      FilterMediaTypes, 0x00000000, "ethernet"
+
+[Filter_Install.Services]
+AddService = $PROJECTNAME_SYS$,,Service_Install
+
+[Service_Install]
+DisplayName    = %ServiceName%
+Description    = %FilterDesc%
+ServiceType    = 1               ; SERVICE_KERNEL_DRIVER
+StartType      = 1               ; SERVICE_SYSTEM_START
+ErrorControl   = 1               ; SERVICE_ERROR_NORMAL
+ServiceBinary  = %12%\$TARGETNAME_SYS$.sys
+LoadOrderGroup = NDIS
+AddReg         = Service_AddReg
+
+[Service_AddReg]
+HKR, Parameters, NdisImPlatformBindingOptions, 0x00010001, 0
+
+[Filter_Install.Remove.Services]
+DelService = $PROJECTNAME_SYS$,0x200
+
+[Strings]
+ManufacturerName = "{{ or .Vars.Company .ProjectName }}"
+DiskName         = "$PROJECTNAME_SYS$ Installation Disk"
+ServiceName      = "$PROJECTNAME_SYS$ Service"
+FilterDesc       = "$PROJECTNAME_SYS$ NDIS Filter"
+`
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNdisLwfLint(t *testing.T) {
+	pack := builtinPacks[NDIS_LWF_PACK_NAME]
+	vars := map[string]string{"FilterClass": "diagnostic", "ComponentId": "Contoso_Lwf", "PoolTag": "Nlwf"}
+
+	for _, problem := range lintPack(pack, vars) {
+		t.Error(problem)
+	}
+
+	files := renderTestPack(t, pack, vars, map[string]string{"lang": "c", "filtertype": "monitoring"})
+	inf := files[LINT_SAMPLE_NAME+"/"+LINT_SAMPLE_NAME+".inf"]
+	for _, text := range []string{"Filter_Install, Contoso_Lwf", "FilterClass,      0x00000000, diagnostic", "FilterType,       0x00010001, 1"} {
+		if !strings.Contains(inf, text) {
+			t.Errorf("the INF lacks %q", text)
+		}
+	}
+
+	header := files[LINT_SAMPLE_NAME+"/"+LINT_SAMPLE_NAME+".h"]
+	for _, text := range []string{"#define TAG_NAME        'fwlN'", "#define ALLOC_NONPAGED(size)", "#define FREE_POOL(p)"} {
+		if !strings.Contains(header, text) {
+			t.Errorf("the header lacks %q", text)
+		}
+	}
+}
@@ -0,0 +1,575 @@
+package main
+
+const OBCALLBACKS_PACK_NAME = `obcallbacks`
+
+var obCallbacksModel = driverModel{
+	Name:        OBCALLBACKS_PACK_NAME,
+	Description: "ObRegisterCallbacks handle protection for a PID list the client manages",
+	NoAlloc:     true,
+	Variables: []packVariable{
+		{Name: "Altitude", Type: VAR_TYPE_STRING, Default: "321000", Regex: `^[1-9][0-9]{4,5}(\.[0-9]+)?$`, Description: "altitude of the object callbacks, unique among the loaded drivers"},
+		{Name: "MaxProtected", Type: VAR_TYPE_INT, Default: "64", Min: 1, Max: 1024, Description: "number of processes that can be protected at once, at most 1024 since every handle open scans the list"},
+	},
+	Settings: []packSettings{integrityCheckSettings, wdmsecSettings},
+	Header:   OBCALLBACKS_DRIVER_HEADER_TEMPLATE,
+	Source:   OBCALLBACKS_DRIVER_SOURCE_TEMPLATE,
+	Inf:      DRIVER_INF_TEMPLATE,
+	Client:   OBCALLBACKS_EXE_CPP_TEMPLATE,
+	Common:   OBCALLBACKS_COMMON_HEADER_TEMPLATE,
+}
+
+const OBCALLBACKS_DRIVER_HEADER_TEMPLATE =
+`#pragma once
+
+{{ if eq .Features.lang "cpp" -}}
+extern "C"
+{
+{{ end -}}
+#include <ntddk.h>
+#include <wdm.h>
+#include <wdmsec.h>
+#include "..\Common\Common.h"
+{{- if eq .Features.lang "cpp" }}
+}
+{{- end }}
+{{- if eq .Features.cppruntime "true" }}
+
+#include "KernelCpp.h"
+{{- end }}
+
+#ifndef _FN_
+#define _FN_	__FUNCTION__
+#endif
+
+#ifndef _LN_
+#define _LN_	__LINE__
+#endif
+
+#define CALLBACK_ALTITUDE		L"{{ .Vars.Altitude }}"
+#define MAX_PROTECTED_PROCESSES	{{ .Vars.MaxProtected }}
+
+// The kernel headers leave out most process and thread access rights.
+#ifndef PROCESS_TERMINATE
+#define PROCESS_TERMINATE		0x0001
+#endif
+#ifndef PROCESS_CREATE_THREAD
+#define PROCESS_CREATE_THREAD	0x0002
+#endif
+#ifndef PROCESS_VM_OPERATION
+#define PROCESS_VM_OPERATION	0x0008
+#endif
+#ifndef PROCESS_VM_WRITE
+#define PROCESS_VM_WRITE		0x0020
+#endif
+#ifndef PROCESS_SUSPEND_RESUME
+#define PROCESS_SUSPEND_RESUME	0x0800
+#endif
+#ifndef THREAD_TERMINATE
+#define THREAD_TERMINATE		0x0001
+#endif
+#ifndef THREAD_SUSPEND_RESUME
+#define THREAD_SUSPEND_RESUME	0x0002
+#endif
+#ifndef THREAD_SET_CONTEXT
+#define THREAD_SET_CONTEXT		0x0010
+#endif
+
+// Rights stripped from handles other processes open to a protected one.
+#define PROCESS_DENIED_ACCESS	(PROCESS_TERMINATE | PROCESS_CREATE_THREAD | PROCESS_VM_OPERATION | PROCESS_VM_WRITE | PROCESS_SUSPEND_RESUME)
+#define THREAD_DENIED_ACCESS	(THREAD_TERMINATE | THREAD_SUSPEND_RESUME | THREAD_SET_CONTEXT)
+` + CONTROL_DEVICE_HEADER_TEMPLATE + `
+NTSTATUS
+RegisterObCallbacks(
+);
+
+void
+ProcessNotifyRoutine(
+	IN OUT		PEPROCESS				pProcess,
+	IN			HANDLE					processId,
+	IN OUT OPTIONAL	PPS_CREATE_NOTIFY_INFO	pCreateInfo
+);
+
+OB_PREOP_CALLBACK_STATUS
+PreProcessHandleOperation(
+	IN	PVOID							pRegistrationContext,
+	IN	POB_PRE_OPERATION_INFORMATION	pOperationInformation
+);
+
+void
+PostProcessHandleOperation(
+	IN	PVOID							pRegistrationContext,
+	IN	POB_POST_OPERATION_INFORMATION	pOperationInformation
+);
+
+OB_PREOP_CALLBACK_STATUS
+PreThreadHandleOperation(
+	IN	PVOID							pRegistrationContext,
+	IN	POB_PRE_OPERATION_INFORMATION	pOperationInformation
+);
+
+void
+PostThreadHandleOperation(
+	IN	PVOID							pRegistrationContext,
+	IN	POB_POST_OPERATION_INFORMATION	pOperationInformation
+);
+
+void
+StripAccess(
+	IN OUT	POB_PRE_OPERATION_INFORMATION	pOperationInformation,
+	IN		ACCESS_MASK						deniedAccess
+);
+
+BOOLEAN
+IsProtectedProcess(
+	IN	HANDLE				processId
+);
+
+NTSTATUS
+ProtectProcess(
+	IN	ULONG				processId
+);
+
+NTSTATUS
+UnprotectProcess(
+	IN	ULONG				processId
+);
+`
+
+const OBCALLBACKS_DRIVER_SOURCE_TEMPLATE =
+`#include "$PROJECTNAME_SYS$.h"
+{{- $name := upperSnake .ProjectName }}
+
+
+PVOID gRegistrationHandle = NULL;
+BOOLEAN gProcessNotifyRegistered = FALSE;
+
+// Protected process IDs, 0 marks a free slot. ProcessNotifyRoutine frees the
+// slot when the process exits.
+ULONG gProtectedProcesses[MAX_PROTECTED_PROCESSES] = { 0 };
+KSPIN_LOCK gProtectedLock;
+
+
+EXTERN_C
+NTSTATUS
+DriverEntry(
+	IN	PDRIVER_OBJECT		pDriverObject,
+	IN	PUNICODE_STRING		pRegistryPath
+)
+{
+	NTSTATUS status = STATUS_UNSUCCESSFUL;
+
+	UNREFERENCED_PARAMETER(pRegistryPath);
+
+	KdPrint(("[%s:%d]\n", _FN_, _LN_));
+
+	KeInitializeSpinLock(&gProtectedLock);
+
+	do
+	{
+		// Only administrators may change what is protected.
+		status = CreateControlDevice(pDriverObject);
+		if (!NT_SUCCESS(status))
+		{
+			break;
+		}
+
+		pDriverObject->DriverUnload = UnloadRoutine;
+
+		// Like ObRegisterCallbacks, fails with STATUS_ACCESS_DENIED without /INTEGRITYCHECK.
+		status = PsSetCreateProcessNotifyRoutineEx(ProcessNotifyRoutine, FALSE);
+		if (!NT_SUCCESS(status))
+		{
+			break;
+		}
+		gProcessNotifyRegistered = TRUE;
+
+		status = RegisterObCallbacks();
+
+	} while (FALSE);
+
+	if (!NT_SUCCESS(status))
+	{
+		KdPrint(("[%s:%d] failed. status : 0x%X\n", _FN_, _LN_, status));
+
+		if (gProcessNotifyRegistered)
+		{
+			PsSetCreateProcessNotifyRoutineEx(ProcessNotifyRoutine, TRUE);
+			gProcessNotifyRegistered = FALSE;
+		}
+
+		DeleteControlDevice(pDriverObject);
+	}
+
+	return status;
+}
+
+
+void
+UnloadRoutine(
+	IN	PDRIVER_OBJECT		pDriverObject
+)
+{
+	KdPrint(("[%s:%d]\n", _FN_, _LN_));
+
+	if (gRegistrationHandle != NULL)
+	{
+		ObUnRegisterCallbacks(gRegistrationHandle);
+		gRegistrationHandle = NULL;
+	}
+
+	if (gProcessNotifyRegistered)
+	{
+		PsSetCreateProcessNotifyRoutineEx(ProcessNotifyRoutine, TRUE);
+		gProcessNotifyRegistered = FALSE;
+	}
+
+	DeleteControlDevice(pDriverObject);
+
+	return;
+}
+
+
+NTSTATUS
+RegisterObCallbacks(
+)
+{
+	OB_OPERATION_REGISTRATION operations[2];
+	OB_CALLBACK_REGISTRATION registration;
+
+	RtlZeroMemory(operations, sizeof(operations));
+	operations[0].ObjectType = PsProcessType;
+	operations[0].Operations = OB_OPERATION_HANDLE_CREATE | OB_OPERATION_HANDLE_DUPLICATE;
+	operations[0].PreOperation = PreProcessHandleOperation;
+	operations[0].PostOperation = PostProcessHandleOperation;
+	operations[1].ObjectType = PsThreadType;
+	operations[1].Operations = OB_OPERATION_HANDLE_CREATE | OB_OPERATION_HANDLE_DUPLICATE;
+	operations[1].PreOperation = PreThreadHandleOperation;
+	operations[1].PostOperation = PostThreadHandleOperation;
+
+	RtlZeroMemory(&registration, sizeof(registration));
+	registration.Version = OB_FLT_REGISTRATION_VERSION;
+	registration.OperationRegistrationCount = ARRAYSIZE(operations);
+	RtlInitUnicodeString(&registration.Altitude, CALLBACK_ALTITUDE);
+	registration.RegistrationContext = NULL;
+	registration.OperationRegistration = operations;
+
+	// STATUS_ACCESS_DENIED means the driver was linked without /INTEGRITYCHECK,
+	// STATUS_FLT_INSTANCE_ALTITUDE_COLLISION that another driver has the altitude.
+	return ObRegisterCallbacks(&registration, &gRegistrationHandle);
+}
+
+
+// ProcessNotifyRoutine unprotects a process as it exits, its ID may be given
+// to an unrelated process next.
+void
+ProcessNotifyRoutine(
+	IN OUT		PEPROCESS				pProcess,
+	IN			HANDLE					processId,
+	IN OUT OPTIONAL	PPS_CREATE_NOTIFY_INFO	pCreateInfo
+)
+{
+	UNREFERENCED_PARAMETER(pProcess);
+
+	if (pCreateInfo == NULL)
+	{
+		UnprotectProcess(HandleToULong(processId));
+	}
+}
+
+
+OB_PREOP_CALLBACK_STATUS
+PreProcessHandleOperation(
+	IN	PVOID							pRegistrationContext,
+	IN	POB_PRE_OPERATION_INFORMATION	pOperationInformation
+)
+{
+	PEPROCESS pProcess = (PEPROCESS)pOperationInformation->Object;
+
+	UNREFERENCED_PARAMETER(pRegistrationContext);
+
+	// A process may still open itself.
+	if (pProcess != PsGetCurrentProcess() && IsProtectedProcess(PsGetProcessId(pProcess)))
+	{
+		StripAccess(pOperationInformation, PROCESS_DENIED_ACCESS);
+	}
+
+	return OB_PREOP_SUCCESS;
+}
+
+
+void
+PostProcessHandleOperation(
+	IN	PVOID							pRegistrationContext,
+	IN	POB_POST_OPERATION_INFORMATION	pOperationInformation
+)
+{
+	UNREFERENCED_PARAMETER(pRegistrationContext);
+	UNREFERENCED_PARAMETER(pOperationInformation);
+}
+
+
+OB_PREOP_CALLBACK_STATUS
+PreThreadHandleOperation(
+	IN	PVOID							pRegistrationContext,
+	IN	POB_PRE_OPERATION_INFORMATION	pOperationInformation
+)
+{
+	PEPROCESS pProcess = IoThreadToProcess((PETHREAD)pOperationInformation->Object);
+
+	UNREFERENCED_PARAMETER(pRegistrationContext);
+
+	if (pProcess != PsGetCurrentProcess() && IsProtectedProcess(PsGetProcessId(pProcess)))
+	{
+		StripAccess(pOperationInformation, THREAD_DENIED_ACCESS);
+	}
+
+	return OB_PREOP_SUCCESS;
+}
+
+
+void
+PostThreadHandleOperation(
+	IN	PVOID							pRegistrationContext,
+	IN	POB_POST_OPERATION_INFORMATION	pOperationInformation
+)
+{
+	UNREFERENCED_PARAMETER(pRegistrationContext);
+	UNREFERENCED_PARAMETER(pOperationInformation);
+}
+
+
+// StripAccess takes deniedAccess out of the handle about to be created or
+// duplicated. Kernel handles are left alone.
+void
+StripAccess(
+	IN OUT	POB_PRE_OPERATION_INFORMATION	pOperationInformation,
+	IN		ACCESS_MASK						deniedAccess
+)
+{
+	if (pOperationInformation->KernelHandle)
+	{
+		return;
+	}
+
+	if (pOperationInformation->Operation == OB_OPERATION_HANDLE_CREATE)
+	{
+		pOperationInformation->Parameters->CreateHandleInformation.DesiredAccess &= ~deniedAccess;
+	}
+	else
+	{
+		pOperationInformation->Parameters->DuplicateHandleInformation.DesiredAccess &= ~deniedAccess;
+	}
+}
+
+
+BOOLEAN
+IsProtectedProcess(
+	IN	HANDLE				processId
+)
+{
+	BOOLEAN found = FALSE;
+	ULONG id = HandleToULong(processId);
+	KIRQL oldIrql;
+	ULONG i = 0;
+
+	KeAcquireSpinLock(&gProtectedLock, &oldIrql);
+	for (i = 0; i < MAX_PROTECTED_PROCESSES && !found; ++i)
+	{
+		found = gProtectedProcesses[i] == id;
+	}
+	KeReleaseSpinLock(&gProtectedLock, oldIrql);
+
+	return found;
+}
+
+
+NTSTATUS
+ProtectProcess(
+	IN	ULONG				processId
+)
+{
+	NTSTATUS status = STATUS_INSUFFICIENT_RESOURCES;
+	KIRQL oldIrql;
+	ULONG freeSlot = MAX_PROTECTED_PROCESSES;
+	ULONG i = 0;
+
+	if (processId == 0)
+	{
+		return STATUS_INVALID_PARAMETER;
+	}
+
+	KeAcquireSpinLock(&gProtectedLock, &oldIrql);
+	for (i = 0; i < MAX_PROTECTED_PROCESSES; ++i)
+	{
+		if (gProtectedProcesses[i] == processId)
+		{
+			freeSlot = i;
+			break;
+		}
+		if (gProtectedProcesses[i] == 0 && freeSlot == MAX_PROTECTED_PROCESSES)
+		{
+			freeSlot = i;
+		}
+	}
+	if (freeSlot < MAX_PROTECTED_PROCESSES)
+	{
+		gProtectedProcesses[freeSlot] = processId;
+		status = STATUS_SUCCESS;
+	}
+	KeReleaseSpinLock(&gProtectedLock, oldIrql);
+
+	return status;
+}
+
+
+NTSTATUS
+UnprotectProcess(
+	IN	ULONG				processId
+)
+{
+	NTSTATUS status = STATUS_NOT_FOUND;
+	KIRQL oldIrql;
+	ULONG i = 0;
+
+	if (processId == 0)
+	{
+		return STATUS_INVALID_PARAMETER;
+	}
+
+	KeAcquireSpinLock(&gProtectedLock, &oldIrql);
+	for (i = 0; i < MAX_PROTECTED_PROCESSES; ++i)
+	{
+		if (gProtectedProcesses[i] == processId)
+		{
+			gProtectedProcesses[i] = 0;
+			status = STATUS_SUCCESS;
+			break;
+		}
+	}
+	KeReleaseSpinLock(&gProtectedLock, oldIrql);
+
+	return status;
+}
+` + CONTROL_DEVICE_SOURCE_TEMPLATE + `
+
+NTSTATUS
+DeviceIoControlRoutine(
+	IN	PDEVICE_OBJECT		pDeviceObject,
+	IN	PIRP				pIrp
+)
+{
+	NTSTATUS status = STATUS_UNSUCCESSFUL;
+
+	PIO_STACK_LOCATION pStack = IoGetCurrentIrpStackLocation(pIrp);
+	ULONG ioCtlCode = pStack->Parameters.DeviceIoControl.IoControlCode;
+	ULONG inSize = pStack->Parameters.DeviceIoControl.InputBufferLength;
+	P{{ $name }}_PROCESS pRequest = (P{{ $name }}_PROCESS)pIrp->AssociatedIrp.SystemBuffer;
+
+	UNREFERENCED_PARAMETER(pDeviceObject);
+
+	switch (ioCtlCode)
+	{
+	case IOCTL_{{ $name }}_PROTECT:
+	case IOCTL_{{ $name }}_UNPROTECT:
+	{
+		if (pRequest == NULL || inSize != sizeof({{ $name }}_PROCESS))
+		{
+			status = STATUS_INVALID_PARAMETER;
+			break;
+		}
+
+		KdPrint(("[%s:%d] pid : %u, protect : %d\n", _FN_, _LN_, pRequest->processId, ioCtlCode == IOCTL_{{ $name }}_PROTECT));
+
+		if (ioCtlCode == IOCTL_{{ $name }}_PROTECT)
+		{
+			status = ProtectProcess(pRequest->processId);
+		}
+		else
+		{
+			status = UnprotectProcess(pRequest->processId);
+		}
+		break;
+	}
+	default:
+		status = STATUS_INVALID_DEVICE_REQUEST;
+		break;
+	}
+
+	pIrp->IoStatus.Status = status;
+	pIrp->IoStatus.Information = 0;
+	IoCompleteRequest(pIrp, IO_NO_INCREMENT);
+
+	return status;
+}
+`
+
+const OBCALLBACKS_COMMON_HEADER_TEMPLATE =
+`#pragma once
+{{- $name := upperSnake .ProjectName }}
+
+// Input buffer is a {{ $name }}_PROCESS for both.
+#define IOCTL_{{ $name }}_PROTECT		CTL_CODE({{ .Vars.DeviceType }}, {{ ctlFunction 0 }}, METHOD_BUFFERED, FILE_WRITE_ACCESS)
+#define IOCTL_{{ $name }}_UNPROTECT		CTL_CODE({{ .Vars.DeviceType }}, {{ ctlFunction 1 }}, METHOD_BUFFERED, FILE_WRITE_ACCESS)
+
+#pragma pack (push, 1)
+
+typedef struct _{{ $name }}_PROCESS
+{
+    ULONG               processId;
+
+} {{ $name }}_PROCESS, *P{{ $name }}_PROCESS;
+
+#pragma pack (pop)
+`
+
+const OBCALLBACKS_EXE_CPP_TEMPLATE =
+`#include <iostream>
+#include <string>
+#include <Windows.h>
+#include "../Common/Common.h"
+{{- $name := upperSnake .ProjectName }}
+
+int wmain(int argc, wchar_t* argv[])
+{
+	HANDLE deviceHandle = INVALID_HANDLE_VALUE;
+	int result = 1;
+
+	if (argc != 3 || (std::wstring(argv[1]) != L"protect" && std::wstring(argv[1]) != L"unprotect"))
+	{
+		std::wcerr << L"usage: " << argv[0] << L" protect|unprotect <pid>" << std::endl;
+		return 1;
+	}
+
+	do
+	{
+		deviceHandle = CreateFile(L"\\\\.\\$PROJECTNAME_SYS$", GENERIC_READ | GENERIC_WRITE, 0, nullptr, OPEN_EXISTING, FILE_ATTRIBUTE_NORMAL, nullptr);
+		if (deviceHandle == INVALID_HANDLE_VALUE)
+		{
+			std::wcerr << L"CreateFile failed. error : " << GetLastError() << std::endl;
+			break;
+		}
+
+		DWORD retSize = 0;
+		DWORD ioctlCode = std::wstring(argv[1]) == L"protect" ? IOCTL_{{ $name }}_PROTECT : IOCTL_{{ $name }}_UNPROTECT;
+		{{ $name }}_PROCESS request = { 0 };
+		request.processId = std::wcstoul(argv[2], nullptr, 10);
+
+		if (!DeviceIoControl(deviceHandle, ioctlCode, (LPVOID)&request, sizeof(request), nullptr, 0, &retSize, nullptr))
+		{
+			std::wcerr << argv[1] << L" failed. error : " << GetLastError() << std::endl;
+			break;
+		}
+
+		result = 0;
+
+	} while (false);
+
+	if (deviceHandle != INVALID_HANDLE_VALUE)
+	{
+		CloseHandle(deviceHandle);
+		deviceHandle = INVALID_HANDLE_VALUE;
+	}
+
+	return result;
+}
+`
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestObCallbacksLint(t *testing.T) {
+	pack := builtinPacks[OBCALLBACKS_PACK_NAME]
+	vars := map[string]string{"Altitude": "385201.5", "MaxProtected": "1024", "DeviceType": "FILE_DEVICE_NETWORK"}
+
+	for _, problem := range lintPack(pack, vars) {
+		t.Error(problem)
+	}
+
+	for _, max := range []string{"0", "1025"} {
+		if _, err := resolveVariables(pack, nil, map[string]string{"MaxProtected": max}); err == nil || !strings.Contains(err.Error(), "is not between 1 and 1024") {
+			t.Errorf("MaxProtected=%s: error %v", max, err)
+		}
+	}
+
+	files := renderTestPack(t, pack, vars, nil)
+	project := files[LINT_SAMPLE_NAME+"/"+LINT_SAMPLE_NAME+".vcxproj"]
+	for _, text := range []string{"wdmsec.lib;", "/INTEGRITYCHECK"} {
+		if !strings.Contains(project, text) {
+			t.Errorf("the driver project lacks %s", text)
+		}
+	}
+
+	header := files[LINT_SAMPLE_NAME+"/"+LINT_SAMPLE_NAME+".h"]
+	if !strings.Contains(header, `L"385201.5"`) || !strings.Contains(header, "MAX_PROTECTED_PROCESSES\t1024") {
+		t.Errorf("the driver header lacks the altitude or the protected process count:\n%s", header)
+	}
+	if strings.Contains(header, "ALLOC_NONPAGED") {
+		t.Errorf("the driver allocates nothing, but its header defines ALLOC_NONPAGED")
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const MARK_TARGETNAME_SYS = `$TARGETNAME_SYS$`
+
+// outputLayout is the "output" section of the -config file. OutDir and IntDir
+// are relative to the solution directory unless they are absolute or start
+// with an MSBuild property, and may use $(Platform), $(Configuration) and
+// $(ProjectName).
+type outputLayout struct {
+	OutDir           string `yaml:"outDir"`
+	IntDir           string `yaml:"intDir"`
+	DriverTargetName string `yaml:"driverTargetName"`
+	AppTargetName    string `yaml:"appTargetName"`
+}
+
+var (
+	output = outputLayout{}
+
+	targetNameRegex = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+)
+
+// overlayOutput applies the non-empty fields of over to base.
+func overlayOutput(base, over outputLayout) outputLayout {
+	for _, field := range []struct {
+		to    *string
+		value string
+	}{
+		{&base.OutDir, over.OutDir},
+		{&base.IntDir, over.IntDir},
+		{&base.DriverTargetName, over.DriverTargetName},
+		{&base.AppTargetName, over.AppTargetName},
+	} {
+		if field.value != "" {
+			*field.to = field.value
+		}
+	}
+	return base
+}
+
+func checkOutputLayout(layout outputLayout) error {
+	for _, name := range []string{layout.DriverTargetName, layout.AppTargetName} {
+		if name == "" {
+			continue
+		}
+		extension := strings.ToLower(filepath.Ext(name))
+		if !targetNameRegex.MatchString(name) || extension == ".sys" || extension == ".exe" {
+			return fmt.Errorf("invalid target name %q, give it without directory or extension", name)
+		}
+	}
+	return nil
+}
+
+// outputDirectory turns a directory pattern into an OutDir/IntDir value.
+// Generated projects live one level below the solution directory.
+func outputDirectory(pattern string) string {
+	dir := strings.Replace(pattern, "/", `\`, -1)
+	if !strings.HasSuffix(dir, `\`) {
+		dir += `\`
+	}
+
+	absolute := strings.HasPrefix(dir, `\`) || strings.HasPrefix(dir, `$(`) || (len(dir) > 1 && dir[1] == ':')
+	if !absolute {
+		dir = `$(MSBuildProjectDirectory)\..\` + dir
+	}
+	return dir
+}
+
+// intermediateDirectory is like outputDirectory, but keeps projects apart so
+// they never share object files.
+func intermediateDirectory(pattern string) string {
+	dir := outputDirectory(pattern)
+	if !strings.Contains(dir, "$(ProjectName)") {
+		dir += `$(ProjectName)\`
+	}
+	return dir
+}
+
+func outputSettings() []msbuildProperty {
+	var properties []msbuildProperty
+	if output.OutDir != "" {
+		properties = append(properties, msbuildProperty{"OutDir", outputDirectory(output.OutDir)})
+	}
+	if output.IntDir != "" {
+		properties = append(properties, msbuildProperty{"IntDir", intermediateDirectory(output.IntDir)})
+	}
+	return properties
+}
+
+// targetNameProperty sets TargetName when it differs from the project name.
+func targetNameProperty(properties []msbuildProperty, targetName string) []msbuildProperty {
+	if targetName == "" {
+		return properties
+	}
+	return append(properties, msbuildProperty{"TargetName", targetName})
+}
+
+// libraryName is the project name of the kernel library next to the driver.
+func libraryName(projectName string) string {
+	return projectName + "Lib"
+}
+
+func driverTargetName(projectName string) string {
+	if output.DriverTargetName != "" {
+		return output.DriverTargetName
+	}
+	return projectName
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOutputDirectory(t *testing.T) {
+	tests := []struct {
+		pattern      string
+		outDir       string
+		intermediate string
+	}{
+		{`bin\$(Platform)\$(Configuration)`, `$(MSBuildProjectDirectory)\..\bin\$(Platform)\$(Configuration)\`, `$(MSBuildProjectDirectory)\..\bin\$(Platform)\$(Configuration)\$(ProjectName)\`},
+		{`build/obj/`, `$(MSBuildProjectDirectory)\..\build\obj\`, `$(MSBuildProjectDirectory)\..\build\obj\$(ProjectName)\`},
+		{`obj\$(ProjectName)\$(Configuration)`, `$(MSBuildProjectDirectory)\..\obj\$(ProjectName)\$(Configuration)\`, `$(MSBuildProjectDirectory)\..\obj\$(ProjectName)\$(Configuration)\`},
+		{`$(SolutionDir)out`, `$(SolutionDir)out\`, `$(SolutionDir)out\$(ProjectName)\`},
+		{`d:\build`, `d:\build\`, `d:\build\$(ProjectName)\`},
+		{`\\server\share`, `\\server\share\`, `\\server\share\$(ProjectName)\`},
+	}
+
+	for _, test := range tests {
+		if got := outputDirectory(test.pattern); got != test.outDir {
+			t.Errorf("outputDirectory(%q) = %q, want %q", test.pattern, got, test.outDir)
+		}
+		if got := intermediateDirectory(test.pattern); got != test.intermediate {
+			t.Errorf("intermediateDirectory(%q) = %q, want %q", test.pattern, got, test.intermediate)
+		}
+	}
+}
+
+func TestOverlayOutput(t *testing.T) {
+	config := outputLayout{OutDir: "bin", IntDir: "obj", DriverTargetName: "contoso"}
+	cli := outputLayout{OutDir: "out", AppTargetName: "contosoctl"}
+
+	got := overlayOutput(config, cli)
+	want := outputLayout{OutDir: "out", IntDir: "obj", DriverTargetName: "contoso", AppTargetName: "contosoctl"}
+	if got != want {
+		t.Errorf("overlayOutput = %+v, want %+v", got, want)
+	}
+}
+
+func TestCheckOutputLayout(t *testing.T) {
+	tests := []struct {
+		layout  outputLayout
+		wantErr bool
+	}{
+		{outputLayout{}, false},
+		{outputLayout{DriverTargetName: "contoso", AppTargetName: "contoso-ctl.v2"}, false},
+		{outputLayout{DriverTargetName: "contoso.sys"}, true},
+		{outputLayout{AppTargetName: "Contoso.EXE"}, true},
+		{outputLayout{DriverTargetName: `bin\contoso`}, true},
+		{outputLayout{AppTargetName: ".hidden"}, true},
+	}
+
+	for _, test := range tests {
+		err := checkOutputLayout(test.layout)
+		if (err != nil) != test.wantErr {
+			t.Errorf("checkOutputLayout(%+v) = %v, want error %v", test.layout, err, test.wantErr)
+		}
+	}
+}
+
+func TestOutputSettings(t *testing.T) {
+	defer func(saved outputLayout) { output = saved }(output)
+
+	output = outputLayout{}
+	if properties := outputSettings(); len(properties) != 0 {
+		t.Errorf("outputSettings without patterns = %v, want none", properties)
+	}
+
+	output = outputLayout{OutDir: `bin\$(Configuration)`, IntDir: `obj`, DriverTargetName: "contoso"}
+	properties := outputSettings()
+	if value := propertyValue(properties, "OutDir"); value != `$(MSBuildProjectDirectory)\..\bin\$(Configuration)\` {
+		t.Errorf("OutDir = %q", value)
+	}
+	if value := propertyValue(properties, "IntDir"); value != `$(MSBuildProjectDirectory)\..\obj\$(ProjectName)\` {
+		t.Errorf("IntDir = %q", value)
+	}
+
+	driver := driverSettings(buildConfiguration{Name: "Release"}, "x64")
+	if value := propertyValue(driver.properties, "TargetName"); value != "contoso" {
+		t.Errorf("driver TargetName = %q, want contoso", value)
+	}
+	if name := driverTargetName("MyDriver"); name != "contoso" {
+		t.Errorf("driverTargetName = %q, want contoso", name)
+	}
+
+	app := appSettings(buildConfiguration{Name: "Release"}, "x64")
+	if value := propertyValue(app.properties, "TargetName"); value != "" {
+		t.Errorf("app TargetName = %q, want none", value)
+	}
+	if strings.Contains(propertyValue(app.properties, "OutDir"), "bin") {
+		t.Errorf("app settings repeat the common OutDir")
+	}
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	PACK_MANIFEST_NAME = `pack.yaml`
+	DEFAULT_PACK_NAME  = `wdm`
+
+	SETTINGS_PROJECT_DRIVER  = `driver`
+	SETTINGS_PROJECT_APP     = `app`
+	SETTINGS_PROJECT_LIBRARY = `library`
+)
+
+// packVariable is a user-defined variable declared by a template pack.
+// Values come from -var / -vars and are checked against the declaration.
+// Min and Max bound int variables when Max is set.
+type packVariable struct {
+	Name        string `yaml:"name"`
+	Type        string `yaml:"type"`
+	Default     string `yaml:"default"`
+	Required    bool   `yaml:"required"`
+	Regex       string `yaml:"regex"`
+	Min         int    `yaml:"min"`
+	Max         int    `yaml:"max"`
+	Description string `yaml:"description"`
+}
+
+// packFile is one file emitted by a template pack. Path is relative to the
+// solution directory and may contain MARK_* markers. When holds an optional
+// feature condition, see parseCondition.
+type packFile struct {
+	Path   string `yaml:"path"`
+	Source string `yaml:"source"`
+	When   string `yaml:"when"`
+
+	contents string
+}
+
+// packSettings changes the generated MSBuild settings of one project, driver,
+// app or library, when its feature condition holds.
+type packSettings struct {
+	When            string `yaml:"when"`
+	Project         string `yaml:"project"`
+	configOverrides `yaml:",inline"`
+}
+
+type templatePack struct {
+	Name        string         `yaml:"name"`
+	Description string         `yaml:"description"`
+	Variables   []packVariable `yaml:"variables"`
+	Features    []packFeature  `yaml:"features"`
+	Files       []packFile     `yaml:"files"`
+	Settings    []packSettings `yaml:"settings"`
+
+	dir string
+}
+
+// templateData is what pack templates see as "." when rendered.
+type templateData struct {
+	ProjectName string
+	Vars        map[string]interface{}
+	Features    map[string]string
+}
+
+// integrityCheckSettings links the driver with /INTEGRITYCHECK, without it
+// routines such as PsSetCreateProcessNotifyRoutineEx and ObRegisterCallbacks
+// fail with STATUS_ACCESS_DENIED.
+var integrityCheckSettings = packSettings{Project: SETTINGS_PROJECT_DRIVER, configOverrides: configOverrides{
+	Link: map[string]string{"AdditionalOptions": "/INTEGRITYCHECK %(AdditionalOptions)"},
+}}
+
+// wdmsecSettings links wdmsec.lib, which holds IoCreateDeviceSecure and the
+// SDDL_DEVOBJ_* strings control devices are created with.
+var wdmsecSettings = packSettings{Project: SETTINGS_PROJECT_DRIVER, configOverrides: configOverrides{
+	Link: map[string]string{"AdditionalDependencies": "wdmsec.lib;%(AdditionalDependencies)"},
+}}
+
+var builtinPacks = map[string]*templatePack{
+	DEFAULT_PACK_NAME: newDriverPack(driverModel{
+		Name:        DEFAULT_PACK_NAME,
+		Description: "legacy WDM control driver with a console client",
+		NoAlloc:     true,
+		Header:      DRIVER_HEADER_TEMPLATE,
+		Source:      DRIVER_SOURCE_TEMPLATE,
+		Inf:         DRIVER_INF_TEMPLATE,
+		Client:      EXE_CPP_TEMPLATE,
+	}),
+	KMDF_PACK_NAME:        newDriverPack(kmdfModel),
+	UMDF2_PACK_NAME:       newDriverPack(umdf2Model),
+	MINIFILTER_PACK_NAME:  newDriverPack(minifilterModel),
+	MONITOR_PACK_NAME:     newDriverPack(monitorModel),
+	NDIS_LWF_PACK_NAME:    newDriverPack(ndisLwfModel),
+	OBCALLBACKS_PACK_NAME: newDriverPack(obCallbacksModel),
+	REGFILTER_PACK_NAME:   newDriverPack(regFilterModel),
+	WDM_FILTER_PACK_NAME:  newDriverPack(wdmFilterModel),
+	WDM_PNP_PACK_NAME:     newDriverPack(wdmPnpModel),
+	WFP_CALLOUT_PACK_NAME: newDriverPack(wfpCalloutModel),
+}
+
+// driverModel is a builtin pack on top of the shared solution and project
+// templates. Variables, Features, Files and Settings are added to the shared
+// ones, Drop names shared variables and features the model has no use for.
+// UserMode drivers get no kernel C++ support or library. NoClient models have
+// nothing a console client could talk to. NoAlloc models allocate nothing, so
+// alloc only picks what the kernel C++ support allocates with.
+type driverModel struct {
+	Name        string
+	Description string
+	UserMode    bool
+	RequireInf  bool
+	NoClient    bool
+	NoAlloc     bool
+	Drop        []string
+	Variables   []packVariable
+	Features    []packFeature
+	Files       []packFile
+	Settings    []packSettings
+
+	Header string
+	Source string
+	Inf    string
+	Client string
+	Common string
+}
+
+func newDriverPack(model driverModel) *templatePack {
+	if model.Common == "" {
+		model.Common = COMMON_HEADER_TEMPLATE
+	}
+
+	pack := &templatePack{
+		Name:        model.Name,
+		Description: model.Description,
+		Variables: []packVariable{
+			{Name: "DeviceType", Type: VAR_TYPE_STRING, Default: "FILE_DEVICE_UNKNOWN", Regex: `^FILE_DEVICE_[A-Z0-9_]+$`, Description: "device type passed to IoCreateDevice and CTL_CODE"},
+			{Name: "Company", Type: VAR_TYPE_STRING, Description: "manufacturer shown in the INF, defaults to the project name"},
+			{Name: "PoolTag", Type: VAR_TYPE_STRING, Regex: `^[A-Za-z0-9]{1,4}$`, Description: "pool tag as shown by pool tools, derived from the project name when empty"},
+		},
+		Features: []packFeature{
+			{Name: "client", Default: "app", Values: []string{"app", "none"}, Description: "console client project talking to the driver"},
+			{Name: "inf", Default: "false", Values: []string{"true", "false"}, Description: "INF to install the driver"},
+			{Name: "lang", Default: "cpp", Values: []string{"cpp", "c"}, Description: "language of the driver sources, the file extension follows it"},
+			{Name: "cppruntime", Default: "false", Values: []string{"true", "false"}, Description: "kernel C++ support: pool new/delete and RAII lock, string and object reference wrappers", Requires: map[string]string{"true": "lang=cpp"}},
+			{Name: "alloc", Default: "pool", Values: []string{"pool", "pool2"}, Description: "allocate with ExAllocatePoolWithTag, or the zeroing ExAllocatePool2 (Windows 10 2004)", MinOS: map[string]string{"pool2": "win10-2004"}},
+			{Name: "props", Default: "inline", Values: []string{"inline", "shared", "project"}, Description: "inline settings, share the common ones in Directory.Build.props, or also move project settings to per-project .props"},
+			{Name: "library", Default: "none", Values: []string{"none", "static", "export"}, Description: "kernel library project the driver links against: a static library, or an export driver with DllInitialize/DllUnload and a .def file"},
+		},
+		Files: []packFile{
+			{Path: MARK_PROJECTNAME_SYS + `.sln`, contents: SOLUTION_TEMPLATE},
+			{Path: `Directory.Build.props`, When: "props=shared|project", contents: DIRECTORY_BUILD_PROPS_TEMPLATE},
+			{Path: MARK_PROJECTNAME_SYS + `\` + MARK_PROJECTNAME_SYS + `.props`, When: "props=project", contents: PROPS_SYS_TEMPLATE},
+			{Path: MARK_PROJECTNAME_SYS + `\` + MARK_PROJECTNAME_SYS + `.vcxproj`, contents: VCXPROJ_SYS_TEMPLATE},
+			{Path: MARK_PROJECTNAME_SYS + `\` + MARK_PROJECTNAME_SYS + `.vcxproj.filters`, contents: VCXPROJFILTER_SYS_TEMPLATE},
+			{Path: MARK_PROJECTNAME_SYS + `\` + MARK_PROJECTNAME_SYS + `.h`, contents: model.Header},
+			{Path: MARK_PROJECTNAME_SYS + `\` + MARK_PROJECTNAME_SYS + `.cpp`, When: "lang=cpp", contents: model.Source},
+			{Path: MARK_PROJECTNAME_SYS + `\` + MARK_PROJECTNAME_SYS + `.c`, When: "lang=c", contents: model.Source},
+			{Path: MARK_PROJECTNAME_SYS + `\KernelCpp.h`, When: "cppruntime=true", contents: KERNEL_CPP_HEADER_TEMPLATE},
+			{Path: MARK_PROJECTNAME_SYS + `\KernelCpp.cpp`, When: "cppruntime=true", contents: KERNEL_CPP_SOURCE_TEMPLATE},
+			{Path: MARK_PROJECTNAME_SYS + `\` + MARK_PROJECTNAME_SYS + `.inf`, When: "inf=true", contents: model.Inf},
+			{Path: EXE_NAME + `\` + EXE_NAME + `.vcxproj`, When: "client=app", contents: VCXPROJ_EXE_TEMPLATE},
+			{Path: EXE_NAME + `\` + EXE_NAME + `.vcxproj.filters`, When: "client=app", contents: VCXPROJFILTER_EXE_TEMPLATE},
+			{Path: EXE_NAME + `\` + EXE_NAME + `.cpp`, When: "client=app", contents: model.Client},
+			{Path: EXE_NAME + `\` + EXE_NAME + `.props`, When: "client=app,props=project", contents: PROPS_EXE_TEMPLATE},
+			{Path: COMMON_NAME + `\` + COMMON_NAME + `.h`, contents: model.Common},
+			{Path: MARK_PROJECTNAME_LIB + `\` + MARK_PROJECTNAME_LIB + `.vcxproj`, When: "library=static|export", contents: VCXPROJ_LIB_TEMPLATE},
+			{Path: MARK_PROJECTNAME_LIB + `\` + MARK_PROJECTNAME_LIB + `.vcxproj.filters`, When: "library=static|export", contents: VCXPROJFILTER_LIB_TEMPLATE},
+			{Path: MARK_PROJECTNAME_LIB + `\` + MARK_PROJECTNAME_LIB + `.props`, When: "library=static|export,props=project", contents: PROPS_LIB_TEMPLATE},
+			{Path: MARK_PROJECTNAME_LIB + `\` + MARK_PROJECTNAME_LIB + `.h`, When: "library=static|export", contents: LIBRARY_HEADER_TEMPLATE},
+			{Path: MARK_PROJECTNAME_LIB + `\` + MARK_PROJECTNAME_LIB + `.cpp`, When: "library=static|export,lang=cpp", contents: LIBRARY_SOURCE_TEMPLATE},
+			{Path: MARK_PROJECTNAME_LIB + `\` + MARK_PROJECTNAME_LIB + `.c`, When: "library=static|export,lang=c", contents: LIBRARY_SOURCE_TEMPLATE},
+			{Path: MARK_PROJECTNAME_LIB + `\` + MARK_PROJECTNAME_LIB + `.def`, When: "library=export", contents: LIBRARY_DEF_TEMPLATE},
+		},
+		Settings: []packSettings{
+			{When: "cppruntime=true", Project: SETTINGS_PROJECT_DRIVER, configOverrides: configOverrides{
+				Compile: map[string]string{"AdditionalOptions": "/Zc:threadSafeInit- %(AdditionalOptions)"},
+			}},
+			{When: "library=export", Project: SETTINGS_PROJECT_LIBRARY, configOverrides: configOverrides{
+				Properties: map[string]string{"ConfigurationType": "Driver"},
+				Link:       map[string]string{"ModuleDefinitionFile": "$(ProjectName).def"},
+			}},
+		},
+	}
+
+	for _, name := range model.Drop {
+		pack.Variables = withoutVariable(pack.Variables, name)
+		pack.Features = withoutFeature(pack.Features, name)
+	}
+
+	// The shared project templates look at inf, client, cppruntime and
+	// library, so they stay declared with a single value.
+	if model.RequireInf {
+		inf := findFeature(pack, "inf")
+		inf.Default = "true"
+		inf.Values = []string{"true"}
+	}
+
+	if model.NoClient {
+		pack.Files, pack.Settings = withoutCondition(pack.Files, pack.Settings, "client=app")
+
+		client := findFeature(pack, "client")
+		client.Default = "none"
+		client.Values = []string{"none"}
+	}
+
+	if model.NoAlloc {
+		alloc := findFeature(pack, "alloc")
+		alloc.Requires = map[string]string{"pool2": "cppruntime=true"}
+		alloc.Description = "allocator of the kernel C++ support, the driver itself allocates nothing"
+	}
+
+	if model.UserMode {
+		pack.Files, pack.Settings = withoutCondition(pack.Files, pack.Settings, "cppruntime=true")
+
+		cppruntime := findFeature(pack, "cppruntime")
+		cppruntime.Values = []string{"false"}
+		cppruntime.Requires = nil
+		cppruntime.Description = "kernel C++ support, not available in user mode"
+
+		for _, when := range []string{"library=static|export", "library=export"} {
+			pack.Files, pack.Settings = withoutCondition(pack.Files, pack.Settings, when)
+		}
+
+		library := findFeature(pack, "library")
+		library.Values = []string{"none"}
+		library.Description = "kernel library project, user-mode drivers cannot link against one"
+	}
+
+	pack.Variables = append(pack.Variables, model.Variables...)
+	pack.Features = append(pack.Features, model.Features...)
+	pack.Files = append(pack.Files, model.Files...)
+	pack.Settings = append(pack.Settings, model.Settings...)
+
+	return pack
+}
+
+func withoutVariable(variables []packVariable, name string) []packVariable {
+	var result []packVariable
+	for _, variable := range variables {
+		if variable.Name != name {
+			result = append(result, variable)
+		}
+	}
+	return result
+}
+
+func withoutFeature(features []packFeature, name string) []packFeature {
+	var result []packFeature
+	for _, feature := range features {
+		if feature.Name != name {
+			result = append(result, feature)
+		}
+	}
+	return result
+}
+
+// withoutCondition drops the files and settings that only apply under when,
+// alone or as one of the terms of their condition.
+func withoutCondition(files []packFile, settings []packSettings, when string) ([]packFile, []packSettings) {
+	var resultFiles []packFile
+	for _, file := range files {
+		if !hasConditionTerm(file.When, when) {
+			resultFiles = append(resultFiles, file)
+		}
+	}
+
+	var resultSettings []packSettings
+	for _, setting := range settings {
+		if !hasConditionTerm(setting.When, when) {
+			resultSettings = append(resultSettings, setting)
+		}
+	}
+
+	return resultFiles, resultSettings
+}
+
+func hasConditionTerm(when, term string) bool {
+	for _, candidate := range strings.Split(when, ",") {
+		if strings.TrimSpace(candidate) == term {
+			return true
+		}
+	}
+	return false
+}
+
+// builtinModelNames lists the builtin packs for -model.
+func builtinModelNames() string {
+	names := make([]string, 0, len(builtinPacks))
+	for name := range builtinPacks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, "|")
+}
+
+// loadPack returns the builtin pack with the given name, or reads the pack
+// whose manifest lives in the directory name points to.
+func loadPack(name string) (*templatePack, error) {
+	if name == "" {
+		name = DEFAULT_PACK_NAME
+	}
+
+	if pack, ok := builtinPacks[name]; ok {
+		return pack, nil
+	}
+
+	manifest, err := ioutil.ReadFile(filepath.Join(name, PACK_MANIFEST_NAME))
+	if err != nil {
+		return nil, err
+	}
+
+	pack := &templatePack{}
+	if err := yaml.Unmarshal(manifest, pack); err != nil {
+		return nil, fmt.Errorf("%s: %v", PACK_MANIFEST_NAME, err)
+	}
+	pack.dir = name
+
+	if pack.Name == "" {
+		pack.Name = filepath.Base(name)
+	}
+
+	for i := range pack.Files {
+		file := &pack.Files[i]
+		if file.Path == "" {
+			return nil, fmt.Errorf("%s: file %d has no path", PACK_MANIFEST_NAME, i)
+		}
+		if file.Source == "" {
+			file.Source = file.Path
+		}
+
+		contents, err := ioutil.ReadFile(filepath.Join(pack.dir, filepath.FromSlash(file.Source)))
+		if err != nil {
+			return nil, err
+		}
+		file.contents = string(contents)
+	}
+
+	if err := checkPackVariables(pack); err != nil {
+		return nil, err
+	}
+
+	if err := checkPackFeatures(pack); err != nil {
+		return nil, err
+	}
+
+	return pack, nil
+}
+
+// applyPackSettings sets the pack's driver, app and library settings for the
+// selected features.
+func applyPackSettings(pack *templatePack, features map[string]string) error {
+	driverPackOverrides = configOverrides{}
+	appPackOverrides = configOverrides{}
+	libraryPackOverrides = configOverrides{}
+
+	for _, settings := range pack.Settings {
+		matched, err := matchCondition(pack, settings.When, features)
+		if err != nil {
+			return err
+		}
+		if !matched {
+			continue
+		}
+
+		switch settings.Project {
+		case SETTINGS_PROJECT_DRIVER:
+			driverPackOverrides = mergeOverrides(driverPackOverrides, settings.configOverrides)
+		case SETTINGS_PROJECT_LIBRARY:
+			libraryPackOverrides = mergeOverrides(libraryPackOverrides, settings.configOverrides)
+		default:
+			appPackOverrides = mergeOverrides(appPackOverrides, settings.configOverrides)
+		}
+	}
+
+	return nil
+}
+
+// renderContents replaces the MARK_* markers and then executes the result as
+// a text/template against data.
+func renderContents(name, contents string, marks map[string]string, data *templateData) (string, error) {
+	for mark, value := range marks {
+		contents = replaceContents(contents, mark, value)
+	}
+	contents = replaceContents(contents, MARK_GUID_RANDOM, genGuid(name+`/random`))
+
+	tmpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(contents)
+	if err != nil {
+		return "", err
+	}
+
+	var buffer bytes.Buffer
+	if err := tmpl.Execute(&buffer, data); err != nil {
+		return "", err
+	}
+
+	return buffer.String(), nil
+}
+
+type renderedFile struct {
+	path     string
+	contents string
+}
+
+// renderPackFiles renders every file of pack in memory. Paths are returned
+// with forward slashes, relative to the solution directory.
+func renderPackFiles(pack *templatePack, marks map[string]string, data *templateData) ([]renderedFile, error) {
+	files := make([]renderedFile, 0, len(pack.Files))
+	for _, file := range pack.Files {
+		included, err := matchCondition(pack, file.When, data.Features)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file.Path, err)
+		}
+		if !included {
+			continue
+		}
+
+		relPath := file.Path
+		for mark, value := range marks {
+			relPath = replaceContents(relPath, mark, value)
+		}
+		relPath = strings.Replace(relPath, `\`, `/`, -1)
+
+		contents, err := renderContents(relPath, file.contents, marks, data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", relPath, err)
+		}
+
+		files = append(files, renderedFile{path: relPath, contents: contents})
+	}
+
+	return files, nil
+}
+
+// renderPack writes every file of pack below outputPath.
+func renderPack(pack *templatePack, outputPath string, marks map[string]string, data *templateData) error {
+	files, err := renderPackFiles(pack, marks, data)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		filePath := filepath.Join(outputPath, filepath.FromSlash(file.path))
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			return err
+		}
+
+		if err := makeFile(filePath, file.contents); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// renderTestPack renders pack as SampleDriver with the pack settings of the
+// given features applied, and returns the contents by path.
+func renderTestPack(t *testing.T, pack *templatePack, vars, features map[string]string) map[string]string {
+	t.Helper()
+
+	resolvedVars, err := resolveVariables(pack, nil, vars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolvedFeatures, err := resolveFeatures(pack, nil, features)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := applyPackSettings(pack, resolvedFeatures); err != nil {
+		t.Fatal(err)
+	}
+
+	data := &templateData{ProjectName: LINT_SAMPLE_NAME, Vars: resolvedVars, Features: resolvedFeatures}
+	files, err := renderPackFiles(pack, makeMarks(LINT_SAMPLE_NAME, LINT_SAMPLE_VSVERSION), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contents := map[string]string{}
+	for _, file := range files {
+		contents[file.path] = file.contents
+	}
+	return contents
+}
+
+func TestPropsModes(t *testing.T) {
+	const (
+		sysProject  = LINT_SAMPLE_NAME + "/" + LINT_SAMPLE_NAME + ".vcxproj"
+		sysProps    = LINT_SAMPLE_NAME + "/" + LINT_SAMPLE_NAME + ".props"
+		exeProps    = EXE_NAME + "/" + EXE_NAME + ".props"
+		sharedProps = "Directory.Build.props"
+		warnings    = "<WarningLevel>Level3</WarningLevel>"
+		debugger    = "<DebuggerFlavor>DbgengKernelDebugger</DebuggerFlavor>"
+	)
+
+	tests := []struct {
+		props        string
+		files        []string
+		noFiles      []string
+		projectHas   []string
+		projectLacks []string
+	}{
+		{
+			props:        "inline",
+			noFiles:      []string{sharedProps, sysProps, exeProps},
+			projectHas:   []string{warnings, debugger},
+			projectLacks: []string{"Directory.Build.props"},
+		},
+		{
+			props:        "shared",
+			files:        []string{sharedProps},
+			noFiles:      []string{sysProps, exeProps},
+			projectHas:   []string{`<Import Project="..\Directory.Build.props" />`, "<ImportDirectoryBuildProps>false</ImportDirectoryBuildProps>", debugger},
+			projectLacks: []string{warnings},
+		},
+		{
+			props:        "project",
+			files:        []string{sharedProps, sysProps, exeProps},
+			projectHas:   []string{`<Import Project="..\Directory.Build.props" />`, `<Import Project="` + LINT_SAMPLE_NAME + `.props" />`},
+			projectLacks: []string{warnings, debugger},
+		},
+	}
+
+	for _, test := range tests {
+		files := renderTestPack(t, builtinPacks[DEFAULT_PACK_NAME], nil, map[string]string{"props": test.props})
+
+		for _, path := range test.files {
+			if _, ok := files[path]; !ok {
+				t.Errorf("props=%s: %s is not emitted", test.props, path)
+			}
+		}
+		for _, path := range test.noFiles {
+			if _, ok := files[path]; ok {
+				t.Errorf("props=%s: %s is emitted", test.props, path)
+			}
+		}
+		for _, text := range test.projectHas {
+			if !strings.Contains(files[sysProject], text) {
+				t.Errorf("props=%s: driver project lacks %s", test.props, text)
+			}
+		}
+		for _, text := range test.projectLacks {
+			if strings.Contains(files[sysProject], text) {
+				t.Errorf("props=%s: driver project has %s", test.props, text)
+			}
+		}
+	}
+
+	files := renderTestPack(t, builtinPacks[DEFAULT_PACK_NAME], nil, map[string]string{"props": "project"})
+	if !strings.Contains(files[sharedProps], warnings) || strings.Contains(files[sharedProps], debugger) {
+		t.Errorf("Directory.Build.props should hold the common settings only:\n%s", files[sharedProps])
+	}
+	if !strings.Contains(files[sysProps], debugger) {
+		t.Errorf("%s lacks the driver settings:\n%s", sysProps, files[sysProps])
+	}
+}
+
+func TestNoAllocModel(t *testing.T) {
+	pack := newDriverPack(driverModel{Name: "test", NoAlloc: true})
+
+	if _, err := resolveFeatures(pack, nil, map[string]string{"alloc": "pool2"}); err == nil || !strings.Contains(err.Error(), "alloc=pool2 needs cppruntime=true") {
+		t.Errorf("alloc=pool2 without the kernel C++ support: error %v", err)
+	}
+	if _, err := resolveFeatures(pack, nil, map[string]string{"alloc": "pool2", "cppruntime": "true"}); err != nil {
+		t.Errorf("alloc=pool2 with the kernel C++ support: %v", err)
+	}
+
+	if _, err := resolveFeatures(builtinPacks[MONITOR_PACK_NAME], nil, map[string]string{"alloc": "pool2"}); err != nil {
+		t.Errorf("alloc=pool2 on a model that allocates: %v", err)
+	}
+}
+
+func TestLangFeature(t *testing.T) {
+	const sysPrefix = LINT_SAMPLE_NAME + "/" + LINT_SAMPLE_NAME
+
+	for _, test := range []struct{ lang, source, other string }{
+		{"c", sysPrefix + ".c", sysPrefix + ".cpp"},
+		{"cpp", sysPrefix + ".cpp", sysPrefix + ".c"},
+	} {
+		files := renderTestPack(t, builtinPacks[DEFAULT_PACK_NAME], nil, map[string]string{"lang": test.lang})
+
+		if _, ok := files[test.source]; !ok {
+			t.Errorf("lang=%s: %s is not emitted", test.lang, test.source)
+		}
+		if _, ok := files[test.other]; ok {
+			t.Errorf("lang=%s: %s is emitted", test.lang, test.other)
+		}
+
+		include := `<ClCompile Include="` + LINT_SAMPLE_NAME + test.source[len(sysPrefix):] + `"`
+		for _, path := range []string{sysPrefix + ".vcxproj", sysPrefix + ".vcxproj.filters"} {
+			if !strings.Contains(files[path], include) {
+				t.Errorf("lang=%s: %s lacks %s", test.lang, path, include)
+			}
+		}
+
+		if hasExternC := strings.Contains(files[sysPrefix+".h"], `extern "C"`); hasExternC != (test.lang == "cpp") {
+			t.Errorf(`lang=%s: header has extern "C" %v`, test.lang, hasExternC)
+		}
+	}
+}
+
+func TestCppRuntimeFeature(t *testing.T) {
+	const sysPrefix = LINT_SAMPLE_NAME + "/" + LINT_SAMPLE_NAME
+	pack := builtinPacks[DEFAULT_PACK_NAME]
+
+	files := renderTestPack(t, pack, nil, nil)
+	if _, ok := files[LINT_SAMPLE_NAME+"/KernelCpp.h"]; ok {
+		t.Errorf("KernelCpp.h is emitted without cppruntime=true")
+	}
+
+	files = renderTestPack(t, pack, nil, map[string]string{"cppruntime": "true", "alloc": "pool2"})
+	for _, path := range []string{LINT_SAMPLE_NAME + "/KernelCpp.h", LINT_SAMPLE_NAME + "/KernelCpp.cpp"} {
+		if _, ok := files[path]; !ok {
+			t.Errorf("cppruntime=true: %s is not emitted", path)
+		}
+	}
+	if !strings.Contains(files[sysPrefix+".h"], `#include "KernelCpp.h"`) {
+		t.Errorf("cppruntime=true: the driver header does not include KernelCpp.h")
+	}
+	if !strings.Contains(files[sysPrefix+".vcxproj"], "/Zc:threadSafeInit-") {
+		t.Errorf("cppruntime=true: the driver project does not turn off thread-safe statics")
+	}
+	if !strings.Contains(files[LINT_SAMPLE_NAME+"/KernelCpp.cpp"], "ExAllocatePool2(") {
+		t.Errorf("alloc=pool2: the kernel C++ support does not allocate with ExAllocatePool2")
+	}
+	if strings.Contains(files[sysPrefix+".h"], "ALLOC_NONPAGED") {
+		t.Errorf("the wdm driver allocates nothing, but its header defines ALLOC_NONPAGED")
+	}
+
+	if _, err := resolveFeatures(pack, nil, map[string]string{"cppruntime": "true", "lang": "c"}); err == nil || !strings.Contains(err.Error(), "cppruntime=true needs lang=cpp") {
+		t.Errorf("cppruntime=true with lang=c: error %v", err)
+	}
+}
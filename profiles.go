@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const DEFAULT_PROFILE_NAME = `default`
+
+// buildProfile controls the compiler and linker policy of a project. Empty
+// fields leave the setting to whatever comes before: the named Profile, the
+// solution-wide profile, or the project's own defaults.
+type buildProfile struct {
+	Profile             string   `yaml:"profile"`
+	WarningLevel        string   `yaml:"warningLevel"`
+	TreatWarningAsError string   `yaml:"treatWarningAsError"`
+	SDLCheck            string   `yaml:"sdlCheck"`
+	ConformanceMode     string   `yaml:"conformanceMode"`
+	Optimization        string   `yaml:"optimization"`
+	RuntimeLibrary      string   `yaml:"runtimeLibrary"`
+	Defines             []string `yaml:"defines"`
+	LinkOptions         []string `yaml:"linkOptions"`
+}
+
+// buildSettings is the "build" section of the -config file. The top level is
+// the solution-wide profile; driver and app refine it for one project.
+type buildSettings struct {
+	buildProfile `yaml:",inline"`
+	Driver       buildProfile `yaml:"driver"`
+	App          buildProfile `yaml:"app"`
+}
+
+var namedProfiles = map[string]buildProfile{
+	"default": {
+		WarningLevel:        "Level3",
+		TreatWarningAsError: "false",
+	},
+	"strict": {
+		WarningLevel:        "Level4",
+		TreatWarningAsError: "true",
+		SDLCheck:            "true",
+		ConformanceMode:     "true",
+	},
+	"legacy": {
+		WarningLevel:        "Level3",
+		TreatWarningAsError: "false",
+		SDLCheck:            "false",
+		ConformanceMode:     "false",
+	},
+}
+
+var (
+	solutionProfile = namedProfiles[DEFAULT_PROFILE_NAME]
+	driverProfile   buildProfile
+	appProfile      buildProfile
+)
+
+// overlayProfile returns base with the non-empty fields of over applied. A
+// named profile in over is applied first, then its explicit fields.
+func overlayProfile(base, over buildProfile) (buildProfile, error) {
+	if over.Profile != "" {
+		named, ok := namedProfiles[over.Profile]
+		if !ok {
+			return base, fmt.Errorf("unknown build profile %q", over.Profile)
+		}
+		base, _ = overlayProfile(base, named)
+	}
+
+	for _, field := range []struct {
+		to    *string
+		value string
+	}{
+		{&base.WarningLevel, over.WarningLevel},
+		{&base.TreatWarningAsError, over.TreatWarningAsError},
+		{&base.SDLCheck, over.SDLCheck},
+		{&base.ConformanceMode, over.ConformanceMode},
+		{&base.Optimization, over.Optimization},
+		{&base.RuntimeLibrary, over.RuntimeLibrary},
+	} {
+		if field.value != "" {
+			*field.to = field.value
+		}
+	}
+
+	base.Defines = append(append([]string(nil), base.Defines...), over.Defines...)
+	base.LinkOptions = append(append([]string(nil), base.LinkOptions...), over.LinkOptions...)
+	base.Profile = ""
+
+	return base, nil
+}
+
+// resolveProfiles sets the solution, driver and app profiles from the config
+// file; profileName, when set, replaces the solution-wide named profile.
+func resolveProfiles(settings buildSettings, profileName string) error {
+	solution := settings.buildProfile
+	if profileName != "" {
+		solution.Profile = profileName
+	}
+	if solution.Profile == "" {
+		solution.Profile = DEFAULT_PROFILE_NAME
+	}
+
+	var err error
+	if solutionProfile, err = overlayProfile(buildProfile{}, solution); err != nil {
+		return err
+	}
+
+	// Project profiles keep only their own defines and link options; the
+	// solution-wide ones are already in the common settings.
+	if driverProfile, err = overlayProfile(buildProfile{}, settings.Driver); err != nil {
+		return fmt.Errorf("driver: %v", err)
+	}
+	if appProfile, err = overlayProfile(buildProfile{}, settings.App); err != nil {
+		return fmt.Errorf("app: %v", err)
+	}
+
+	return nil
+}
+
+// profileSettings turns a profile into ClCompile/Link settings.
+func profileSettings(profile buildProfile, config buildConfiguration) projectSettings {
+	settings := projectSettings{}
+
+	add := func(name, value string) {
+		if value != "" {
+			settings.clCompile = append(settings.clCompile, msbuildProperty{name, value})
+		}
+	}
+	add("WarningLevel", profile.WarningLevel)
+	add("TreatWarningAsError", profile.TreatWarningAsError)
+	add("SDLCheck", profile.SDLCheck)
+	add("ConformanceMode", profile.ConformanceMode)
+	if !config.Debug {
+		add("Optimization", profile.Optimization)
+	}
+	if profile.RuntimeLibrary != "" {
+		// MultiThreaded -> MultiThreadedDebug, MultiThreadedDLL -> MultiThreadedDebugDLL
+		runtime := profile.RuntimeLibrary
+		if config.Debug && !strings.Contains(runtime, "Debug") {
+			runtime = strings.Replace(runtime, "MultiThreaded", "MultiThreadedDebug", 1)
+		}
+		add("RuntimeLibrary", runtime)
+	}
+	if len(profile.Defines) > 0 {
+		add("PreprocessorDefinitions", strings.Join(profile.Defines, ";")+";%(PreprocessorDefinitions)")
+	}
+
+	if len(profile.LinkOptions) > 0 {
+		settings.link = append(settings.link, msbuildProperty{"AdditionalOptions", strings.Join(profile.LinkOptions, " ") + " %(AdditionalOptions)"})
+	}
+
+	return settings
+}
+
+// withProjectProfile lays the project's profile over its own defaults. Values
+// that just repeat the common settings are dropped so that they can still be
+// changed in one place.
+func withProjectProfile(settings projectSettings, project buildProfile, config buildConfiguration, platform string) projectSettings {
+	effective, _ := overlayProfile(solutionProfile, buildProfile{
+		WarningLevel:        project.WarningLevel,
+		TreatWarningAsError: project.TreatWarningAsError,
+		SDLCheck:            project.SDLCheck,
+		ConformanceMode:     project.ConformanceMode,
+		Optimization:        project.Optimization,
+		RuntimeLibrary:      project.RuntimeLibrary,
+	})
+	effective.Defines = project.Defines
+	effective.LinkOptions = project.LinkOptions
+
+	profile := profileSettings(effective, config)
+	common := commonSettings(config, platform)
+
+	settings.clCompile = withoutCommon(mergeProperties(settings.clCompile, profile.clCompile), common.clCompile)
+	settings.link = withoutCommon(mergeProperties(settings.link, profile.link), common.link)
+
+	return settings
+}
+
+func withoutCommon(properties, common []msbuildProperty) []msbuildProperty {
+	var result []msbuildProperty
+	for _, property := range properties {
+		duplicate := false
+		for _, commonProperty := range common {
+			if commonProperty == property {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			result = append(result, property)
+		}
+	}
+	return result
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOverlayProfile(t *testing.T) {
+	base := buildProfile{WarningLevel: "Level3", Optimization: "MaxSpeed", Defines: []string{"BASE"}}
+
+	got, err := overlayProfile(base, buildProfile{Profile: "strict", TreatWarningAsError: "false", Defines: []string{"EXTRA"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := buildProfile{
+		WarningLevel:        "Level4",
+		TreatWarningAsError: "false",
+		SDLCheck:            "true",
+		ConformanceMode:     "true",
+		Optimization:        "MaxSpeed",
+		Defines:             []string{"BASE", "EXTRA"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("overlayProfile = %+v, want %+v", got, want)
+	}
+
+	if _, err := overlayProfile(base, buildProfile{Profile: "pedantic"}); err == nil || !strings.Contains(err.Error(), `unknown build profile "pedantic"`) {
+		t.Errorf("overlayProfile with an unknown profile: error %v", err)
+	}
+}
+
+func TestResolveProfiles(t *testing.T) {
+	defer func(solution, driver, app buildProfile) {
+		solutionProfile, driverProfile, appProfile = solution, driver, app
+	}(solutionProfile, driverProfile, appProfile)
+
+	settings := buildSettings{
+		buildProfile: buildProfile{Profile: "legacy", Defines: []string{"SOLUTION"}},
+		Driver:       buildProfile{Profile: "strict", Defines: []string{"DRIVER"}},
+		App:          buildProfile{Optimization: "MinSpace"},
+	}
+
+	if err := resolveProfiles(settings, ""); err != nil {
+		t.Fatal(err)
+	}
+	if solutionProfile.SDLCheck != "false" || strings.Join(solutionProfile.Defines, ";") != "SOLUTION" {
+		t.Errorf("solution profile %+v, want legacy with SOLUTION", solutionProfile)
+	}
+	if driverProfile.WarningLevel != "Level4" || strings.Join(driverProfile.Defines, ";") != "DRIVER" {
+		t.Errorf("driver profile %+v, want strict with its own defines only", driverProfile)
+	}
+	if appProfile.Optimization != "MinSpace" || appProfile.WarningLevel != "" {
+		t.Errorf("app profile %+v, want only its own fields", appProfile)
+	}
+
+	if err := resolveProfiles(settings, "strict"); err != nil {
+		t.Fatal(err)
+	}
+	if solutionProfile.WarningLevel != "Level4" {
+		t.Errorf("-profile strict gives solution profile %+v", solutionProfile)
+	}
+
+	if err := resolveProfiles(buildSettings{}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if solutionProfile.WarningLevel != "Level3" || solutionProfile.TreatWarningAsError != "false" {
+		t.Errorf("no profile gives solution profile %+v, want the default one", solutionProfile)
+	}
+
+	err := resolveProfiles(buildSettings{App: buildProfile{Profile: "pedantic"}}, "")
+	if err == nil || !strings.HasPrefix(err.Error(), "app: ") {
+		t.Errorf("unknown app profile: error %v, want one starting with app:", err)
+	}
+}
+
+func TestProfileSettings(t *testing.T) {
+	profile := buildProfile{
+		WarningLevel:   "Level4",
+		Optimization:   "MinSpace",
+		RuntimeLibrary: "MultiThreadedDLL",
+		Defines:        []string{"A", "B"},
+		LinkOptions:    []string{"/INTEGRITYCHECK"},
+	}
+
+	debug := profileSettings(profile, buildConfiguration{Name: "Debug", Debug: true})
+	release := profileSettings(profile, buildConfiguration{Name: "Release"})
+
+	if value := propertyValue(debug.clCompile, "Optimization"); value != "" {
+		t.Errorf("Debug Optimization = %q, want it left alone", value)
+	}
+	if value := propertyValue(release.clCompile, "Optimization"); value != "MinSpace" {
+		t.Errorf("Release Optimization = %q, want MinSpace", value)
+	}
+	if value := propertyValue(debug.clCompile, "RuntimeLibrary"); value != "MultiThreadedDebugDLL" {
+		t.Errorf("Debug RuntimeLibrary = %q, want MultiThreadedDebugDLL", value)
+	}
+	if value := propertyValue(release.clCompile, "RuntimeLibrary"); value != "MultiThreadedDLL" {
+		t.Errorf("Release RuntimeLibrary = %q, want MultiThreadedDLL", value)
+	}
+	if value := propertyValue(release.clCompile, "PreprocessorDefinitions"); value != "A;B;%(PreprocessorDefinitions)" {
+		t.Errorf("PreprocessorDefinitions = %q", value)
+	}
+	if value := propertyValue(release.link, "AdditionalOptions"); value != "/INTEGRITYCHECK %(AdditionalOptions)" {
+		t.Errorf("AdditionalOptions = %q", value)
+	}
+}
+
+func TestWithProjectProfile(t *testing.T) {
+	defer func(solution buildProfile) { solutionProfile = solution }(solutionProfile)
+	solutionProfile = namedProfiles["default"]
+
+	release := buildConfiguration{Name: "Release"}
+	settings := withProjectProfile(projectSettings{}, buildProfile{WarningLevel: "Level3", TreatWarningAsError: "true"}, release, "x64")
+
+	if value := propertyValue(settings.clCompile, "WarningLevel"); value != "" {
+		t.Errorf("WarningLevel = %q, want it left to the common settings it repeats", value)
+	}
+	if value := propertyValue(settings.clCompile, "TreatWarningAsError"); value != "true" {
+		t.Errorf("TreatWarningAsError = %q, want the project's true", value)
+	}
+}
@@ -7,36 +7,26 @@ VisualStudioVersion = $VSVERSION$
 MinimumVisualStudioVersion = 10.0.40219.1
 Project("{8BC9CEB8-8B4A-11D0-8D11-00A0C91BC942}") = "$PROJECTNAME_SYS$", "$PROJECTNAME_SYS$\$PROJECTNAME_SYS$.vcxproj", "$GUID_SYS$"
 EndProject
+{{- if eq .Features.client "app" }}
 Project("{8BC9CEB8-8B4A-11D0-8D11-00A0C91BC942}") = "MyApp", "MyApp\MyApp.vcxproj", "$GUID_EXE$"
 EndProject
+{{- end }}
+{{- if ne .Features.library "none" }}
+Project("{8BC9CEB8-8B4A-11D0-8D11-00A0C91BC942}") = "$PROJECTNAME_LIB$", "$PROJECTNAME_LIB$\$PROJECTNAME_LIB$.vcxproj", "$GUID_LIB$"
+EndProject
+{{- end }}
 Global
 	GlobalSection(SolutionConfigurationPlatforms) = preSolution
-		Debug|x64 = Debug|x64
-		Debug|x86 = Debug|x86
-		Release|x64 = Release|x64
-		Release|x86 = Release|x86
+$SOLUTION_CONFIGURATION_PLATFORMS$
 	EndGlobalSection
 	GlobalSection(ProjectConfigurationPlatforms) = postSolution
-		$GUID_SYS$.Debug|x64.ActiveCfg = Debug|x64
-		$GUID_SYS$.Debug|x64.Build.0 = Debug|x64
-		$GUID_SYS$.Debug|x64.Deploy.0 = Debug|x64
-		$GUID_SYS$.Debug|x86.ActiveCfg = Debug|Win32
-		$GUID_SYS$.Debug|x86.Build.0 = Debug|Win32
-		$GUID_SYS$.Debug|x86.Deploy.0 = Debug|Win32		
-		$GUID_SYS$.Release|x64.ActiveCfg = Release|x64
-		$GUID_SYS$.Release|x64.Build.0 = Release|x64
-		$GUID_SYS$.Release|x64.Deploy.0 = Release|x64
-		$GUID_SYS$.Release|x86.ActiveCfg = Release|Win32
-		$GUID_SYS$.Release|x86.Build.0 = Release|Win32
-		$GUID_SYS$.Release|x86.Deploy.0 = Release|Win32
-		$GUID_EXE$.Debug|x64.ActiveCfg = Debug|x64
-		$GUID_EXE$.Debug|x64.Build.0 = Debug|x64
-		$GUID_EXE$.Debug|x86.ActiveCfg = Debug|Win32
-		$GUID_EXE$.Debug|x86.Build.0 = Debug|Win32
-		$GUID_EXE$.Release|x64.ActiveCfg = Release|x64
-		$GUID_EXE$.Release|x64.Build.0 = Release|x64
-		$GUID_EXE$.Release|x86.ActiveCfg = Release|Win32
-		$GUID_EXE$.Release|x86.Build.0 = Release|Win32
+$SYS_SOLUTION_CONFIGURATIONS$
+{{- if eq .Features.client "app" }}
+$EXE_SOLUTION_CONFIGURATIONS$
+{{- end }}
+{{- if ne .Features.library "none" }}
+$LIB_SOLUTION_CONFIGURATIONS$
+{{- end }}
 	EndGlobalSection
 	GlobalSection(SolutionProperties) = preSolution
 		HideSolutionNode = FALSE
@@ -51,22 +41,7 @@ const VCXPROJ_SYS_TEMPLATE =
 `<?xml version="1.0" encoding="utf-8"?>
 <Project DefaultTargets="Build" ToolsVersion="12.0" xmlns="http://schemas.microsoft.com/developer/msbuild/2003">
   <ItemGroup Label="ProjectConfigurations">
-    <ProjectConfiguration Include="Debug|Win32">
-      <Configuration>Debug</Configuration>
-      <Platform>Win32</Platform>
-    </ProjectConfiguration>
-    <ProjectConfiguration Include="Release|Win32">
-      <Configuration>Release</Configuration>
-      <Platform>Win32</Platform>
-    </ProjectConfiguration>
-    <ProjectConfiguration Include="Debug|x64">
-      <Configuration>Debug</Configuration>
-      <Platform>x64</Platform>
-    </ProjectConfiguration>
-    <ProjectConfiguration Include="Release|x64">
-      <Configuration>Release</Configuration>
-      <Platform>x64</Platform>
-    </ProjectConfiguration>    
+$SYS_PROJECT_CONFIGURATIONS$
   </ItemGroup>
   <PropertyGroup Label="Globals">
     <ProjectGuid>$GUID_SYS$</ProjectGuid>
@@ -74,102 +49,77 @@ const VCXPROJ_SYS_TEMPLATE =
     <TargetFrameworkVersion>v4.5</TargetFrameworkVersion>
     <MinimumVisualStudioVersion>12.0</MinimumVisualStudioVersion>
     <Configuration>Debug</Configuration>
-    <Platform Condition="'$(Platform)' == ''">Win32</Platform>
+    <Platform Condition="'$(Platform)' == ''">$SYS_DEFAULT_PLATFORM$</Platform>
     <RootNamespace>$PROJECTNAME_SYS$</RootNamespace>
+{{- if ne .Features.props "inline" }}
+    <!-- Directory.Build.props is imported with the property sheets below. -->
+    <ImportDirectoryBuildProps>false</ImportDirectoryBuildProps>
+{{- end }}
   </PropertyGroup>
   <Import Project="$(VCTargetsPath)\Microsoft.Cpp.Default.props" />
-  <PropertyGroup Condition="'$(Configuration)|$(Platform)'=='Debug|Win32'" Label="Configuration">
-    <TargetVersion>Windows10</TargetVersion>
-    <UseDebugLibraries>true</UseDebugLibraries>
-    <PlatformToolset>WindowsKernelModeDriver10.0</PlatformToolset>
-    <ConfigurationType>Driver</ConfigurationType>
-    <DriverType>WDM</DriverType>
-    <SpectreMitigation>false</SpectreMitigation>
-  </PropertyGroup>
-  <PropertyGroup Condition="'$(Configuration)|$(Platform)'=='Release|Win32'" Label="Configuration">
-    <TargetVersion>Windows10</TargetVersion>
-    <UseDebugLibraries>false</UseDebugLibraries>
-    <PlatformToolset>WindowsKernelModeDriver10.0</PlatformToolset>
-    <ConfigurationType>Driver</ConfigurationType>
-    <DriverType>WDM</DriverType>
-  </PropertyGroup>
-  <PropertyGroup Condition="'$(Configuration)|$(Platform)'=='Debug|x64'" Label="Configuration">
-    <TargetVersion>Windows10</TargetVersion>
-    <UseDebugLibraries>true</UseDebugLibraries>
-    <PlatformToolset>WindowsKernelModeDriver10.0</PlatformToolset>
-    <ConfigurationType>Driver</ConfigurationType>
-    <DriverType>WDM</DriverType>
-    <SpectreMitigation>false</SpectreMitigation>
-  </PropertyGroup>
-  <PropertyGroup Condition="'$(Configuration)|$(Platform)'=='Release|x64'" Label="Configuration">
-    <TargetVersion>Windows10</TargetVersion>
-    <UseDebugLibraries>false</UseDebugLibraries>
-    <PlatformToolset>WindowsKernelModeDriver10.0</PlatformToolset>
-    <ConfigurationType>Driver</ConfigurationType>
-    <DriverType>WDM</DriverType>
-  </PropertyGroup>  
+$SYS_CONFIGURATION_GROUPS$
   <Import Project="$(VCTargetsPath)\Microsoft.Cpp.props" />
   <ImportGroup Label="ExtensionSettings">
   </ImportGroup>
   <ImportGroup Label="PropertySheets">
     <Import Project="$(UserRootDir)\Microsoft.Cpp.$(Platform).user.props" Condition="exists('$(UserRootDir)\Microsoft.Cpp.$(Platform).user.props')" Label="LocalAppDataPlatform" />
+{{- if ne .Features.props "inline" }}
+    <Import Project="..\Directory.Build.props" />
+{{- end }}
+{{- if eq .Features.props "project" }}
+    <Import Project="$PROJECTNAME_SYS$.props" />
+{{- end }}
   </ImportGroup>
   <PropertyGroup Label="UserMacros" />
   <PropertyGroup />
-  <PropertyGroup Condition="'$(Configuration)|$(Platform)'=='Debug|Win32'">
-    <DebuggerFlavor>DbgengKernelDebugger</DebuggerFlavor>
-  </PropertyGroup>
-  <PropertyGroup Condition="'$(Configuration)|$(Platform)'=='Release|Win32'">
-    <DebuggerFlavor>DbgengKernelDebugger</DebuggerFlavor>
-  </PropertyGroup>
-  <PropertyGroup Condition="'$(Configuration)|$(Platform)'=='Debug|x64'">
-    <DebuggerFlavor>DbgengKernelDebugger</DebuggerFlavor>
-  </PropertyGroup>
-  <PropertyGroup Condition="'$(Configuration)|$(Platform)'=='Release|x64'">
-    <DebuggerFlavor>DbgengKernelDebugger</DebuggerFlavor>
-  </PropertyGroup>
-  <ItemDefinitionGroup Condition="'$(Configuration)|$(Platform)'=='Debug|Win32'">
-    <ClCompile>
-      <WarningLevel>Level3</WarningLevel>
-      <TreatWarningAsError>false</TreatWarningAsError>
-    </ClCompile>
-  </ItemDefinitionGroup>
-  <ItemDefinitionGroup Condition="'$(Configuration)|$(Platform)'=='Debug|x64'">
+{{- if eq .Features.props "inline" }}
+$SYS_PROPERTY_GROUPS$
+$SYS_ITEM_DEFINITION_GROUPS$
+{{- else if eq .Features.props "shared" }}
+$SYS_PROJECT_PROPERTY_GROUPS$
+$SYS_PROJECT_ITEM_DEFINITION_GROUPS$
+{{- end }}
+{{- if ne .Features.library "none" }}
+  <ItemDefinitionGroup>
     <ClCompile>
-      <WarningLevel>Level3</WarningLevel>
-      <TreatWarningAsError>false</TreatWarningAsError>
-    </ClCompile>
-  </ItemDefinitionGroup>
-  <ItemDefinitionGroup Condition="'$(Configuration)|$(Platform)'=='Release|Win32'">
-    <ClCompile>
-      <WarningLevel>Level3</WarningLevel>
-    </ClCompile>
-  </ItemDefinitionGroup>
-  <ItemDefinitionGroup Condition="'$(Configuration)|$(Platform)'=='Release|Win32'">
-    <ClCompile>
-      <TreatWarningAsError>false</TreatWarningAsError>
-    </ClCompile>
-  </ItemDefinitionGroup>
-  <ItemDefinitionGroup Condition="'$(Configuration)|$(Platform)'=='Release|x64'">
-    <ClCompile>
-      <WarningLevel>Level3</WarningLevel>
-    </ClCompile>
-  </ItemDefinitionGroup>
-  <ItemDefinitionGroup Condition="'$(Configuration)|$(Platform)'=='Release|x64'">
-    <ClCompile>
-      <TreatWarningAsError>false</TreatWarningAsError>
+      <AdditionalIncludeDirectories>..\$PROJECTNAME_LIB$;%(AdditionalIncludeDirectories)</AdditionalIncludeDirectories>
     </ClCompile>
+{{- if eq .Features.library "export" }}
+    <Link>
+      <!-- Import library of the export driver, both projects share OutDir. -->
+      <AdditionalDependencies>$(OutDir)$PROJECTNAME_LIB$.lib;%(AdditionalDependencies)</AdditionalDependencies>
+    </Link>
+{{- end }}
   </ItemDefinitionGroup>
+{{- end }}
   <ItemGroup>
     <FilesToPackage Include="$(TargetPath)" />
   </ItemGroup>
   <ItemGroup>
     <ClInclude Include="..\Common\Common.h" />
     <ClInclude Include="$PROJECTNAME_SYS$.h" />
+{{- if eq .Features.cppruntime "true" }}
+    <ClInclude Include="KernelCpp.h" />
+{{- end }}
   </ItemGroup>
   <ItemGroup>
-    <ClCompile Include="$PROJECTNAME_SYS$.cpp" />
+    <ClCompile Include="$PROJECTNAME_SYS$.{{ .Features.lang }}" />
+{{- if eq .Features.cppruntime "true" }}
+    <ClCompile Include="KernelCpp.cpp" />
+{{- end }}
   </ItemGroup>
+{{- if eq .Features.inf "true" }}
+  <ItemGroup>
+    <Inf Include="$PROJECTNAME_SYS$.inf" />
+  </ItemGroup>
+{{- end }}
+{{- if ne .Features.library "none" }}
+  <ItemGroup>
+    <ProjectReference Include="..\$PROJECTNAME_LIB$\$PROJECTNAME_LIB$.vcxproj">
+      <Project>$GUID_LIB$</Project>
+    </ProjectReference>
+  </ItemGroup>
+{{- end }}
   <Import Project="$(VCTargetsPath)\Microsoft.Cpp.targets" />
   <ImportGroup Label="ExtensionTargets">
   </ImportGroup>
@@ -180,22 +130,7 @@ const VCXPROJ_EXE_TEMPLATE =
 `<?xml version="1.0" encoding="utf-8"?>
 <Project DefaultTargets="Build" xmlns="http://schemas.microsoft.com/developer/msbuild/2003">
   <ItemGroup Label="ProjectConfigurations">
-    <ProjectConfiguration Include="Debug|Win32">
-      <Configuration>Debug</Configuration>
-      <Platform>Win32</Platform>
-    </ProjectConfiguration>
-    <ProjectConfiguration Include="Release|Win32">
-      <Configuration>Release</Configuration>
-      <Platform>Win32</Platform>
-    </ProjectConfiguration>
-    <ProjectConfiguration Include="Debug|x64">
-      <Configuration>Debug</Configuration>
-      <Platform>x64</Platform>
-    </ProjectConfiguration>
-    <ProjectConfiguration Include="Release|x64">
-      <Configuration>Release</Configuration>
-      <Platform>x64</Platform>
-    </ProjectConfiguration>
+$EXE_PROJECT_CONFIGURATIONS$
   </ItemGroup>
   <PropertyGroup Label="Globals">
     <VCProjectVersion>16.0</VCProjectVersion>
@@ -203,136 +138,35 @@ const VCXPROJ_EXE_TEMPLATE =
     <Keyword>Win32Proj</Keyword>
     <RootNamespace>MyApp</RootNamespace>
     <WindowsTargetPlatformVersion>10.0</WindowsTargetPlatformVersion>
+{{- if ne .Features.props "inline" }}
+    <!-- Directory.Build.props is imported with the property sheets below. -->
+    <ImportDirectoryBuildProps>false</ImportDirectoryBuildProps>
+{{- end }}
   </PropertyGroup>
   <Import Project="$(VCTargetsPath)\Microsoft.Cpp.Default.props" />
-  <PropertyGroup Condition="'$(Configuration)|$(Platform)'=='Debug|Win32'" Label="Configuration">
-    <ConfigurationType>Application</ConfigurationType>
-    <UseDebugLibraries>true</UseDebugLibraries>
-    <PlatformToolset>v142</PlatformToolset>
-    <CharacterSet>Unicode</CharacterSet>
-  </PropertyGroup>
-  <PropertyGroup Condition="'$(Configuration)|$(Platform)'=='Release|Win32'" Label="Configuration">
-    <ConfigurationType>Application</ConfigurationType>
-    <UseDebugLibraries>false</UseDebugLibraries>
-    <PlatformToolset>v142</PlatformToolset>
-    <WholeProgramOptimization>true</WholeProgramOptimization>
-    <CharacterSet>Unicode</CharacterSet>
-  </PropertyGroup>
-  <PropertyGroup Condition="'$(Configuration)|$(Platform)'=='Debug|x64'" Label="Configuration">
-    <ConfigurationType>Application</ConfigurationType>
-    <UseDebugLibraries>true</UseDebugLibraries>
-    <PlatformToolset>v142</PlatformToolset>
-    <CharacterSet>Unicode</CharacterSet>
-  </PropertyGroup>
-  <PropertyGroup Condition="'$(Configuration)|$(Platform)'=='Release|x64'" Label="Configuration">
-    <ConfigurationType>Application</ConfigurationType>
-    <UseDebugLibraries>false</UseDebugLibraries>
-    <PlatformToolset>v142</PlatformToolset>
-    <WholeProgramOptimization>true</WholeProgramOptimization>
-    <CharacterSet>Unicode</CharacterSet>
-  </PropertyGroup>
+$EXE_CONFIGURATION_GROUPS$
   <Import Project="$(VCTargetsPath)\Microsoft.Cpp.props" />
   <ImportGroup Label="ExtensionSettings">
   </ImportGroup>
   <ImportGroup Label="Shared">
   </ImportGroup>
-  <ImportGroup Label="PropertySheets" Condition="'$(Configuration)|$(Platform)'=='Debug|Win32'">
-    <Import Project="$(UserRootDir)\Microsoft.Cpp.$(Platform).user.props" Condition="exists('$(UserRootDir)\Microsoft.Cpp.$(Platform).user.props')" Label="LocalAppDataPlatform" />
-  </ImportGroup>
-  <ImportGroup Label="PropertySheets" Condition="'$(Configuration)|$(Platform)'=='Release|Win32'">
-    <Import Project="$(UserRootDir)\Microsoft.Cpp.$(Platform).user.props" Condition="exists('$(UserRootDir)\Microsoft.Cpp.$(Platform).user.props')" Label="LocalAppDataPlatform" />
-  </ImportGroup>
-  <ImportGroup Label="PropertySheets" Condition="'$(Configuration)|$(Platform)'=='Debug|x64'">
-    <Import Project="$(UserRootDir)\Microsoft.Cpp.$(Platform).user.props" Condition="exists('$(UserRootDir)\Microsoft.Cpp.$(Platform).user.props')" Label="LocalAppDataPlatform" />
-  </ImportGroup>
-  <ImportGroup Label="PropertySheets" Condition="'$(Configuration)|$(Platform)'=='Release|x64'">
+  <ImportGroup Label="PropertySheets">
     <Import Project="$(UserRootDir)\Microsoft.Cpp.$(Platform).user.props" Condition="exists('$(UserRootDir)\Microsoft.Cpp.$(Platform).user.props')" Label="LocalAppDataPlatform" />
+{{- if ne .Features.props "inline" }}
+    <Import Project="..\Directory.Build.props" />
+{{- end }}
+{{- if eq .Features.props "project" }}
+    <Import Project="MyApp.props" />
+{{- end }}
   </ImportGroup>
   <PropertyGroup Label="UserMacros" />
-  <PropertyGroup Condition="'$(Configuration)|$(Platform)'=='Debug|x64'">
-    <LinkIncremental>true</LinkIncremental>
-  </PropertyGroup>
-  <PropertyGroup Condition="'$(Configuration)|$(Platform)'=='Debug|Win32'">
-    <LinkIncremental>true</LinkIncremental>
-  </PropertyGroup>
-  <PropertyGroup Condition="'$(Configuration)|$(Platform)'=='Release|Win32'">
-    <LinkIncremental>false</LinkIncremental>
-  </PropertyGroup>
-  <PropertyGroup Condition="'$(Configuration)|$(Platform)'=='Release|x64'">
-    <LinkIncremental>false</LinkIncremental>
-  </PropertyGroup>
-  <ItemDefinitionGroup Condition="'$(Configuration)|$(Platform)'=='Debug|x64'">
-    <ClCompile>
-      <PrecompiledHeader>
-      </PrecompiledHeader>
-      <WarningLevel>Level3</WarningLevel>
-      <Optimization>Disabled</Optimization>
-      <SDLCheck>true</SDLCheck>
-      <PreprocessorDefinitions>_DEBUG;_CONSOLE;%(PreprocessorDefinitions)</PreprocessorDefinitions>
-      <ConformanceMode>true</ConformanceMode>
-      <RuntimeLibrary>MultiThreadedDebug</RuntimeLibrary>
-    </ClCompile>
-    <Link>
-      <SubSystem>Console</SubSystem>
-      <GenerateDebugInformation>true</GenerateDebugInformation>
-    </Link>
-  </ItemDefinitionGroup>
-  <ItemDefinitionGroup Condition="'$(Configuration)|$(Platform)'=='Debug|Win32'">
-    <ClCompile>
-      <PrecompiledHeader>
-      </PrecompiledHeader>
-      <WarningLevel>Level3</WarningLevel>
-      <Optimization>Disabled</Optimization>
-      <SDLCheck>true</SDLCheck>
-      <PreprocessorDefinitions>WIN32;_DEBUG;_CONSOLE;%(PreprocessorDefinitions)</PreprocessorDefinitions>
-      <ConformanceMode>true</ConformanceMode>
-      <RuntimeLibrary>MultiThreadedDebug</RuntimeLibrary>
-    </ClCompile>
-    <Link>
-      <SubSystem>Console</SubSystem>
-      <GenerateDebugInformation>true</GenerateDebugInformation>
-    </Link>
-  </ItemDefinitionGroup>
-  <ItemDefinitionGroup Condition="'$(Configuration)|$(Platform)'=='Release|Win32'">
-    <ClCompile>
-      <PrecompiledHeader>
-      </PrecompiledHeader>
-      <WarningLevel>Level3</WarningLevel>
-      <Optimization>MaxSpeed</Optimization>
-      <FunctionLevelLinking>true</FunctionLevelLinking>
-      <IntrinsicFunctions>true</IntrinsicFunctions>
-      <SDLCheck>true</SDLCheck>
-      <PreprocessorDefinitions>WIN32;NDEBUG;_CONSOLE;%(PreprocessorDefinitions)</PreprocessorDefinitions>
-      <ConformanceMode>true</ConformanceMode>
-      <RuntimeLibrary>MultiThreaded</RuntimeLibrary>
-    </ClCompile>
-    <Link>
-      <SubSystem>Console</SubSystem>
-      <EnableCOMDATFolding>true</EnableCOMDATFolding>
-      <OptimizeReferences>true</OptimizeReferences>
-      <GenerateDebugInformation>true</GenerateDebugInformation>
-    </Link>
-  </ItemDefinitionGroup>
-  <ItemDefinitionGroup Condition="'$(Configuration)|$(Platform)'=='Release|x64'">
-    <ClCompile>
-      <PrecompiledHeader>
-      </PrecompiledHeader>
-      <WarningLevel>Level3</WarningLevel>
-      <Optimization>MaxSpeed</Optimization>
-      <FunctionLevelLinking>true</FunctionLevelLinking>
-      <IntrinsicFunctions>true</IntrinsicFunctions>
-      <SDLCheck>true</SDLCheck>
-      <PreprocessorDefinitions>NDEBUG;_CONSOLE;%(PreprocessorDefinitions)</PreprocessorDefinitions>
-      <ConformanceMode>true</ConformanceMode>
-      <RuntimeLibrary>MultiThreaded</RuntimeLibrary>
-    </ClCompile>
-    <Link>
-      <SubSystem>Console</SubSystem>
-      <EnableCOMDATFolding>true</EnableCOMDATFolding>
-      <OptimizeReferences>true</OptimizeReferences>
-      <GenerateDebugInformation>true</GenerateDebugInformation>
-    </Link>
-  </ItemDefinitionGroup>
+{{- if eq .Features.props "inline" }}
+$EXE_PROPERTY_GROUPS$
+$EXE_ITEM_DEFINITION_GROUPS$
+{{- else if eq .Features.props "shared" }}
+$EXE_PROJECT_PROPERTY_GROUPS$
+$EXE_PROJECT_ITEM_DEFINITION_GROUPS$
+{{- end }}
   <ItemGroup>
     <ClCompile Include="MyApp.cpp" />
   </ItemGroup>
@@ -364,20 +198,43 @@ const VCXPROJFILTER_SYS_TEMPLATE =
     <Filter Include="Common">
       <UniqueIdentifier>$GUID_RANDOM$</UniqueIdentifier>
     </Filter>
+{{- if eq .Features.inf "true" }}
+    <Filter Include="Driver Files">
+      <UniqueIdentifier>{8E41214B-6785-4CFE-B992-037D68949A14}</UniqueIdentifier>
+      <Extensions>inf;inv;inx;mof;mc;</Extensions>
+    </Filter>
+{{- end }}
   </ItemGroup>
   <ItemGroup>
     <ClInclude Include="$PROJECTNAME_SYS$.h">
       <Filter>Header Files</Filter>
     </ClInclude>
+{{- if eq .Features.cppruntime "true" }}
+    <ClInclude Include="KernelCpp.h">
+      <Filter>Header Files</Filter>
+    </ClInclude>
+{{- end }}
     <ClInclude Include="..\Common\Common.h">
       <Filter>Common</Filter>
     </ClInclude>
   </ItemGroup>
   <ItemGroup>
-    <ClCompile Include="$PROJECTNAME_SYS$.cpp">
+    <ClCompile Include="$PROJECTNAME_SYS$.{{ .Features.lang }}">
+      <Filter>Source Files</Filter>
+    </ClCompile>
+{{- if eq .Features.cppruntime "true" }}
+    <ClCompile Include="KernelCpp.cpp">
       <Filter>Source Files</Filter>
     </ClCompile>
+{{- end }}
   </ItemGroup>
+{{- if eq .Features.inf "true" }}
+  <ItemGroup>
+    <Inf Include="$PROJECTNAME_SYS$.inf">
+      <Filter>Driver Files</Filter>
+    </Inf>
+  </ItemGroup>
+{{- end }}
 </Project>
 `
 
@@ -412,4 +269,85 @@ const VCXPROJFILTER_EXE_TEMPLATE =
     </ClInclude>
   </ItemGroup>
 </Project>
-`
\ No newline at end of file
+`
+const DRIVER_INF_TEMPLATE =
+`;
+; $PROJECTNAME_SYS$.inf
+;
+; Installs $TARGETNAME_SYS$.sys as a demand-start legacy kernel service.
+;   rundll32 setupapi.dll,InstallHinfSection DefaultInstall 132 $PROJECTNAME_SYS$.inf
+;
+
+[Version]
+Signature   = "$WINDOWS NT$"
+Class       = System
+ClassGuid   = {4d36e97d-e325-11ce-bfc1-08002be10318}
+Provider    = %ManufacturerName%
+DriverVer   =
+CatalogFile = $PROJECTNAME_SYS$.cat
+PnpLockdown = 1
+
+[DestinationDirs]
+DefaultDestDir = 12
+
+[SourceDisksNames]
+1 = %DiskName%,,,""
+
+[SourceDisksFiles]
+$TARGETNAME_SYS$.sys = 1,,
+
+[DefaultInstall.NT$ARCH$]
+CopyFiles = Drivers_Dir
+
+[DefaultInstall.NT$ARCH$.Services]
+AddService = $PROJECTNAME_SYS$,,Service_Install
+
+[DefaultUninstall.NT$ARCH$]
+LegacyUninstall = 1
+DelFiles = Drivers_Dir
+
+[DefaultUninstall.NT$ARCH$.Services]
+DelService = $PROJECTNAME_SYS$,0x200
+
+[Drivers_Dir]
+$TARGETNAME_SYS$.sys
+
+[Service_Install]
+DisplayName    = %ServiceName%
+ServiceType    = 1               ; SERVICE_KERNEL_DRIVER
+StartType      = 3               ; SERVICE_DEMAND_START
+ErrorControl   = 1               ; SERVICE_ERROR_NORMAL
+ServiceBinary  = %12%\$TARGETNAME_SYS$.sys
+
+[Strings]
+ManufacturerName = "{{ or .Vars.Company .ProjectName }}"
+DiskName         = "$PROJECTNAME_SYS$ Installation Disk"
+ServiceName      = "$PROJECTNAME_SYS$ Service"
+`
+
+const DIRECTORY_BUILD_PROPS_TEMPLATE =
+`<?xml version="1.0" encoding="utf-8"?>
+<!-- Settings shared by every project of the $PROJECTNAME_SYS$ solution. -->
+<Project ToolsVersion="4.0" xmlns="http://schemas.microsoft.com/developer/msbuild/2003">
+$COMMON_PROPERTY_GROUPS$
+$COMMON_ITEM_DEFINITION_GROUPS$
+</Project>
+`
+
+const PROPS_SYS_TEMPLATE =
+`<?xml version="1.0" encoding="utf-8"?>
+<!-- $PROJECTNAME_SYS$ driver settings, imported after Directory.Build.props. -->
+<Project ToolsVersion="4.0" xmlns="http://schemas.microsoft.com/developer/msbuild/2003">
+$SYS_PROJECT_PROPERTY_GROUPS$
+$SYS_PROJECT_ITEM_DEFINITION_GROUPS$
+</Project>
+`
+
+const PROPS_EXE_TEMPLATE =
+`<?xml version="1.0" encoding="utf-8"?>
+<!-- MyApp settings, imported after Directory.Build.props. -->
+<Project ToolsVersion="4.0" xmlns="http://schemas.microsoft.com/developer/msbuild/2003">
+$EXE_PROJECT_PROPERTY_GROUPS$
+$EXE_PROJECT_ITEM_DEFINITION_GROUPS$
+</Project>
+`
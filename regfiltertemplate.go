@@ -0,0 +1,523 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const REGFILTER_PACK_NAME = `regfilter`
+
+// regNotifyInfo maps the REG_NOTIFY_CLASS operations Operations may list,
+// RegNt* without the prefix, to the structure Argument2 points to.
+var regNotifyInfo = map[string]string{
+	"PreDeleteKey":             "REG_DELETE_KEY_INFORMATION",
+	"PreSetValueKey":           "REG_SET_VALUE_KEY_INFORMATION",
+	"PreDeleteValueKey":        "REG_DELETE_VALUE_KEY_INFORMATION",
+	"PreSetInformationKey":     "REG_SET_INFORMATION_KEY_INFORMATION",
+	"PreRenameKey":             "REG_RENAME_KEY_INFORMATION",
+	"PreEnumerateKey":          "REG_ENUMERATE_KEY_INFORMATION",
+	"PreEnumerateValueKey":     "REG_ENUMERATE_VALUE_KEY_INFORMATION",
+	"PreQueryKey":              "REG_QUERY_KEY_INFORMATION",
+	"PreQueryValueKey":         "REG_QUERY_VALUE_KEY_INFORMATION",
+	"PreQueryMultipleValueKey": "REG_QUERY_MULTIPLE_VALUE_KEY_INFORMATION",
+	"PreCreateKeyEx":           "REG_CREATE_KEY_INFORMATION",
+	"PreOpenKeyEx":             "REG_OPEN_KEY_INFORMATION",
+	"PreKeyHandleClose":        "REG_KEY_HANDLE_CLOSE_INFORMATION",
+	"PreFlushKey":              "REG_FLUSH_KEY_INFORMATION",
+	"PreLoadKey":               "REG_LOAD_KEY_INFORMATION",
+	"PreUnLoadKey":             "REG_UNLOAD_KEY_INFORMATION",
+	"PreQueryKeySecurity":      "REG_QUERY_KEY_SECURITY_INFORMATION",
+	"PreSetKeySecurity":        "REG_SET_KEY_SECURITY_INFORMATION",
+	"PreSaveKey":               "REG_SAVE_KEY_INFORMATION",
+	"PreRestoreKey":            "REG_RESTORE_KEY_INFORMATION",
+	"PreReplaceKey":            "REG_REPLACE_KEY_INFORMATION",
+	"PostDeleteKey":            "REG_POST_OPERATION_INFORMATION",
+	"PostSetValueKey":          "REG_POST_OPERATION_INFORMATION",
+	"PostDeleteValueKey":       "REG_POST_OPERATION_INFORMATION",
+	"PostSetInformationKey":    "REG_POST_OPERATION_INFORMATION",
+	"PostRenameKey":            "REG_POST_OPERATION_INFORMATION",
+	"PostEnumerateKey":         "REG_POST_OPERATION_INFORMATION",
+	"PostEnumerateValueKey":    "REG_POST_OPERATION_INFORMATION",
+	"PostQueryKey":             "REG_POST_OPERATION_INFORMATION",
+	"PostQueryValueKey":        "REG_POST_OPERATION_INFORMATION",
+	"PostCreateKeyEx":          "REG_POST_OPERATION_INFORMATION",
+	"PostOpenKeyEx":            "REG_POST_OPERATION_INFORMATION",
+}
+
+func regOperationsRegex() string {
+	operations := make([]string, 0, len(regNotifyInfo))
+	for operation := range regNotifyInfo {
+		operations = append(operations, operation)
+	}
+	sort.Strings(operations)
+	alternatives := `(` + strings.Join(operations, "|") + `)`
+	return `^` + alternatives + `(,` + alternatives + `)*$`
+}
+
+// toRegInfo returns the Argument2 structure of a RegNt* operation.
+func toRegInfo(operation string) (string, error) {
+	info, ok := regNotifyInfo[operation]
+	if !ok {
+		return "", fmt.Errorf("regInfo: unknown operation %q", operation)
+	}
+	return info, nil
+}
+
+var regFilterModel = driverModel{
+	Name:        REGFILTER_PACK_NAME,
+	Description: "CmRegisterCallbackEx registry filter with an IOCTL-configured key policy",
+	NoAlloc:     true,
+	Variables: []packVariable{
+		{Name: "Altitude", Type: VAR_TYPE_STRING, Default: "360000", Regex: `^[1-9][0-9]{4,5}(\.[0-9]+)?$`, Description: "altitude of the registry callback, unique among the loaded drivers"},
+		{Name: "Operations", Type: VAR_TYPE_STRING, Default: "PreSetValueKey,PreDeleteValueKey,PreDeleteKey", Regex: regOperationsRegex(), Description: "comma-separated REG_NOTIFY_CLASS values without the RegNt prefix that get a stub"},
+	},
+	Settings: []packSettings{wdmsecSettings},
+	Header:   REGFILTER_DRIVER_HEADER_TEMPLATE,
+	Source:   REGFILTER_DRIVER_SOURCE_TEMPLATE,
+	Inf:      DRIVER_INF_TEMPLATE,
+	Client:   REGFILTER_EXE_CPP_TEMPLATE,
+	Common:   REGFILTER_COMMON_HEADER_TEMPLATE,
+}
+
+const REGFILTER_DRIVER_HEADER_TEMPLATE =
+`#pragma once
+
+{{ if eq .Features.lang "cpp" -}}
+extern "C"
+{
+{{ end -}}
+#include <ntddk.h>
+#include <wdm.h>
+#include <wdmsec.h>
+#include "..\Common\Common.h"
+{{- if eq .Features.lang "cpp" }}
+}
+{{- end }}
+{{- if eq .Features.cppruntime "true" }}
+
+#include "KernelCpp.h"
+{{- end }}
+
+#ifndef _FN_
+#define _FN_	__FUNCTION__
+#endif
+
+#ifndef _LN_
+#define _LN_	__LINE__
+#endif
+
+#define CALLBACK_ALTITUDE		L"{{ .Vars.Altitude }}"
+` + CONTROL_DEVICE_HEADER_TEMPLATE + `
+NTSTATUS
+RegistryCallback(
+	IN	PVOID				pCallbackContext,
+	IN	PVOID				pArgument1,
+	IN	PVOID				pArgument2
+);
+{{- range list .Vars.Operations }}
+
+NTSTATUS
+{{ . }}(
+	IN	P{{ regInfo . }}	pInfo
+);
+{{- end }}
+
+NTSTATUS
+GetKeyObjectName(
+	IN	PVOID				pObject,
+	OUT	PCUNICODE_STRING*	ppName
+);
+
+void
+ReleaseKeyObjectName(
+	IN	PCUNICODE_STRING	pName
+);
+
+NTSTATUS
+CheckPolicy(
+	IN	PVOID				pObject
+);
+
+NTSTATUS
+SetPolicy(
+	IN	P{{ upperSnake .ProjectName }}_POLICY	pPolicy
+);
+`
+
+const REGFILTER_DRIVER_SOURCE_TEMPLATE =
+`#include "$PROJECTNAME_SYS$.h"
+{{- $name := upperSnake .ProjectName }}
+
+
+LARGE_INTEGER gCookie = { 0 };
+BOOLEAN gCallbackRegistered = FALSE;
+
+// gPolicy is read by the callbacks and replaced by IOCTL_{{ $name }}_SET_POLICY.
+{{ $name }}_POLICY gPolicy = { 0 };
+UNICODE_STRING gPolicyKeyPrefix = { 0 };
+ERESOURCE gPolicyLock;
+
+
+EXTERN_C
+NTSTATUS
+DriverEntry(
+	IN	PDRIVER_OBJECT		pDriverObject,
+	IN	PUNICODE_STRING		pRegistryPath
+)
+{
+	NTSTATUS status = STATUS_UNSUCCESSFUL;
+
+	UNICODE_STRING altitude = RTL_CONSTANT_STRING(CALLBACK_ALTITUDE);
+
+	UNREFERENCED_PARAMETER(pRegistryPath);
+
+	KdPrint(("[%s:%d]\n", _FN_, _LN_));
+
+	status = ExInitializeResourceLite(&gPolicyLock);
+	if (!NT_SUCCESS(status))
+	{
+		return status;
+	}
+
+	do
+	{
+		// Only administrators may change the policy.
+		status = CreateControlDevice(pDriverObject);
+		if (!NT_SUCCESS(status))
+		{
+			break;
+		}
+
+		pDriverObject->DriverUnload = UnloadRoutine;
+
+		// STATUS_FLT_INSTANCE_ALTITUDE_COLLISION means another driver has the altitude.
+		status = CmRegisterCallbackEx(RegistryCallback, &altitude, pDriverObject, NULL, &gCookie, NULL);
+		if (!NT_SUCCESS(status))
+		{
+			break;
+		}
+		gCallbackRegistered = TRUE;
+
+	} while (FALSE);
+
+	if (!NT_SUCCESS(status))
+	{
+		KdPrint(("[%s:%d] failed. status : 0x%X\n", _FN_, _LN_, status));
+
+		DeleteControlDevice(pDriverObject);
+		ExDeleteResourceLite(&gPolicyLock);
+	}
+
+	return status;
+}
+
+
+void
+UnloadRoutine(
+	IN	PDRIVER_OBJECT		pDriverObject
+)
+{
+	KdPrint(("[%s:%d]\n", _FN_, _LN_));
+
+	// Waits for the callbacks in flight.
+	if (gCallbackRegistered)
+	{
+		CmUnRegisterCallback(gCookie);
+		gCallbackRegistered = FALSE;
+	}
+
+	DeleteControlDevice(pDriverObject);
+
+	ExDeleteResourceLite(&gPolicyLock);
+
+	return;
+}
+
+
+// RegistryCallback hands each selected REG_NOTIFY_CLASS to its stub with
+// Argument2 cast to the matching structure.
+NTSTATUS
+RegistryCallback(
+	IN	PVOID				pCallbackContext,
+	IN	PVOID				pArgument1,
+	IN	PVOID				pArgument2
+)
+{
+	REG_NOTIFY_CLASS notifyClass = (REG_NOTIFY_CLASS)(ULONG_PTR)pArgument1;
+
+	UNREFERENCED_PARAMETER(pCallbackContext);
+
+	switch (notifyClass)
+	{
+{{- range list .Vars.Operations }}
+	case RegNt{{ . }}:
+		return {{ . }}((P{{ regInfo . }})pArgument2);
+{{- end }}
+	default:
+		return STATUS_SUCCESS;
+	}
+}
+{{- range list .Vars.Operations }}
+{{- if eq . "PreCreateKeyEx" "PreOpenKeyEx" }}
+
+
+NTSTATUS
+{{ . }}(
+	IN	P{{ regInfo . }}	pInfo
+)
+{
+	// The key doesn't exist as an object yet, only its name relative to RootObject.
+	KdPrint(("[%s:%d] %wZ\n", _FN_, _LN_, pInfo->CompleteName));
+
+	return STATUS_SUCCESS;
+}
+{{- else if eq (regInfo .) "REG_POST_OPERATION_INFORMATION" }}
+
+
+NTSTATUS
+{{ . }}(
+	IN	P{{ regInfo . }}	pInfo
+)
+{
+	PCUNICODE_STRING pName = NULL;
+
+	// Object is not valid when the operation failed.
+	if (!NT_SUCCESS(pInfo->Status) || pInfo->Object == NULL)
+	{
+		return STATUS_SUCCESS;
+	}
+
+	if (NT_SUCCESS(GetKeyObjectName(pInfo->Object, &pName)))
+	{
+		KdPrint(("[%s:%d] %wZ\n", _FN_, _LN_, pName));
+		ReleaseKeyObjectName(pName);
+	}
+
+	return STATUS_SUCCESS;
+}
+{{- else }}
+
+
+NTSTATUS
+{{ . }}(
+	IN	P{{ regInfo . }}	pInfo
+)
+{
+	return CheckPolicy(pInfo->Object);
+}
+{{- end }}
+{{- end }}
+
+
+// GetKeyObjectName returns the full name of a key object. Free it with
+// ReleaseKeyObjectName.
+NTSTATUS
+GetKeyObjectName(
+	IN	PVOID				pObject,
+	OUT	PCUNICODE_STRING*	ppName
+)
+{
+	return CmCallbackGetKeyObjectIDEx(&gCookie, pObject, NULL, ppName, 0);
+}
+
+
+void
+ReleaseKeyObjectName(
+	IN	PCUNICODE_STRING	pName
+)
+{
+	CmCallbackReleaseKeyObjectIDEx(pName);
+}
+
+
+// CheckPolicy denies or reports operations on keys below the policy prefix.
+NTSTATUS
+CheckPolicy(
+	IN	PVOID				pObject
+)
+{
+	NTSTATUS status = STATUS_SUCCESS;
+	PCUNICODE_STRING pName = NULL;
+
+	if (gPolicy.mode == {{ $name }}_POLICY_OFF)
+	{
+		return STATUS_SUCCESS;
+	}
+
+	if (!NT_SUCCESS(GetKeyObjectName(pObject, &pName)))
+	{
+		return STATUS_SUCCESS;
+	}
+
+	KeEnterCriticalRegion();
+	ExAcquireResourceSharedLite(&gPolicyLock, TRUE);
+
+	if (gPolicy.mode != {{ $name }}_POLICY_OFF && RtlPrefixUnicodeString(&gPolicyKeyPrefix, pName, TRUE))
+	{
+		KdPrint(("[%s:%d] %wZ\n", _FN_, _LN_, pName));
+		if (gPolicy.mode == {{ $name }}_POLICY_BLOCK)
+		{
+			status = STATUS_ACCESS_DENIED;
+		}
+	}
+
+	ExReleaseResourceLite(&gPolicyLock);
+	KeLeaveCriticalRegion();
+
+	ReleaseKeyObjectName(pName);
+
+	return status;
+}
+
+
+NTSTATUS
+SetPolicy(
+	IN	P{{ $name }}_POLICY	pPolicy
+)
+{
+	if (pPolicy->mode > {{ $name }}_POLICY_BLOCK)
+	{
+		return STATUS_INVALID_PARAMETER;
+	}
+
+	KeEnterCriticalRegion();
+	ExAcquireResourceExclusiveLite(&gPolicyLock, TRUE);
+
+	gPolicy = *pPolicy;
+	gPolicy.keyPrefix[{{ $name }}_KEY_PREFIX_LENGTH - 1] = L'\0';
+	RtlInitUnicodeString(&gPolicyKeyPrefix, gPolicy.keyPrefix);
+
+	ExReleaseResourceLite(&gPolicyLock);
+	KeLeaveCriticalRegion();
+
+	return STATUS_SUCCESS;
+}
+` + CONTROL_DEVICE_SOURCE_TEMPLATE + `
+
+NTSTATUS
+DeviceIoControlRoutine(
+	IN	PDEVICE_OBJECT		pDeviceObject,
+	IN	PIRP				pIrp
+)
+{
+	NTSTATUS status = STATUS_UNSUCCESSFUL;
+
+	PIO_STACK_LOCATION pStack = IoGetCurrentIrpStackLocation(pIrp);
+	ULONG ioCtlCode = pStack->Parameters.DeviceIoControl.IoControlCode;
+	ULONG inSize = pStack->Parameters.DeviceIoControl.InputBufferLength;
+	PVOID pInBuffer = pIrp->AssociatedIrp.SystemBuffer;
+
+	UNREFERENCED_PARAMETER(pDeviceObject);
+
+	switch (ioCtlCode)
+	{
+	case IOCTL_{{ upperSnake .ProjectName }}_SET_POLICY:
+	{
+		if (pInBuffer == NULL || inSize != sizeof({{ upperSnake .ProjectName }}_POLICY))
+		{
+			status = STATUS_INVALID_PARAMETER;
+			break;
+		}
+
+		status = SetPolicy((P{{ upperSnake .ProjectName }}_POLICY)pInBuffer);
+		break;
+	}
+	default:
+		status = STATUS_INVALID_DEVICE_REQUEST;
+		break;
+	}
+
+	pIrp->IoStatus.Status = status;
+	pIrp->IoStatus.Information = 0;
+	IoCompleteRequest(pIrp, IO_NO_INCREMENT);
+
+	return status;
+}
+`
+
+const REGFILTER_COMMON_HEADER_TEMPLATE =
+`#pragma once
+{{- $name := upperSnake .ProjectName }}
+
+// Input buffer is a {{ $name }}_POLICY.
+#define IOCTL_{{ $name }}_SET_POLICY		CTL_CODE({{ .Vars.DeviceType }}, {{ ctlFunction 0 }}, METHOD_BUFFERED, FILE_WRITE_ACCESS)
+
+#define {{ $name }}_POLICY_OFF			0
+#define {{ $name }}_POLICY_AUDIT		1
+#define {{ $name }}_POLICY_BLOCK		2
+
+#define {{ $name }}_KEY_PREFIX_LENGTH	256
+
+#pragma pack (push, 1)
+
+// keyPrefix is a kernel key path such as \REGISTRY\MACHINE\SOFTWARE\Contoso,
+// matched without case.
+typedef struct _{{ $name }}_POLICY
+{
+    ULONG               mode;
+    WCHAR               keyPrefix[{{ $name }}_KEY_PREFIX_LENGTH];
+
+} {{ $name }}_POLICY, *P{{ $name }}_POLICY;
+
+#pragma pack (pop)
+`
+
+const REGFILTER_EXE_CPP_TEMPLATE =
+`#include <iostream>
+#include <string>
+#include <Windows.h>
+#include "../Common/Common.h"
+{{- $name := upperSnake .ProjectName }}
+
+int wmain(int argc, wchar_t* argv[])
+{
+	HANDLE deviceHandle = INVALID_HANDLE_VALUE;
+	{{ $name }}_POLICY policy = { 0 };
+	int result = 1;
+
+	std::wstring mode = argc > 1 ? argv[1] : L"";
+	if (mode == L"off" && argc == 2)
+	{
+		policy.mode = {{ $name }}_POLICY_OFF;
+	}
+	else if ((mode == L"audit" || mode == L"block") && argc == 3 && wcslen(argv[2]) < {{ $name }}_KEY_PREFIX_LENGTH)
+	{
+		policy.mode = mode == L"audit" ? {{ $name }}_POLICY_AUDIT : {{ $name }}_POLICY_BLOCK;
+		wcscpy_s(policy.keyPrefix, argv[2]);
+	}
+	else
+	{
+		std::wcerr << L"usage: " << argv[0] << L" off | audit|block \\REGISTRY\\MACHINE\\SOFTWARE\\<key>" << std::endl;
+		return 1;
+	}
+
+	do
+	{
+		deviceHandle = CreateFile(L"\\\\.\\$PROJECTNAME_SYS$", GENERIC_READ | GENERIC_WRITE, 0, nullptr, OPEN_EXISTING, FILE_ATTRIBUTE_NORMAL, nullptr);
+		if (deviceHandle == INVALID_HANDLE_VALUE)
+		{
+			std::wcerr << L"CreateFile failed. error : " << GetLastError() << std::endl;
+			break;
+		}
+
+		DWORD retSize = 0;
+		if (!DeviceIoControl(deviceHandle, IOCTL_{{ $name }}_SET_POLICY, (LPVOID)&policy, sizeof(policy), nullptr, 0, &retSize, nullptr))
+		{
+			std::wcerr << L"DeviceIoControl failed. error : " << GetLastError() << std::endl;
+			break;
+		}
+
+		result = 0;
+
+	} while (false);
+
+	if (deviceHandle != INVALID_HANDLE_VALUE)
+	{
+		CloseHandle(deviceHandle);
+		deviceHandle = INVALID_HANDLE_VALUE;
+	}
+
+	return result;
+}
+`
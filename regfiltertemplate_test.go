@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegFilterRepeatedOperations(t *testing.T) {
+	pack := builtinPacks[REGFILTER_PACK_NAME]
+
+	vars, err := resolveVariables(pack, nil, map[string]string{"Operations": "PreSetValueKey,PreDeleteKey,PreSetValueKey"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	features, err := resolveFeatures(pack, nil, map[string]string{"lang": "c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := &templateData{ProjectName: LINT_SAMPLE_NAME, Vars: vars, Features: features}
+	files, err := renderPackFiles(pack, makeMarks(LINT_SAMPLE_NAME, LINT_SAMPLE_VSVERSION), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var source string
+	for _, file := range files {
+		if file.path == LINT_SAMPLE_NAME+"/"+LINT_SAMPLE_NAME+".c" {
+			source = file.contents
+		}
+	}
+	if source == "" {
+		t.Fatal("no driver source rendered")
+	}
+
+	for _, text := range []string{
+		"case RegNtPreSetValueKey:",
+		"case RegNtPreDeleteKey:",
+		"\nPreSetValueKey(\n",
+		"\nPreDeleteKey(\n",
+	} {
+		if count := strings.Count(source, text); count != 1 {
+			t.Errorf("%q appears %d times, want once", text, count)
+		}
+	}
+}
+
+func TestRegFilterLint(t *testing.T) {
+	pack := builtinPacks[REGFILTER_PACK_NAME]
+	vars := map[string]string{"Altitude": "385100", "Operations": "PreCreateKeyEx,PostSetValueKey", "DeviceType": "FILE_DEVICE_NETWORK"}
+
+	for _, problem := range lintPack(pack, vars) {
+		t.Error(problem)
+	}
+
+	files := renderTestPack(t, pack, vars, nil)
+	if !strings.Contains(files[LINT_SAMPLE_NAME+"/"+LINT_SAMPLE_NAME+".vcxproj"], "wdmsec.lib;") {
+		t.Errorf("the driver does not link wdmsec.lib")
+	}
+	source := files[LINT_SAMPLE_NAME+"/"+LINT_SAMPLE_NAME+".cpp"]
+	for _, text := range []string{"case RegNtPreCreateKeyEx:", "case RegNtPostSetValueKey:", "IoCreateDeviceSecure("} {
+		if !strings.Contains(source, text) {
+			t.Errorf("the driver source lacks %s", text)
+		}
+	}
+	if strings.Contains(files[LINT_SAMPLE_NAME+"/"+LINT_SAMPLE_NAME+".h"], "ALLOC_NONPAGED") {
+		t.Errorf("the driver allocates nothing, but its header defines ALLOC_NONPAGED")
+	}
+}
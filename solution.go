@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	MARK_SOLUTION_CONFIGURATION_PLATFORMS = `$SOLUTION_CONFIGURATION_PLATFORMS$`
+	MARK_SYS_SOLUTION_CONFIGURATIONS      = `$SYS_SOLUTION_CONFIGURATIONS$`
+	MARK_EXE_SOLUTION_CONFIGURATIONS      = `$EXE_SOLUTION_CONFIGURATIONS$`
+	MARK_LIB_SOLUTION_CONFIGURATIONS      = `$LIB_SOLUTION_CONFIGURATIONS$`
+)
+
+// solutionPlatforms returns every platform in .sln order, which Visual Studio
+// keeps sorted by solution platform name.
+func solutionPlatforms() []buildPlatform {
+	sorted := append([]buildPlatform(nil), platforms...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].SolutionName < sorted[j].SolutionName
+	})
+	return sorted
+}
+
+// solutionConfigurationPlatforms lists the SolutionConfigurationPlatforms entries.
+func solutionConfigurationPlatforms() string {
+	var lines []string
+	for _, config := range configurations {
+		for _, platform := range solutionPlatforms() {
+			lines = append(lines, fmt.Sprintf("\t\t%s|%s = %s|%s", config.Name, platform.SolutionName, config.Name, platform.SolutionName))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// projectConfigurationPlatforms maps every solution configuration onto the
+// project. Solution platforms the project lacks fall back to the first one it
+// has and are not built.
+func projectConfigurationPlatforms(guid string, projectPlatforms []buildPlatform, deploy bool) string {
+	supported := map[string]bool{}
+	for _, platform := range projectPlatforms {
+		supported[platform.Name] = true
+	}
+
+	var lines []string
+	for _, config := range configurations {
+		for _, platform := range solutionPlatforms() {
+			key := fmt.Sprintf("\t\t%s.%s|%s", guid, config.Name, platform.SolutionName)
+			if !supported[platform.Name] {
+				lines = append(lines, fmt.Sprintf("%s.ActiveCfg = %s|%s", key, config.Name, projectPlatforms[0].Name))
+				continue
+			}
+
+			value := fmt.Sprintf("%s|%s", config.Name, platform.Name)
+			lines = append(lines,
+				fmt.Sprintf("%s.ActiveCfg = %s", key, value),
+				fmt.Sprintf("%s.Build.0 = %s", key, value))
+			if deploy {
+				lines = append(lines, fmt.Sprintf("%s.Deploy.0 = %s", key, value))
+			}
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// addSolutionMarks adds the generated .sln configuration sections to marks.
+func addSolutionMarks(marks map[string]string) {
+	marks[MARK_SOLUTION_CONFIGURATION_PLATFORMS] = solutionConfigurationPlatforms()
+	marks[MARK_SYS_SOLUTION_CONFIGURATIONS] = projectConfigurationPlatforms(marks[MARK_GUID_SYS], driverPlatforms(), true)
+	marks[MARK_EXE_SOLUTION_CONFIGURATIONS] = projectConfigurationPlatforms(marks[MARK_GUID_EXE], platforms, false)
+	marks[MARK_LIB_SOLUTION_CONFIGURATIONS] = projectConfigurationPlatforms(marks[MARK_GUID_LIB], driverPlatforms(), false)
+}
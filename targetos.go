@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const DEFAULT_TARGET_OS = `win10`
+
+// targetOS is a Windows release the driver can be built for. Level orders
+// the releases; WdfMinor is the newest KMDF 1.x and UMDF 2.x minor version
+// that ships in-box with it.
+type targetOS struct {
+	Name          string
+	Description   string
+	Level         int
+	TargetVersion string
+	NTDDIVersion  string
+	WdfMinor      int
+}
+
+// Every target still uses TargetVersion Windows10 in the WDK, the release is
+// pinned by NTDDI_VERSION.
+var targetOSes = map[string]targetOS{
+	"win10":      {Name: "win10", Description: "Windows 10 1507", Level: 0, TargetVersion: "Windows10", NTDDIVersion: "0x0A000000", WdfMinor: 15},
+	"win10-1809": {Name: "win10-1809", Description: "Windows 10 1809", Level: 6, TargetVersion: "Windows10", NTDDIVersion: "0x0A000006", WdfMinor: 27},
+	"win10-2004": {Name: "win10-2004", Description: "Windows 10 2004", Level: 8, TargetVersion: "Windows10", NTDDIVersion: "0x0A000008", WdfMinor: 31},
+	"server2022": {Name: "server2022", Description: "Windows Server 2022", Level: 10, TargetVersion: "Windows10", NTDDIVersion: "0x0A00000A", WdfMinor: 31},
+	"win11":      {Name: "win11", Description: "Windows 11 21H2", Level: 11, TargetVersion: "Windows10", NTDDIVersion: "0x0A00000B", WdfMinor: 31},
+}
+
+var (
+	driverTarget = targetOSes[DEFAULT_TARGET_OS]
+	kmdfMinor    = driverTarget.WdfMinor
+	umdfMinor    = driverTarget.WdfMinor
+
+	wdfVersionRegex = regexp.MustCompile(`^([0-9]+)\.([0-9]+)$`)
+)
+
+func targetOSNames() string {
+	names := make([]string, 0, len(targetOSes))
+	for name := range targetOSes {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return targetOSes[names[i]].Level < targetOSes[names[j]].Level })
+	return strings.Join(names, "|")
+}
+
+func findTargetOS(name string) (targetOS, error) {
+	target, ok := targetOSes[name]
+	if !ok {
+		return target, fmt.Errorf("unknown target OS %q, use one of %s", name, targetOSNames())
+	}
+	return target, nil
+}
+
+// targetOSDefines pins the kernel headers to the targeted release.
+func targetOSDefines(target targetOS) string {
+	return "_NT_TARGET_VERSION=0xA00;NTDDI_VERSION=" + target.NTDDIVersion + ";%(PreprocessorDefinitions)"
+}
+
+// checkTargetOS fails when a selected feature value needs a newer release
+// than target.
+func checkTargetOS(pack *templatePack, features map[string]string, target targetOS) error {
+	for _, feature := range pack.Features {
+		value := features[feature.Name]
+		minName, ok := feature.MinOS[value]
+		if !ok {
+			continue
+		}
+
+		minimum := targetOSes[minName]
+		if minimum.Level > target.Level {
+			return fmt.Errorf("feature %s=%s needs %s or newer, but the target OS is %s", feature.Name, value, minimum.Name, target.Name)
+		}
+	}
+	return nil
+}
+
+// resolveWdfVersion returns the minor version of requested, or the one that
+// ships with target when it is empty. A version newer than target ships is an
+// error, the driver would not load there.
+func resolveWdfVersion(framework string, major int, requested string, target targetOS) (int, error) {
+	if requested == "" {
+		return target.WdfMinor, nil
+	}
+
+	match := wdfVersionRegex.FindStringSubmatch(requested)
+	if match == nil || match[1] != strconv.Itoa(major) {
+		return 0, fmt.Errorf("invalid %s version %q, use %d.<minor>", framework, requested, major)
+	}
+
+	minor, _ := strconv.Atoi(match[2])
+	if minor > target.WdfMinor {
+		return 0, fmt.Errorf("%s %s is newer than the %d.%d that ships with %s", framework, requested, major, target.WdfMinor, target.Name)
+	}
+
+	return minor, nil
+}
+
+// withWdfVersion adds the framework version properties to KMDF and UMDF
+// drivers.
+func withWdfVersion(settings projectSettings) projectSettings {
+	for _, property := range settings.configuration {
+		if property.name != "DriverType" {
+			continue
+		}
+
+		switch property.value {
+		case "KMDF":
+			settings.configuration = append(settings.configuration,
+				msbuildProperty{"KMDF_VERSION_MAJOR", "1"},
+				msbuildProperty{"KMDF_VERSION_MINOR", strconv.Itoa(kmdfMinor)})
+		case "UMDF":
+			settings.configuration = append(settings.configuration,
+				msbuildProperty{"UMDF_VERSION_MAJOR", "2"},
+				msbuildProperty{"UMDF_VERSION_MINOR", strconv.Itoa(umdfMinor)})
+		}
+		break
+	}
+	return settings
+}
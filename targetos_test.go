@@ -0,0 +1,93 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTargetOSNames(t *testing.T) {
+	if names := targetOSNames(); names != "win10|win10-1809|win10-2004|server2022|win11" {
+		t.Errorf("targetOSNames() = %q, want the releases oldest first", names)
+	}
+
+	if _, err := findTargetOS("win12"); err == nil || !strings.Contains(err.Error(), `unknown target OS "win12"`) {
+		t.Errorf("findTargetOS(win12): error %v", err)
+	}
+
+	target, err := findTargetOS("win10-2004")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if defines := targetOSDefines(target); defines != "_NT_TARGET_VERSION=0xA00;NTDDI_VERSION=0x0A000008;%(PreprocessorDefinitions)" {
+		t.Errorf("targetOSDefines(win10-2004) = %q", defines)
+	}
+}
+
+func TestCheckTargetOS(t *testing.T) {
+	pack := builtinPacks[DEFAULT_PACK_NAME]
+
+	tests := []struct {
+		target  string
+		alloc   string
+		wantErr string
+	}{
+		{"win10", "pool", ""},
+		{"win10", "pool2", "alloc=pool2 needs win10-2004 or newer, but the target OS is win10"},
+		{"win10-1809", "pool2", "alloc=pool2 needs win10-2004 or newer, but the target OS is win10-1809"},
+		{"win10-2004", "pool2", ""},
+		{"win11", "pool2", ""},
+	}
+
+	for _, test := range tests {
+		target, err := findTargetOS(test.target)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = checkTargetOS(pack, map[string]string{"alloc": test.alloc}, target)
+		if test.wantErr == "" {
+			if err != nil {
+				t.Errorf("alloc=%s on %s: %v", test.alloc, test.target, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("alloc=%s on %s: error %v, want one mentioning %q", test.alloc, test.target, err, test.wantErr)
+		}
+	}
+}
+
+func TestResolveWdfVersion(t *testing.T) {
+	win10 := targetOSes["win10"]
+	win11 := targetOSes["win11"]
+
+	tests := []struct {
+		framework string
+		major     int
+		requested string
+		target    targetOS
+		want      int
+		wantErr   string
+	}{
+		{"KMDF", 1, "", win10, 15, ""},
+		{"KMDF", 1, "", win11, 31, ""},
+		{"KMDF", 1, "1.9", win10, 9, ""},
+		{"UMDF", 2, "2.31", win11, 31, ""},
+		{"KMDF", 1, "1.27", win10, 0, "KMDF 1.27 is newer than the 1.15 that ships with win10"},
+		{"UMDF", 2, "1.15", win10, 0, `invalid UMDF version "1.15", use 2.<minor>`},
+		{"KMDF", 1, "1", win10, 0, `invalid KMDF version "1"`},
+	}
+
+	for _, test := range tests {
+		got, err := resolveWdfVersion(test.framework, test.major, test.requested, test.target)
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("resolveWdfVersion(%s %q, %s): error %v, want one mentioning %q", test.framework, test.requested, test.target.Name, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("resolveWdfVersion(%s %q, %s) = %d, %v, want %d", test.framework, test.requested, test.target.Name, got, err, test.want)
+		}
+	}
+}
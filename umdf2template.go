@@ -0,0 +1,324 @@
+package main
+
+const UMDF2_PACK_NAME = `umdf2`
+
+var umdf2Model = driverModel{
+	Name:        UMDF2_PACK_NAME,
+	Description: "UMDF 2 user-mode driver with a default I/O queue and a client opening its device interface",
+	UserMode:    true,
+	RequireInf:  true,
+	Drop:        []string{"PoolTag", "alloc"},
+	Settings: []packSettings{
+		{Project: SETTINGS_PROJECT_DRIVER, configOverrides: configOverrides{
+			Properties: map[string]string{
+				"ConfigurationType": "DynamicLibrary",
+				"DriverType":        "UMDF",
+				"PlatformToolset":   "WindowsUserModeDriver10.0",
+				"DebuggerFlavor":    "DbgengRemoteDebugger",
+			},
+		}},
+		{Project: SETTINGS_PROJECT_APP, configOverrides: configOverrides{
+			Link: map[string]string{"AdditionalDependencies": "cfgmgr32.lib;%(AdditionalDependencies)"},
+		}},
+	},
+	Header: UMDF2_DRIVER_HEADER_TEMPLATE,
+	Source: UMDF2_DRIVER_SOURCE_TEMPLATE,
+	Inf:    UMDF2_DRIVER_INF_TEMPLATE,
+	Client: UMDF2_EXE_CPP_TEMPLATE,
+	Common: UMDF2_COMMON_HEADER_TEMPLATE,
+}
+
+const UMDF2_DRIVER_HEADER_TEMPLATE =
+`#pragma once
+
+#include <windows.h>
+#include <strsafe.h>
+#include <wdf.h>
+#include <initguid.h>
+#include "..\Common\Common.h"
+
+#ifndef _FN_
+#define _FN_	__FUNCTION__
+#endif
+
+#ifndef _LN_
+#define _LN_	__LINE__
+#endif
+
+#define DebugPrint(...)	\
+	do { CHAR _message[256]; StringCchPrintfA(_message, ARRAYSIZE(_message), __VA_ARGS__); OutputDebugStringA(_message); } while (FALSE)
+
+
+EXTERN_C DRIVER_INITIALIZE DriverEntry;
+EVT_WDF_DRIVER_DEVICE_ADD EvtDeviceAdd;
+EVT_WDF_IO_QUEUE_IO_DEVICE_CONTROL EvtIoDeviceControl;
+
+NTSTATUS
+CreateQueue(
+	IN	WDFDEVICE			device
+);
+`
+
+const UMDF2_DRIVER_SOURCE_TEMPLATE =
+`#include "$PROJECTNAME_SYS$.h"
+
+
+EXTERN_C
+NTSTATUS
+DriverEntry(
+	IN	PDRIVER_OBJECT		pDriverObject,
+	IN	PUNICODE_STRING		pRegistryPath
+)
+{
+	NTSTATUS status = STATUS_UNSUCCESSFUL;
+	WDF_DRIVER_CONFIG config;
+
+	DebugPrint("[%s:%d]\n", _FN_, _LN_);
+
+	WDF_DRIVER_CONFIG_INIT(&config, EvtDeviceAdd);
+
+	status = WdfDriverCreate(pDriverObject, pRegistryPath, WDF_NO_OBJECT_ATTRIBUTES, &config, WDF_NO_HANDLE);
+	if (!NT_SUCCESS(status))
+	{
+		DebugPrint("[%s:%d] WdfDriverCreate failed. status : 0x%X\n", _FN_, _LN_, status);
+	}
+
+	return status;
+}
+
+
+NTSTATUS
+EvtDeviceAdd(
+	IN	WDFDRIVER			driver,
+	IN	PWDFDEVICE_INIT		pDeviceInit
+)
+{
+	NTSTATUS status = STATUS_UNSUCCESSFUL;
+	WDFDEVICE device = NULL;
+
+	UNREFERENCED_PARAMETER(driver);
+
+	DebugPrint("[%s:%d]\n", _FN_, _LN_);
+
+	status = WdfDeviceCreate(&pDeviceInit, WDF_NO_OBJECT_ATTRIBUTES, &device);
+	if (!NT_SUCCESS(status))
+	{
+		return status;
+	}
+
+	status = WdfDeviceCreateDeviceInterface(device, &GUID_DEVINTERFACE_{{ upperSnake .ProjectName }}, NULL);
+	if (!NT_SUCCESS(status))
+	{
+		return status;
+	}
+
+	return CreateQueue(device);
+}
+
+
+NTSTATUS
+CreateQueue(
+	IN	WDFDEVICE			device
+)
+{
+	WDF_IO_QUEUE_CONFIG queueConfig;
+	WDFQUEUE queue = NULL;
+
+	WDF_IO_QUEUE_CONFIG_INIT_DEFAULT_QUEUE(&queueConfig, WdfIoQueueDispatchSequential);
+	queueConfig.EvtIoDeviceControl = EvtIoDeviceControl;
+
+	return WdfIoQueueCreate(device, &queueConfig, WDF_NO_OBJECT_ATTRIBUTES, &queue);
+}
+
+
+void
+EvtIoDeviceControl(
+	IN	WDFQUEUE			queue,
+	IN	WDFREQUEST			request,
+	IN	size_t				outputBufferLength,
+	IN	size_t				inputBufferLength,
+	IN	ULONG				ioControlCode
+)
+{
+	NTSTATUS status = STATUS_UNSUCCESSFUL;
+	ULONG_PTR information = 0;
+	P{{ upperSnake .ProjectName }}_DATA_1 pData = NULL;
+
+	UNREFERENCED_PARAMETER(queue);
+	UNREFERENCED_PARAMETER(outputBufferLength);
+
+	switch (ioControlCode)
+	{
+	case IOCTL_{{ upperSnake .ProjectName }}_1:
+	{
+		DebugPrint("[%s:%d] IOCTL_{{ upperSnake .ProjectName }}_1. inSize : 0x%IX\n", _FN_, _LN_, inputBufferLength);
+		if (inputBufferLength != sizeof({{ upperSnake .ProjectName }}_DATA_1))
+		{
+			status = STATUS_INVALID_PARAMETER;
+			break;
+		}
+
+		status = WdfRequestRetrieveInputBuffer(request, sizeof({{ upperSnake .ProjectName }}_DATA_1), (PVOID*)&pData, NULL);
+		if (!NT_SUCCESS(status))
+		{
+			break;
+		}
+
+		status = STATUS_SUCCESS;
+		break;
+	}
+	default:
+		status = STATUS_INVALID_DEVICE_REQUEST;
+		information = 0;
+		break;
+	}
+
+	WdfRequestCompleteWithInformation(request, status, information);
+}
+`
+
+const UMDF2_DRIVER_INF_TEMPLATE =
+`;
+; $PROJECTNAME_SYS$.inf
+;
+; Installs the UMDF 2 driver $TARGETNAME_SYS$.dll for a root-enumerated device.
+;   devcon install $PROJECTNAME_SYS$.inf Root\$PROJECTNAME_SYS$
+;
+
+[Version]
+Signature   = "$WINDOWS NT$"
+Class       = System
+ClassGuid   = {4d36e97d-e325-11ce-bfc1-08002be10318}
+Provider    = %ManufacturerName%
+DriverVer   =
+CatalogFile = $PROJECTNAME_SYS$.cat
+PnpLockdown = 1
+
+[DestinationDirs]
+UMDriverCopy = 12,UMDF
+
+[SourceDisksNames]
+1 = %DiskName%,,,""
+
+[SourceDisksFiles]
+$TARGETNAME_SYS$.dll = 1,,
+
+[Manufacturer]
+%ManufacturerName% = Standard,NT$ARCH$
+
+[Standard.NT$ARCH$]
+%DeviceDesc% = Device_Install, Root\$PROJECTNAME_SYS$
+
+[Device_Install.NT]
+CopyFiles = UMDriverCopy
+
+[UMDriverCopy]
+$TARGETNAME_SYS$.dll
+
+[Device_Install.NT.Services]
+AddService = WUDFRd,0x000001fa,WUDFRD_ServiceInstall
+
+[WUDFRD_ServiceInstall]
+DisplayName    = %WudfRdDisplayName%
+ServiceType    = 1               ; SERVICE_KERNEL_DRIVER
+StartType      = 3               ; SERVICE_DEMAND_START
+ErrorControl   = 1               ; SERVICE_ERROR_NORMAL
+ServiceBinary  = %12%\WUDFRd.sys
+
+[Device_Install.NT.Wdf]
+UmdfService      = $PROJECTNAME_SYS$, UMDFDriver_Install
+UmdfServiceOrder = $PROJECTNAME_SYS$
+
+[UMDFDriver_Install]
+UmdfLibraryVersion = $UMDFVERSION$
+ServiceBinary      = %12%\UMDF\$TARGETNAME_SYS$.dll
+
+[Strings]
+ManufacturerName  = "{{ or .Vars.Company .ProjectName }}"
+DiskName          = "$PROJECTNAME_SYS$ Installation Disk"
+DeviceDesc        = "$PROJECTNAME_SYS$ Device"
+WudfRdDisplayName = "Windows Driver Foundation - User-mode Driver Framework Reflector"
+`
+
+const UMDF2_COMMON_HEADER_TEMPLATE = COMMON_HEADER_TEMPLATE + `
+// Device interface of the driver, defined where <initguid.h> comes first.
+DEFINE_GUID(GUID_DEVINTERFACE_{{ upperSnake .ProjectName }},
+	{{ guidStruct "$GUID_INTERFACE$" }});
+`
+
+const UMDF2_EXE_CPP_TEMPLATE =
+`#include <iostream>
+#include <string>
+#include <vector>
+#include <Windows.h>
+#include <cfgmgr32.h>
+#include <initguid.h>
+#include "../Common/Common.h"
+
+// Returns the path of the first present device with the driver's interface.
+std::wstring GetDeviceInterfacePath()
+{
+	std::vector<wchar_t> list;
+	CONFIGRET cr = CR_SUCCESS;
+
+	do
+	{
+		ULONG length = 0;
+		cr = CM_Get_Device_Interface_List_Size(&length, (LPGUID)&GUID_DEVINTERFACE_{{ upperSnake .ProjectName }}, nullptr, CM_GET_DEVICE_INTERFACE_LIST_PRESENT);
+		if (cr != CR_SUCCESS || length <= 1)
+		{
+			return std::wstring();
+		}
+
+		list.resize(length);
+		cr = CM_Get_Device_Interface_List((LPGUID)&GUID_DEVINTERFACE_{{ upperSnake .ProjectName }}, nullptr, list.data(), length, CM_GET_DEVICE_INTERFACE_LIST_PRESENT);
+
+	} while (cr == CR_BUFFER_SMALL);
+
+	if (cr != CR_SUCCESS)
+	{
+		return std::wstring();
+	}
+
+	return std::wstring(list.data());
+}
+
+int main()
+{
+	HANDLE deviceHandle = INVALID_HANDLE_VALUE;
+
+	do
+	{
+		std::wstring devicePath = GetDeviceInterfacePath();
+		if (devicePath.empty())
+		{
+			std::wcerr << L"$PROJECTNAME_SYS$ device not found" << std::endl;
+			break;
+		}
+
+		deviceHandle = CreateFile(devicePath.c_str(), GENERIC_READ | GENERIC_WRITE, 0, nullptr, OPEN_EXISTING, FILE_ATTRIBUTE_NORMAL, nullptr);
+		if (deviceHandle == INVALID_HANDLE_VALUE)
+		{
+			break;
+		}
+
+		DWORD retSize = 0;
+		{{ upperSnake .ProjectName }}_DATA_1 ioctlData = { 0 };
+		ioctlData.totalSize = sizeof(ioctlData);
+
+		if (!DeviceIoControl(deviceHandle, IOCTL_{{ upperSnake .ProjectName }}_1, (LPVOID)&ioctlData, sizeof(ioctlData), nullptr, 0, &retSize, nullptr))
+		{
+			break;
+		}
+
+	} while (false);
+
+	if (deviceHandle != INVALID_HANDLE_VALUE)
+	{
+		CloseHandle(deviceHandle);
+		deviceHandle = INVALID_HANDLE_VALUE;
+	}
+
+	return 0;
+}
+`
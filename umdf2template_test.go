@@ -0,0 +1,27 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUmdf2Lint(t *testing.T) {
+	pack := builtinPacks[UMDF2_PACK_NAME]
+	vars := map[string]string{"DeviceType": "FILE_DEVICE_NETWORK", "Company": "Contoso"}
+
+	for _, problem := range lintPack(pack, vars) {
+		t.Error(problem)
+	}
+
+	files := renderTestPack(t, pack, vars, map[string]string{"lang": "c"})
+	if !strings.Contains(files[LINT_SAMPLE_NAME+"/"+LINT_SAMPLE_NAME+".inf"], `ManufacturerName  = "Contoso"`) {
+		t.Errorf("the INF does not name Company as the manufacturer")
+	}
+	if !strings.Contains(files[LINT_SAMPLE_NAME+"/"+LINT_SAMPLE_NAME+".vcxproj"], "<ConfigurationType>DynamicLibrary</ConfigurationType>") {
+		t.Errorf("the driver project does not build a DLL")
+	}
+
+	if _, err := resolveFeatures(pack, nil, map[string]string{"cppruntime": "true"}); err == nil {
+		t.Errorf("cppruntime=true is accepted for a user-mode driver")
+	}
+}
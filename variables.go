@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	VAR_TYPE_STRING = `string`
+	VAR_TYPE_INT    = `int`
+	VAR_TYPE_BOOL   = `bool`
+)
+
+// varFlags collects repeated -var Name=Value arguments.
+type varFlags map[string]string
+
+func (v varFlags) String() string {
+	pairs := make([]string, 0, len(v))
+	for name, value := range v {
+		pairs = append(pairs, name+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (v varFlags) Set(arg string) error {
+	pos := strings.Index(arg, "=")
+	if pos <= 0 {
+		return fmt.Errorf("expected Name=Value, got %q", arg)
+	}
+	v[arg[:pos]] = arg[pos+1:]
+	return nil
+}
+
+// loadVariableFile reads a flat Name: Value YAML mapping.
+func loadVariableFile(filePath string) (map[string]string, error) {
+	contents, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	vars := map[string]string{}
+	if err := yaml.Unmarshal(contents, &vars); err != nil {
+		return nil, fmt.Errorf("%s: %v", filePath, err)
+	}
+
+	return vars, nil
+}
+
+// checkPackVariables makes sure the declarations themselves are usable, so a
+// broken pack is reported as such rather than as a bad user value.
+func checkPackVariables(pack *templatePack) error {
+	seen := map[string]bool{}
+	for _, decl := range pack.Variables {
+		if decl.Name == "" {
+			return fmt.Errorf("pack %s: variable without a name", pack.Name)
+		}
+		if seen[decl.Name] {
+			return fmt.Errorf("pack %s: variable %s declared twice", pack.Name, decl.Name)
+		}
+		seen[decl.Name] = true
+
+		switch decl.Type {
+		case "", VAR_TYPE_STRING, VAR_TYPE_INT, VAR_TYPE_BOOL:
+		default:
+			return fmt.Errorf("pack %s: variable %s has unknown type %q", pack.Name, decl.Name, decl.Type)
+		}
+
+		if decl.Regex != "" {
+			if _, err := regexp.Compile(decl.Regex); err != nil {
+				return fmt.Errorf("pack %s: variable %s: %v", pack.Name, decl.Name, err)
+			}
+		}
+
+		if decl.Min != 0 || decl.Max != 0 {
+			if decl.Type != VAR_TYPE_INT {
+				return fmt.Errorf("pack %s: variable %s has bounds but is not an int", pack.Name, decl.Name)
+			}
+			if decl.Max < decl.Min {
+				return fmt.Errorf("pack %s: variable %s has max %d below min %d", pack.Name, decl.Name, decl.Max, decl.Min)
+			}
+		}
+	}
+
+	return nil
+}
+
+// resolveVariables merges the variable file and -var values (the latter win),
+// applies defaults and validates everything against the pack declarations.
+func resolveVariables(pack *templatePack, fileVars, cliVars map[string]string) (map[string]interface{}, error) {
+	values := map[string]string{}
+	for name, value := range fileVars {
+		values[name] = value
+	}
+	for name, value := range cliVars {
+		values[name] = value
+	}
+
+	declared := map[string]bool{}
+	for _, decl := range pack.Variables {
+		declared[decl.Name] = true
+	}
+
+	for name := range values {
+		if !declared[name] {
+			return nil, fmt.Errorf("pack %s does not declare variable %s", pack.Name, name)
+		}
+	}
+
+	result := map[string]interface{}{}
+	for _, decl := range pack.Variables {
+		value, ok := values[decl.Name]
+		if !ok {
+			if decl.Required {
+				return nil, fmt.Errorf("variable %s is required", decl.Name)
+			}
+			value = decl.Default
+			if value == "" {
+				result[decl.Name] = zeroVariable(decl.Type)
+				continue
+			}
+		}
+
+		if decl.Regex != "" && !regexp.MustCompile(decl.Regex).MatchString(value) {
+			return nil, fmt.Errorf("variable %s: %q does not match %s", decl.Name, value, decl.Regex)
+		}
+
+		switch decl.Type {
+		case VAR_TYPE_INT:
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("variable %s: %q is not an int", decl.Name, value)
+			}
+			if decl.Max != 0 && (n < decl.Min || n > decl.Max) {
+				return nil, fmt.Errorf("variable %s: %d is not between %d and %d", decl.Name, n, decl.Min, decl.Max)
+			}
+			result[decl.Name] = n
+		case VAR_TYPE_BOOL:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("variable %s: %q is not a bool", decl.Name, value)
+			}
+			result[decl.Name] = b
+		default:
+			result[decl.Name] = value
+		}
+	}
+
+	return result, nil
+}
+
+func zeroVariable(varType string) interface{} {
+	switch varType {
+	case VAR_TYPE_INT:
+		return 0
+	case VAR_TYPE_BOOL:
+		return false
+	}
+	return ""
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var variableTestPack = &templatePack{
+	Name: "test",
+	Variables: []packVariable{
+		{Name: "Name", Type: VAR_TYPE_STRING, Default: "Sample", Regex: `^[A-Z][a-z]+$`},
+		{Name: "Vendor", Required: true},
+		{Name: "Count", Type: VAR_TYPE_INT, Default: "8", Min: 1, Max: 64},
+		{Name: "Size", Type: VAR_TYPE_INT},
+		{Name: "Verbose", Type: VAR_TYPE_BOOL},
+	},
+}
+
+func TestResolveVariables(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    map[string]string
+		cli     map[string]string
+		want    map[string]interface{}
+		wantErr string
+	}{
+		{
+			name: "defaults and zero values",
+			cli:  map[string]string{"Vendor": "Contoso"},
+			want: map[string]interface{}{"Name": "Sample", "Vendor": "Contoso", "Count": 8, "Size": 0, "Verbose": false},
+		},
+		{
+			name: "cli wins over file",
+			file: map[string]string{"Vendor": "Fabrikam", "Name": "Filter", "Count": "2"},
+			cli:  map[string]string{"Vendor": "Contoso", "Verbose": "true"},
+			want: map[string]interface{}{"Name": "Filter", "Vendor": "Contoso", "Count": 2, "Size": 0, "Verbose": true},
+		},
+		{
+			name:    "required",
+			file:    map[string]string{"Name": "Filter"},
+			wantErr: "variable Vendor is required",
+		},
+		{
+			name:    "undeclared",
+			cli:     map[string]string{"Vendor": "Contoso", "Altitude": "370030"},
+			wantErr: "does not declare variable Altitude",
+		},
+		{
+			name:    "regex",
+			cli:     map[string]string{"Vendor": "Contoso", "Name": "filter"},
+			wantErr: `"filter" does not match`,
+		},
+		{
+			name:    "not an int",
+			cli:     map[string]string{"Vendor": "Contoso", "Size": "12k"},
+			wantErr: `"12k" is not an int`,
+		},
+		{
+			name:    "not a bool",
+			cli:     map[string]string{"Vendor": "Contoso", "Verbose": "maybe"},
+			wantErr: `"maybe" is not a bool`,
+		},
+		{
+			name:    "above max",
+			cli:     map[string]string{"Vendor": "Contoso", "Count": "65"},
+			wantErr: "65 is not between 1 and 64",
+		},
+		{
+			name:    "below min",
+			cli:     map[string]string{"Vendor": "Contoso", "Count": "0"},
+			wantErr: "0 is not between 1 and 64",
+		},
+		{
+			name: "unbounded int",
+			cli:  map[string]string{"Vendor": "Contoso", "Size": "-100000"},
+			want: map[string]interface{}{"Name": "Sample", "Vendor": "Contoso", "Count": 8, "Size": -100000, "Verbose": false},
+		},
+	}
+
+	for _, test := range tests {
+		got, err := resolveVariables(variableTestPack, test.file, test.cli)
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("%s: error %v, want one mentioning %q", test.name, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCheckPackVariables(t *testing.T) {
+	tests := []struct {
+		name     string
+		variable packVariable
+		want     string
+	}{
+		{"no name", packVariable{Type: VAR_TYPE_STRING}, "variable without a name"},
+		{"unknown type", packVariable{Name: "Count", Type: "float"}, `unknown type "float"`},
+		{"bad regex", packVariable{Name: "Name", Regex: `^[a-z`}, "variable Name"},
+		{"bounded string", packVariable{Name: "Name", Max: 4}, "has bounds but is not an int"},
+		{"max below min", packVariable{Name: "Count", Type: VAR_TYPE_INT, Min: 8, Max: 4}, "max 4 below min 8"},
+	}
+
+	for _, test := range tests {
+		pack := &templatePack{Name: "test", Variables: []packVariable{test.variable}}
+		if err := checkPackVariables(pack); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: error %v, want one mentioning %q", test.name, err, test.want)
+		}
+	}
+
+	twice := &templatePack{Name: "test", Variables: []packVariable{{Name: "Count"}, {Name: "Count"}}}
+	if err := checkPackVariables(twice); err == nil || !strings.Contains(err.Error(), "declared twice") {
+		t.Errorf("duplicate declaration: error %v, want one mentioning %q", err, "declared twice")
+	}
+
+	if err := checkPackVariables(variableTestPack); err != nil {
+		t.Errorf("checkPackVariables(variableTestPack): %v", err)
+	}
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	MARK_SYS_PROJECT_CONFIGURATIONS = `$SYS_PROJECT_CONFIGURATIONS$`
+	MARK_SYS_CONFIGURATION_GROUPS   = `$SYS_CONFIGURATION_GROUPS$`
+	MARK_SYS_PROPERTY_GROUPS        = `$SYS_PROPERTY_GROUPS$`
+	MARK_SYS_ITEM_DEFINITION_GROUPS = `$SYS_ITEM_DEFINITION_GROUPS$`
+	MARK_EXE_PROJECT_CONFIGURATIONS = `$EXE_PROJECT_CONFIGURATIONS$`
+	MARK_EXE_CONFIGURATION_GROUPS   = `$EXE_CONFIGURATION_GROUPS$`
+	MARK_EXE_PROPERTY_GROUPS        = `$EXE_PROPERTY_GROUPS$`
+	MARK_EXE_ITEM_DEFINITION_GROUPS = `$EXE_ITEM_DEFINITION_GROUPS$`
+	MARK_LIB_PROJECT_CONFIGURATIONS = `$LIB_PROJECT_CONFIGURATIONS$`
+	MARK_LIB_CONFIGURATION_GROUPS   = `$LIB_CONFIGURATION_GROUPS$`
+	MARK_LIB_PROPERTY_GROUPS        = `$LIB_PROPERTY_GROUPS$`
+	MARK_LIB_ITEM_DEFINITION_GROUPS = `$LIB_ITEM_DEFINITION_GROUPS$`
+	MARK_SYS_DEFAULT_PLATFORM       = `$SYS_DEFAULT_PLATFORM$`
+
+	MARK_SYS_PROJECT_PROPERTY_GROUPS        = `$SYS_PROJECT_PROPERTY_GROUPS$`
+	MARK_SYS_PROJECT_ITEM_DEFINITION_GROUPS = `$SYS_PROJECT_ITEM_DEFINITION_GROUPS$`
+	MARK_EXE_PROJECT_PROPERTY_GROUPS        = `$EXE_PROJECT_PROPERTY_GROUPS$`
+	MARK_EXE_PROJECT_ITEM_DEFINITION_GROUPS = `$EXE_PROJECT_ITEM_DEFINITION_GROUPS$`
+	MARK_LIB_PROJECT_PROPERTY_GROUPS        = `$LIB_PROJECT_PROPERTY_GROUPS$`
+	MARK_LIB_PROJECT_ITEM_DEFINITION_GROUPS = `$LIB_PROJECT_ITEM_DEFINITION_GROUPS$`
+	MARK_COMMON_PROPERTY_GROUPS             = `$COMMON_PROPERTY_GROUPS$`
+	MARK_COMMON_ITEM_DEFINITION_GROUPS      = `$COMMON_ITEM_DEFINITION_GROUPS$`
+	VCXPROJ_INDENT                          = `  `
+)
+
+// buildConfiguration is Debug, Release or a custom configuration inheriting
+// from one of them; Driver and App hold its per-project overrides.
+type buildConfiguration struct {
+	Name   string
+	Debug  bool
+	Driver configOverrides
+	App    configOverrides
+}
+
+// buildPlatform is an MSBuild platform. SolutionName is what the .sln calls
+// it; Driver is false for platforms only user-mode projects can target.
+type buildPlatform struct {
+	Name         string
+	SolutionName string
+	Driver       bool
+}
+
+var knownPlatforms = map[string]buildPlatform{
+	"win32":   {Name: "Win32", SolutionName: "x86", Driver: true},
+	"x86":     {Name: "Win32", SolutionName: "x86", Driver: true},
+	"x64":     {Name: "x64", SolutionName: "x64", Driver: true},
+	"arm64":   {Name: "ARM64", SolutionName: "ARM64", Driver: true},
+	"arm64ec": {Name: "ARM64EC", SolutionName: "ARM64EC", Driver: false},
+}
+
+const DEFAULT_PLATFORMS = `win32,x64`
+
+var (
+	configurations, _ = buildConfigurations(nil)
+	platforms         = mustParsePlatforms(DEFAULT_PLATFORMS)
+
+	// Settings the template pack asks for, see applyPackSettings.
+	driverPackOverrides  configOverrides
+	appPackOverrides     configOverrides
+	libraryPackOverrides configOverrides
+)
+
+// parsePlatforms parses a comma-separated -platforms list such as "x64,arm64".
+func parsePlatforms(list string) ([]buildPlatform, error) {
+	var result []buildPlatform
+	seen := map[string]bool{}
+	driverPlatforms := 0
+
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		platform, ok := knownPlatforms[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown platform %q", name)
+		}
+		if seen[platform.Name] {
+			continue
+		}
+		seen[platform.Name] = true
+
+		if platform.Driver {
+			driverPlatforms++
+		}
+		result = append(result, platform)
+	}
+
+	if driverPlatforms == 0 {
+		return nil, fmt.Errorf("%q has no platform a driver can be built for", list)
+	}
+
+	return result, nil
+}
+
+func mustParsePlatforms(list string) []buildPlatform {
+	result, err := parsePlatforms(list)
+	if err != nil {
+		panic(err)
+	}
+	return result
+}
+
+func driverPlatforms() []buildPlatform {
+	var result []buildPlatform
+	for _, platform := range platforms {
+		if platform.Driver {
+			result = append(result, platform)
+		}
+	}
+	return result
+}
+
+type msbuildProperty struct {
+	name  string
+	value string
+}
+
+// projectSettings holds everything a project sets for one configuration and
+// platform. Each list becomes one conditional group in the vcxproj.
+type projectSettings struct {
+	configuration []msbuildProperty
+	properties    []msbuildProperty
+	clCompile     []msbuildProperty
+	link          []msbuildProperty
+}
+
+type settingsFunc func(config buildConfiguration, platform string) projectSettings
+
+func debugOr(config buildConfiguration, debugValue, releaseValue string) string {
+	if config.Debug {
+		return debugValue
+	}
+	return releaseValue
+}
+
+// commonSettings are shared by every project of the solution. They end up in
+// Directory.Build.props, or inlined into each project when there is none.
+func commonSettings(config buildConfiguration, platform string) projectSettings {
+	settings := profileSettings(solutionProfile, config)
+	settings.properties = outputSettings()
+	return settings
+}
+
+func driverSettings(config buildConfiguration, platform string) projectSettings {
+	settings := projectSettings{
+		configuration: []msbuildProperty{
+			{"TargetVersion", driverTarget.TargetVersion},
+			{"UseDebugLibraries", debugOr(config, "true", "false")},
+			{"PlatformToolset", "WindowsKernelModeDriver10.0"},
+			{"ConfigurationType", "Driver"},
+			{"DriverType", "WDM"},
+			{"SpectreMitigation", "false"},
+		},
+		properties: []msbuildProperty{
+			{"DebuggerFlavor", "DbgengKernelDebugger"},
+		},
+		clCompile: []msbuildProperty{
+			{"PreprocessorDefinitions", targetOSDefines(driverTarget)},
+		},
+	}
+	settings.properties = targetNameProperty(settings.properties, output.DriverTargetName)
+
+	settings = applyOverrides(settings, driverPackOverrides)
+	settings = withWdfVersion(settings)
+	settings = withProjectProfile(settings, driverProfile, config, platform)
+	return applyOverrides(settings, config.Driver)
+}
+
+// librarySettings are those of the kernel library the driver links against. It
+// is a static library unless the pack settings turn it into an export driver,
+// and takes the driver's profile and configuration overrides.
+func librarySettings(config buildConfiguration, platform string) projectSettings {
+	settings := projectSettings{
+		configuration: []msbuildProperty{
+			{"TargetVersion", driverTarget.TargetVersion},
+			{"UseDebugLibraries", debugOr(config, "true", "false")},
+			{"PlatformToolset", "WindowsKernelModeDriver10.0"},
+			{"ConfigurationType", "StaticLibrary"},
+			{"DriverType", "WDM"},
+			{"SpectreMitigation", "false"},
+		},
+		clCompile: []msbuildProperty{
+			{"PreprocessorDefinitions", targetOSDefines(driverTarget)},
+		},
+	}
+
+	settings = applyOverrides(settings, libraryPackOverrides)
+	settings = withProjectProfile(settings, driverProfile, config, platform)
+	return applyOverrides(settings, config.Driver)
+}
+
+func appSettings(config buildConfiguration, platform string) projectSettings {
+	settings := projectSettings{
+		configuration: []msbuildProperty{
+			{"ConfigurationType", "Application"},
+			{"UseDebugLibraries", debugOr(config, "true", "false")},
+			{"PlatformToolset", "v142"},
+		},
+		properties: []msbuildProperty{
+			{"LinkIncremental", debugOr(config, "true", "false")},
+		},
+	}
+	settings.properties = targetNameProperty(settings.properties, output.AppTargetName)
+
+	if !config.Debug {
+		settings.configuration = append(settings.configuration, msbuildProperty{"WholeProgramOptimization", "true"})
+	}
+	settings.configuration = append(settings.configuration, msbuildProperty{"CharacterSet", "Unicode"})
+
+	defines := debugOr(config, "_DEBUG", "NDEBUG") + ";_CONSOLE;%(PreprocessorDefinitions)"
+	if platform == "Win32" {
+		defines = "WIN32;" + defines
+	}
+
+	settings.clCompile = []msbuildProperty{
+		{"PrecompiledHeader", "NotUsing"},
+		{"Optimization", debugOr(config, "Disabled", "MaxSpeed")},
+	}
+	if !config.Debug {
+		settings.clCompile = append(settings.clCompile,
+			msbuildProperty{"FunctionLevelLinking", "true"},
+			msbuildProperty{"IntrinsicFunctions", "true"})
+	}
+	settings.clCompile = append(settings.clCompile,
+		msbuildProperty{"SDLCheck", "true"},
+		msbuildProperty{"PreprocessorDefinitions", defines},
+		msbuildProperty{"ConformanceMode", "true"},
+		msbuildProperty{"RuntimeLibrary", debugOr(config, "MultiThreadedDebug", "MultiThreaded")})
+
+	settings.link = []msbuildProperty{
+		{"SubSystem", "Console"},
+	}
+	if !config.Debug {
+		settings.link = append(settings.link,
+			msbuildProperty{"EnableCOMDATFolding", "true"},
+			msbuildProperty{"OptimizeReferences", "true"})
+	}
+	settings.link = append(settings.link, msbuildProperty{"GenerateDebugInformation", "true"})
+
+	settings = applyOverrides(settings, appPackOverrides)
+	settings = withProjectProfile(settings, appProfile, config, platform)
+	return applyOverrides(settings, config.App)
+}
+
+// mergeProperties lays over on top of base: values of the same name are
+// replaced where base has them, new ones are appended in order. As in MSBuild,
+// a %(Name) reference in the new value stands for the old one.
+func mergeProperties(base, over []msbuildProperty) []msbuildProperty {
+	result := append([]msbuildProperty(nil), base...)
+	for _, property := range over {
+		found := false
+		for i := range result {
+			if result[i].name == property.name {
+				result[i].value = strings.Replace(property.value, "%("+property.name+")", result[i].value, 1)
+				found = true
+				break
+			}
+		}
+		if !found {
+			result = append(result, property)
+		}
+	}
+	return result
+}
+
+// withCommon inlines the common settings into a project's own settings.
+func withCommon(settings settingsFunc) settingsFunc {
+	return func(config buildConfiguration, platform string) projectSettings {
+		common := commonSettings(config, platform)
+		own := settings(config, platform)
+		return projectSettings{
+			configuration: mergeProperties(common.configuration, own.configuration),
+			properties:    mergeProperties(common.properties, own.properties),
+			clCompile:     mergeProperties(common.clCompile, own.clCompile),
+			link:          mergeProperties(common.link, own.link),
+		}
+	}
+}
+
+func configCondition(config buildConfiguration, platform string) string {
+	return fmt.Sprintf(`'$(Configuration)|$(Platform)'=='%s|%s'`, config.Name, platform)
+}
+
+func writeProperties(lines []string, depth int, properties []msbuildProperty) []string {
+	indent := strings.Repeat(VCXPROJ_INDENT, depth)
+	for _, property := range properties {
+		lines = append(lines, fmt.Sprintf("%s<%s>%s</%s>", indent, property.name, property.value, property.name))
+	}
+	return lines
+}
+
+// vcxprojBlocks are the per-configuration parts of a vcxproj or .props file.
+type vcxprojBlocks struct {
+	projectConfigurations string
+	configurationGroups   string
+	propertyGroups        string
+	itemDefinitionGroups  string
+}
+
+// buildVcxprojBlocks builds the blocks for every configuration x platform pair.
+func buildVcxprojBlocks(settings settingsFunc, platforms []buildPlatform) vcxprojBlocks {
+	var projectConfigurations, configurationGroups, propertyGroups, itemDefinitionGroups []string
+
+	for _, platform := range platforms {
+		for _, config := range configurations {
+			projectConfigurations = append(projectConfigurations,
+				fmt.Sprintf(`    <ProjectConfiguration Include="%s|%s">`, config.Name, platform.Name),
+				fmt.Sprintf(`      <Configuration>%s</Configuration>`, config.Name),
+				fmt.Sprintf(`      <Platform>%s</Platform>`, platform.Name),
+				`    </ProjectConfiguration>`)
+		}
+	}
+
+	for _, platform := range platforms {
+		for _, config := range configurations {
+			s := settings(config, platform.Name)
+			condition := configCondition(config, platform.Name)
+
+			if len(s.configuration) > 0 {
+				configurationGroups = append(configurationGroups, fmt.Sprintf(`  <PropertyGroup Condition="%s" Label="Configuration">`, condition))
+				configurationGroups = writeProperties(configurationGroups, 2, s.configuration)
+				configurationGroups = append(configurationGroups, `  </PropertyGroup>`)
+			}
+
+			if len(s.properties) > 0 {
+				propertyGroups = append(propertyGroups, fmt.Sprintf(`  <PropertyGroup Condition="%s">`, condition))
+				propertyGroups = writeProperties(propertyGroups, 2, s.properties)
+				propertyGroups = append(propertyGroups, `  </PropertyGroup>`)
+			}
+
+			if len(s.clCompile) == 0 && len(s.link) == 0 {
+				continue
+			}
+			itemDefinitionGroups = append(itemDefinitionGroups, fmt.Sprintf(`  <ItemDefinitionGroup Condition="%s">`, condition))
+			if len(s.clCompile) > 0 {
+				itemDefinitionGroups = append(itemDefinitionGroups, `    <ClCompile>`)
+				itemDefinitionGroups = writeProperties(itemDefinitionGroups, 3, s.clCompile)
+				itemDefinitionGroups = append(itemDefinitionGroups, `    </ClCompile>`)
+			}
+			if len(s.link) > 0 {
+				itemDefinitionGroups = append(itemDefinitionGroups, `    <Link>`)
+				itemDefinitionGroups = writeProperties(itemDefinitionGroups, 3, s.link)
+				itemDefinitionGroups = append(itemDefinitionGroups, `    </Link>`)
+			}
+			itemDefinitionGroups = append(itemDefinitionGroups, `  </ItemDefinitionGroup>`)
+		}
+	}
+
+	return vcxprojBlocks{
+		projectConfigurations: strings.Join(projectConfigurations, "\n"),
+		configurationGroups:   strings.Join(configurationGroups, "\n"),
+		propertyGroups:        strings.Join(propertyGroups, "\n"),
+		itemDefinitionGroups:  strings.Join(itemDefinitionGroups, "\n"),
+	}
+}
+
+// addVcxprojMarks adds the generated vcxproj and .props blocks to marks. The
+// plain SYS/EXE groups have the common settings inlined, the PROJECT ones
+// leave them to Directory.Build.props.
+func addVcxprojMarks(marks map[string]string) {
+	sys := buildVcxprojBlocks(withCommon(driverSettings), driverPlatforms())
+	marks[MARK_SYS_PROJECT_CONFIGURATIONS] = sys.projectConfigurations
+	marks[MARK_SYS_CONFIGURATION_GROUPS] = sys.configurationGroups
+	marks[MARK_SYS_PROPERTY_GROUPS] = sys.propertyGroups
+	marks[MARK_SYS_ITEM_DEFINITION_GROUPS] = sys.itemDefinitionGroups
+	marks[MARK_SYS_DEFAULT_PLATFORM] = driverPlatforms()[0].Name
+
+	sysProject := buildVcxprojBlocks(driverSettings, driverPlatforms())
+	marks[MARK_SYS_PROJECT_PROPERTY_GROUPS] = sysProject.propertyGroups
+	marks[MARK_SYS_PROJECT_ITEM_DEFINITION_GROUPS] = sysProject.itemDefinitionGroups
+
+	lib := buildVcxprojBlocks(withCommon(librarySettings), driverPlatforms())
+	marks[MARK_LIB_PROJECT_CONFIGURATIONS] = lib.projectConfigurations
+	marks[MARK_LIB_CONFIGURATION_GROUPS] = lib.configurationGroups
+	marks[MARK_LIB_PROPERTY_GROUPS] = lib.propertyGroups
+	marks[MARK_LIB_ITEM_DEFINITION_GROUPS] = lib.itemDefinitionGroups
+
+	libProject := buildVcxprojBlocks(librarySettings, driverPlatforms())
+	marks[MARK_LIB_PROJECT_PROPERTY_GROUPS] = libProject.propertyGroups
+	marks[MARK_LIB_PROJECT_ITEM_DEFINITION_GROUPS] = libProject.itemDefinitionGroups
+
+	exe := buildVcxprojBlocks(withCommon(appSettings), platforms)
+	marks[MARK_EXE_PROJECT_CONFIGURATIONS] = exe.projectConfigurations
+	marks[MARK_EXE_CONFIGURATION_GROUPS] = exe.configurationGroups
+	marks[MARK_EXE_PROPERTY_GROUPS] = exe.propertyGroups
+	marks[MARK_EXE_ITEM_DEFINITION_GROUPS] = exe.itemDefinitionGroups
+
+	exeProject := buildVcxprojBlocks(appSettings, platforms)
+	marks[MARK_EXE_PROJECT_PROPERTY_GROUPS] = exeProject.propertyGroups
+	marks[MARK_EXE_PROJECT_ITEM_DEFINITION_GROUPS] = exeProject.itemDefinitionGroups
+
+	common := buildVcxprojBlocks(commonSettings, platforms)
+	marks[MARK_COMMON_PROPERTY_GROUPS] = common.propertyGroups
+	marks[MARK_COMMON_ITEM_DEFINITION_GROUPS] = common.itemDefinitionGroups
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePlatforms(t *testing.T) {
+	tests := []struct {
+		list    string
+		want    []string
+		wantErr string
+	}{
+		{list: "x64", want: []string{"x64"}},
+		{list: "win32,x64", want: []string{"Win32", "x64"}},
+		{list: " X64 , ARM64 ", want: []string{"x64", "ARM64"}},
+		{list: "x86,win32,x64,x64", want: []string{"Win32", "x64"}},
+		{list: "x64,,arm64ec", want: []string{"x64", "ARM64EC"}},
+		{list: "arm64ec", wantErr: "no platform a driver can be built for"},
+		{list: "", wantErr: "no platform a driver can be built for"},
+		{list: "x64,mips", wantErr: `unknown platform "mips"`},
+	}
+
+	for _, test := range tests {
+		result, err := parsePlatforms(test.list)
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("parsePlatforms(%q) error %v, want one mentioning %q", test.list, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parsePlatforms(%q): %v", test.list, err)
+			continue
+		}
+
+		var got []string
+		for _, platform := range result {
+			got = append(got, platform.Name)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parsePlatforms(%q) = %q, want %q", test.list, got, test.want)
+		}
+	}
+}
+
+func TestBuildVcxprojBlocks(t *testing.T) {
+	defer func(saved []buildConfiguration) { configurations = saved }(configurations)
+	configurations = []buildConfiguration{{Name: "Debug", Debug: true}, {Name: "Release"}}
+
+	settings := func(config buildConfiguration, platform string) projectSettings {
+		s := projectSettings{
+			configuration: []msbuildProperty{{"UseDebugLibraries", debugOr(config, "true", "false")}},
+			clCompile:     []msbuildProperty{{"Optimization", debugOr(config, "Disabled", "MaxSpeed")}},
+		}
+		if platform == "x64" {
+			s.link = []msbuildProperty{{"SubSystem", "Native"}}
+		}
+		return s
+	}
+
+	blocks := buildVcxprojBlocks(settings, mustParsePlatforms("win32,x64"))
+
+	var order []string
+	for _, line := range strings.Split(blocks.projectConfigurations, "\n") {
+		if strings.Contains(line, "<ProjectConfiguration ") {
+			order = append(order, strings.TrimSpace(line))
+		}
+	}
+	want := []string{
+		`<ProjectConfiguration Include="Debug|Win32">`,
+		`<ProjectConfiguration Include="Release|Win32">`,
+		`<ProjectConfiguration Include="Debug|x64">`,
+		`<ProjectConfiguration Include="Release|x64">`,
+	}
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("project configurations %q, want %q", order, want)
+	}
+
+	for _, text := range []string{
+		`<PropertyGroup Condition="'$(Configuration)|$(Platform)'=='Release|Win32'" Label="Configuration">` + "\n    <UseDebugLibraries>false</UseDebugLibraries>",
+		`<ItemDefinitionGroup Condition="'$(Configuration)|$(Platform)'=='Debug|x64'">` + "\n    <ClCompile>\n      <Optimization>Disabled</Optimization>\n    </ClCompile>\n    <Link>\n      <SubSystem>Native</SubSystem>\n    </Link>",
+	} {
+		if !strings.Contains(blocks.configurationGroups+"\n"+blocks.itemDefinitionGroups, text) {
+			t.Errorf("blocks lack\n%s", text)
+		}
+	}
+
+	if count := strings.Count(blocks.configurationGroups, `Label="Configuration"`); count != 4 {
+		t.Errorf("%d configuration groups, want one per configuration and platform", count)
+	}
+	if strings.Count(blocks.itemDefinitionGroups, "<Link>") != 2 {
+		t.Errorf("Link settings outside the x64 configurations:\n%s", blocks.itemDefinitionGroups)
+	}
+	if blocks.propertyGroups != "" {
+		t.Errorf("property groups without properties:\n%s", blocks.propertyGroups)
+	}
+}
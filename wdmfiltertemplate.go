@@ -0,0 +1,355 @@
+package main
+
+import "strings"
+
+const WDM_FILTER_PACK_NAME = `wdm-filter`
+
+// wdmFilterMajorFunctions are the IRP_MJ_* names Completions may list.
+// IRP_MJ_PNP is left out, the filter handles it to detach on removal.
+var wdmFilterMajorFunctions = []string{
+	"CREATE", "CREATE_NAMED_PIPE", "CLOSE", "READ", "WRITE",
+	"QUERY_INFORMATION", "SET_INFORMATION", "QUERY_EA", "SET_EA",
+	"FLUSH_BUFFERS", "QUERY_VOLUME_INFORMATION", "SET_VOLUME_INFORMATION",
+	"DIRECTORY_CONTROL", "FILE_SYSTEM_CONTROL", "DEVICE_CONTROL",
+	"INTERNAL_DEVICE_CONTROL", "SHUTDOWN", "LOCK_CONTROL", "CLEANUP",
+	"CREATE_MAILSLOT", "QUERY_SECURITY", "SET_SECURITY", "POWER",
+	"SYSTEM_CONTROL", "DEVICE_CHANGE", "QUERY_QUOTA", "SET_QUOTA",
+}
+
+var wdmFilterMajorFunctionsRegex = `^((` + strings.Join(wdmFilterMajorFunctions, "|") + `)(,|$))*$`
+
+var wdmFilterModel = driverModel{
+	Name:        WDM_FILTER_PACK_NAME,
+	Description: "legacy filter attached on top of a named device, passing every IRP through",
+	NoClient:    true,
+	NoAlloc:     true,
+	Drop:        []string{"DeviceType"},
+	Variables: []packVariable{
+		{Name: "AttachTo", Type: VAR_TYPE_STRING, Default: `\Device\KeyboardClass0`, Regex: `^\\Device\\[^\s\\]+(\\[^\s\\]+)*$`, Description: "device object the filter attaches on top of, also set by -attach-to"},
+		{Name: "Completions", Type: VAR_TYPE_STRING, Default: "READ", Regex: wdmFilterMajorFunctionsRegex, Description: "comma-separated IRP_MJ_* names without the prefix that get a completion routine, the others are skipped"},
+	},
+	Header: WDM_FILTER_DRIVER_HEADER_TEMPLATE,
+	Source: WDM_FILTER_DRIVER_SOURCE_TEMPLATE,
+	Inf:    DRIVER_INF_TEMPLATE,
+	Common: WDM_FILTER_COMMON_HEADER_TEMPLATE,
+}
+
+const WDM_FILTER_DRIVER_HEADER_TEMPLATE =
+`#pragma once
+
+#ifndef NTSTRSAFE_LIB
+#define NTSTRSAFE_LIB
+#endif
+
+{{ if eq .Features.lang "cpp" -}}
+extern "C"
+{
+{{ end -}}
+#include <ntddk.h>
+#include <wdm.h>
+#include <ntstrsafe.h>
+#include "..\Common\Common.h"
+{{- if eq .Features.lang "cpp" }}
+}
+{{- end }}
+{{- if eq .Features.cppruntime "true" }}
+
+#include "KernelCpp.h"
+{{- end }}
+
+#ifndef _FN_
+#define _FN_	__FUNCTION__
+#endif
+
+#ifndef _LN_
+#define _LN_	__LINE__
+#endif
+
+#define TARGET_DEVICE_NAME	L"{{ cString .Vars.AttachTo }}"
+
+typedef struct _DEVICE_EXTENSION
+{
+	PDEVICE_OBJECT		pSelf;
+	PDEVICE_OBJECT		pLowerDevice;
+
+} DEVICE_EXTENSION, *PDEVICE_EXTENSION;
+
+
+EXTERN_C DRIVER_INITIALIZE DriverEntry;
+{{- if .Vars.Completions }}
+IO_COMPLETION_ROUTINE CompletionRoutine;
+{{- end }}
+
+void
+UnloadRoutine(
+	IN	PDRIVER_OBJECT		pDriverObject
+);
+
+NTSTATUS
+AttachToTarget(
+	IN	PDRIVER_OBJECT		pDriverObject
+);
+
+void
+DetachFromTarget(
+	IN	PDEVICE_OBJECT		pDeviceObject
+);
+
+NTSTATUS
+PassRoutine(
+	IN	PDEVICE_OBJECT		pDeviceObject,
+	IN	PIRP				pIrp
+);
+{{- if .Vars.Completions }}
+
+NTSTATUS
+CompletionPassRoutine(
+	IN	PDEVICE_OBJECT		pDeviceObject,
+	IN	PIRP				pIrp
+);
+{{- end }}
+
+NTSTATUS
+PnpRoutine(
+	IN	PDEVICE_OBJECT		pDeviceObject,
+	IN	PIRP				pIrp
+);
+`
+
+const WDM_FILTER_DRIVER_SOURCE_TEMPLATE =
+`#include "$PROJECTNAME_SYS$.h"
+
+
+EXTERN_C
+NTSTATUS
+DriverEntry(
+	IN	PDRIVER_OBJECT		pDriverObject,
+	IN	PUNICODE_STRING		pRegistryPath
+)
+{
+	ULONG i = 0;
+
+	UNREFERENCED_PARAMETER(pRegistryPath);
+
+	KdPrint(("[%s:%d]\n", _FN_, _LN_));
+
+	for (i = 0; i <= IRP_MJ_MAXIMUM_FUNCTION; ++i)
+	{
+		pDriverObject->MajorFunction[i] = PassRoutine;
+	}
+{{ range list .Vars.Completions }}
+	pDriverObject->MajorFunction[IRP_MJ_{{ . }}] = CompletionPassRoutine;
+{{- end }}
+	pDriverObject->MajorFunction[IRP_MJ_PNP] = PnpRoutine;
+	pDriverObject->DriverUnload = UnloadRoutine;
+
+	return AttachToTarget(pDriverObject);
+}
+
+
+void
+UnloadRoutine(
+	IN	PDRIVER_OBJECT		pDriverObject
+)
+{
+	KdPrint(("[%s:%d]\n", _FN_, _LN_));
+
+	// Devices removed with their target are already off the list.
+	while (pDriverObject->DeviceObject != NULL)
+	{
+		DetachFromTarget(pDriverObject->DeviceObject);
+	}
+
+	return;
+}
+
+
+NTSTATUS
+AttachToTarget(
+	IN	PDRIVER_OBJECT		pDriverObject
+)
+{
+	NTSTATUS status = STATUS_UNSUCCESSFUL;
+
+	UNICODE_STRING targetName = { 0 };
+	PFILE_OBJECT pTargetFile = NULL;
+	PDEVICE_OBJECT pTargetDevice = NULL;
+	PDEVICE_OBJECT pDeviceObject = NULL;
+	PDEVICE_EXTENSION pExtension = NULL;
+
+	RtlInitUnicodeString(&targetName, TARGET_DEVICE_NAME);
+
+	do
+	{
+		// Returns the top of the target's stack, which is what gets attached to.
+		status = IoGetDeviceObjectPointer(&targetName, FILE_READ_ATTRIBUTES, &pTargetFile, &pTargetDevice);
+		if (!NT_SUCCESS(status))
+		{
+			KdPrint(("[%s:%d] %wZ not found. status : 0x%X\n", _FN_, _LN_, &targetName, status));
+			break;
+		}
+
+		// A filter looks like the device below it to the drivers above.
+		status = IoCreateDevice(
+			pDriverObject,
+			sizeof(DEVICE_EXTENSION),
+			NULL,
+			pTargetDevice->DeviceType,
+			pTargetDevice->Characteristics,
+			FALSE,
+			&pDeviceObject
+		);
+		if (!NT_SUCCESS(status))
+		{
+			break;
+		}
+
+		pExtension = (PDEVICE_EXTENSION)pDeviceObject->DeviceExtension;
+		RtlZeroMemory(pExtension, sizeof(DEVICE_EXTENSION));
+		pExtension->pSelf = pDeviceObject;
+
+		status = IoAttachDeviceToDeviceStackSafe(pDeviceObject, pTargetDevice, &pExtension->pLowerDevice);
+		if (!NT_SUCCESS(status))
+		{
+			break;
+		}
+
+		pDeviceObject->Flags |= pExtension->pLowerDevice->Flags & (DO_BUFFERED_IO | DO_DIRECT_IO | DO_POWER_PAGABLE);
+		pDeviceObject->Characteristics |= pExtension->pLowerDevice->Characteristics;
+		pDeviceObject->Flags &= ~DO_DEVICE_INITIALIZING;
+
+		KdPrint(("[%s:%d] attached to %wZ\n", _FN_, _LN_, &targetName));
+
+		status = STATUS_SUCCESS;
+
+	} while (FALSE);
+
+	if (!NT_SUCCESS(status) && pDeviceObject != NULL)
+	{
+		IoDeleteDevice(pDeviceObject);
+		pDeviceObject = NULL;
+	}
+
+	// The attachment holds its own reference on the target.
+	if (pTargetFile != NULL)
+	{
+		ObDereferenceObject(pTargetFile);
+		pTargetFile = NULL;
+	}
+
+	return status;
+}
+
+
+void
+DetachFromTarget(
+	IN	PDEVICE_OBJECT		pDeviceObject
+)
+{
+	PDEVICE_EXTENSION pExtension = (PDEVICE_EXTENSION)pDeviceObject->DeviceExtension;
+
+	if (pExtension->pLowerDevice != NULL)
+	{
+		IoDetachDevice(pExtension->pLowerDevice);
+		pExtension->pLowerDevice = NULL;
+	}
+
+	IoDeleteDevice(pDeviceObject);
+
+	return;
+}
+
+
+NTSTATUS
+PassRoutine(
+	IN	PDEVICE_OBJECT		pDeviceObject,
+	IN	PIRP				pIrp
+)
+{
+	PDEVICE_EXTENSION pExtension = (PDEVICE_EXTENSION)pDeviceObject->DeviceExtension;
+
+	IoSkipCurrentIrpStackLocation(pIrp);
+
+	return IoCallDriver(pExtension->pLowerDevice, pIrp);
+}
+{{- if .Vars.Completions }}
+
+
+NTSTATUS
+CompletionPassRoutine(
+	IN	PDEVICE_OBJECT		pDeviceObject,
+	IN	PIRP				pIrp
+)
+{
+	NTSTATUS status = STATUS_UNSUCCESSFUL;
+	PDEVICE_EXTENSION pExtension = (PDEVICE_EXTENSION)pDeviceObject->DeviceExtension;
+
+	IoCopyCurrentIrpStackLocationToNext(pIrp);
+
+	// The Ex variant keeps the driver loaded until the routine has run, IRPs
+	// such as keyboard reads may still be pending when it unloads.
+	status = IoSetCompletionRoutineEx(pDeviceObject, pIrp, CompletionRoutine, NULL, TRUE, TRUE, TRUE);
+	if (!NT_SUCCESS(status))
+	{
+		KdPrint(("[%s:%d] IoSetCompletionRoutineEx failed. status : 0x%X\n", _FN_, _LN_, status));
+	}
+
+	return IoCallDriver(pExtension->pLowerDevice, pIrp);
+}
+
+
+NTSTATUS
+CompletionRoutine(
+	IN	PDEVICE_OBJECT		pDeviceObject,
+	IN	PIRP				pIrp,
+	IN	PVOID				pContext
+)
+{
+	PIO_STACK_LOCATION pStack = IoGetCurrentIrpStackLocation(pIrp);
+
+	UNREFERENCED_PARAMETER(pDeviceObject);
+	UNREFERENCED_PARAMETER(pContext);
+
+	if (pIrp->PendingReturned)
+	{
+		IoMarkIrpPending(pIrp);
+	}
+
+	KdPrint(("[%s:%d] major : 0x%X status : 0x%X information : 0x%IX\n", _FN_, _LN_,
+		pStack->MajorFunction, pIrp->IoStatus.Status, pIrp->IoStatus.Information));
+
+	return STATUS_CONTINUE_COMPLETION;
+}
+{{- end }}
+
+
+NTSTATUS
+PnpRoutine(
+	IN	PDEVICE_OBJECT		pDeviceObject,
+	IN	PIRP				pIrp
+)
+{
+	NTSTATUS status = STATUS_UNSUCCESSFUL;
+	PDEVICE_EXTENSION pExtension = (PDEVICE_EXTENSION)pDeviceObject->DeviceExtension;
+	PIO_STACK_LOCATION pStack = IoGetCurrentIrpStackLocation(pIrp);
+
+	if (pStack->MinorFunction != IRP_MN_REMOVE_DEVICE)
+	{
+		return PassRoutine(pDeviceObject, pIrp);
+	}
+
+	// The target is going away, so the filter leaves the stack with it.
+	IoSkipCurrentIrpStackLocation(pIrp);
+	status = IoCallDriver(pExtension->pLowerDevice, pIrp);
+
+	DetachFromTarget(pDeviceObject);
+
+	return status;
+}
+`
+
+const WDM_FILTER_COMMON_HEADER_TEMPLATE =
+`#pragma once
+
+// The filter has no control device, so nothing is shared with user mode yet.
+`
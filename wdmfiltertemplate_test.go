@@ -0,0 +1,34 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWdmFilterLint(t *testing.T) {
+	pack := builtinPacks[WDM_FILTER_PACK_NAME]
+	vars := map[string]string{"AttachTo": `\Device\Serial0`, "Completions": "CREATE,WRITE,DEVICE_CONTROL"}
+
+	for _, problem := range lintPack(pack, vars) {
+		t.Error(problem)
+	}
+
+	files := renderTestPack(t, pack, vars, nil)
+	header := files[LINT_SAMPLE_NAME+"/"+LINT_SAMPLE_NAME+".h"]
+	if !strings.Contains(header, `L"\\Device\\Serial0"`) {
+		t.Errorf("the driver header lacks the escaped target device name:\n%s", header)
+	}
+	if strings.Contains(header, "TAG_NAME") || strings.Contains(header, "ALLOC_NONPAGED") {
+		t.Errorf("the driver allocates nothing, but its header defines the pool macros")
+	}
+
+	source := files[LINT_SAMPLE_NAME+"/"+LINT_SAMPLE_NAME+".cpp"]
+	for _, major := range []string{"CREATE", "WRITE", "DEVICE_CONTROL"} {
+		if !strings.Contains(source, "MajorFunction[IRP_MJ_"+major+"] = CompletionPassRoutine;") {
+			t.Errorf("IRP_MJ_%s does not get the completion routine", major)
+		}
+	}
+	if strings.Contains(source, "MajorFunction[IRP_MJ_READ] = CompletionPassRoutine;") {
+		t.Errorf("IRP_MJ_READ gets the completion routine, but Completions leaves it out")
+	}
+}
@@ -0,0 +1,608 @@
+package main
+
+const WDM_PNP_PACK_NAME = `wdm-pnp`
+
+var wdmPnpModel = driverModel{
+	Name:        WDM_PNP_PACK_NAME,
+	Description: "WDM function driver for a root-enumerated device, with remove locks, PnP state tracking and power passthrough",
+	RequireInf:  true,
+	NoAlloc:     true,
+	Settings: []packSettings{
+		{Project: SETTINGS_PROJECT_APP, configOverrides: configOverrides{
+			Link: map[string]string{"AdditionalDependencies": "cfgmgr32.lib;%(AdditionalDependencies)"},
+		}},
+	},
+	Header: WDM_PNP_DRIVER_HEADER_TEMPLATE,
+	Source: WDM_PNP_DRIVER_SOURCE_TEMPLATE,
+	Inf:    WDM_PNP_DRIVER_INF_TEMPLATE,
+	Client: UMDF2_EXE_CPP_TEMPLATE,
+	Common: UMDF2_COMMON_HEADER_TEMPLATE,
+}
+
+const WDM_PNP_DRIVER_HEADER_TEMPLATE =
+`#pragma once
+
+#ifndef NTSTRSAFE_LIB
+#define NTSTRSAFE_LIB
+#endif
+
+{{ if eq .Features.lang "cpp" -}}
+extern "C"
+{
+{{ end -}}
+#include <ntddk.h>
+#include <wdm.h>
+#include <ntstrsafe.h>
+#include <initguid.h>
+#include "..\Common\Common.h"
+{{- if eq .Features.lang "cpp" }}
+}
+{{- end }}
+{{- if eq .Features.cppruntime "true" }}
+
+#include "KernelCpp.h"
+{{- end }}
+
+#ifndef _FN_
+#define _FN_	__FUNCTION__
+#endif
+
+#ifndef _LN_
+#define _LN_	__LINE__
+#endif
+
+` + POOL_TAG_TEMPLATE + `
+typedef enum _DEVICE_PNP_STATE
+{
+	NotStarted,
+	Started,
+	StopPending,
+	Stopped,
+	RemovePending,
+	SurpriseRemovePending,
+	Deleted
+
+} DEVICE_PNP_STATE;
+
+typedef struct _DEVICE_EXTENSION
+{
+	PDEVICE_OBJECT		pSelf;
+	PDEVICE_OBJECT		pPhysicalDevice;
+	PDEVICE_OBJECT		pLowerDevice;
+	IO_REMOVE_LOCK		removeLock;
+	DEVICE_PNP_STATE	pnpState;
+	DEVICE_PNP_STATE	previousPnpState;
+	UNICODE_STRING		interfaceName;
+
+} DEVICE_EXTENSION, *PDEVICE_EXTENSION;
+
+// Query IRPs can be canceled, so the state before them is kept to go back to.
+#define SET_NEW_PNP_STATE(pExtension, newState)	\
+	do { (pExtension)->previousPnpState = (pExtension)->pnpState; (pExtension)->pnpState = (newState); } while (FALSE)
+
+#define RESTORE_PREVIOUS_PNP_STATE(pExtension)	\
+	do { (pExtension)->pnpState = (pExtension)->previousPnpState; } while (FALSE)
+
+
+EXTERN_C DRIVER_INITIALIZE DriverEntry;
+DRIVER_ADD_DEVICE AddDevice;
+
+void
+UnloadRoutine(
+	IN	PDRIVER_OBJECT		pDriverObject
+);
+
+NTSTATUS
+ForwardIrp(
+	IN	PDEVICE_EXTENSION	pExtension,
+	IN	PIRP				pIrp
+);
+
+NTSTATUS
+PassRoutine(
+	IN	PDEVICE_OBJECT		pDeviceObject,
+	IN	PIRP				pIrp
+);
+
+NTSTATUS
+CreateCloseRoutine(
+	IN	PDEVICE_OBJECT		pDeviceObject,
+	IN	PIRP				pIrp
+);
+
+NTSTATUS
+DeviceIoControlRoutine(
+	IN	PDEVICE_OBJECT		pDeviceObject,
+	IN	PIRP				pIrp
+);
+
+NTSTATUS
+PnpRoutine(
+	IN	PDEVICE_OBJECT		pDeviceObject,
+	IN	PIRP				pIrp
+);
+
+NTSTATUS
+PowerRoutine(
+	IN	PDEVICE_OBJECT		pDeviceObject,
+	IN	PIRP				pIrp
+);
+`
+
+const WDM_PNP_DRIVER_SOURCE_TEMPLATE =
+`#include "$PROJECTNAME_SYS$.h"
+
+
+EXTERN_C
+NTSTATUS
+DriverEntry(
+	IN	PDRIVER_OBJECT		pDriverObject,
+	IN	PUNICODE_STRING		pRegistryPath
+)
+{
+	ULONG i = 0;
+
+	UNREFERENCED_PARAMETER(pRegistryPath);
+
+	KdPrint(("[%s:%d]\n", _FN_, _LN_));
+
+	// Devices are created in AddDevice, once per device the PnP manager finds.
+	for (i = 0; i <= IRP_MJ_MAXIMUM_FUNCTION; ++i)
+	{
+		pDriverObject->MajorFunction[i] = PassRoutine;
+	}
+
+	pDriverObject->MajorFunction[IRP_MJ_CREATE] = CreateCloseRoutine;
+	pDriverObject->MajorFunction[IRP_MJ_CLEANUP] = CreateCloseRoutine;
+	pDriverObject->MajorFunction[IRP_MJ_CLOSE] = CreateCloseRoutine;
+	pDriverObject->MajorFunction[IRP_MJ_DEVICE_CONTROL] = DeviceIoControlRoutine;
+	pDriverObject->MajorFunction[IRP_MJ_PNP] = PnpRoutine;
+	pDriverObject->MajorFunction[IRP_MJ_POWER] = PowerRoutine;
+	pDriverObject->DriverExtension->AddDevice = AddDevice;
+	pDriverObject->DriverUnload = UnloadRoutine;
+
+	return STATUS_SUCCESS;
+}
+
+
+NTSTATUS
+AddDevice(
+	IN	PDRIVER_OBJECT		pDriverObject,
+	IN	PDEVICE_OBJECT		pPhysicalDeviceObject
+)
+{
+	NTSTATUS status = STATUS_UNSUCCESSFUL;
+
+	PDEVICE_OBJECT pDeviceObject = NULL;
+	PDEVICE_EXTENSION pExtension = NULL;
+
+	KdPrint(("[%s:%d]\n", _FN_, _LN_));
+
+	do
+	{
+		status = IoCreateDevice(
+			pDriverObject,
+			sizeof(DEVICE_EXTENSION),
+			NULL,
+			{{ .Vars.DeviceType }},
+			FILE_DEVICE_SECURE_OPEN,
+			FALSE,
+			&pDeviceObject
+		);
+		if (!NT_SUCCESS(status))
+		{
+			break;
+		}
+
+		pExtension = (PDEVICE_EXTENSION)pDeviceObject->DeviceExtension;
+		RtlZeroMemory(pExtension, sizeof(DEVICE_EXTENSION));
+		pExtension->pSelf = pDeviceObject;
+		pExtension->pPhysicalDevice = pPhysicalDeviceObject;
+		pExtension->pnpState = NotStarted;
+		pExtension->previousPnpState = NotStarted;
+
+		IoInitializeRemoveLock(&pExtension->removeLock, TAG_NAME, 0, 0);
+
+		// Clients find the device through its interface, enabled on start.
+		status = IoRegisterDeviceInterface(
+			pPhysicalDeviceObject,
+			&GUID_DEVINTERFACE_{{ upperSnake .ProjectName }},
+			NULL,
+			&pExtension->interfaceName
+		);
+		if (!NT_SUCCESS(status))
+		{
+			break;
+		}
+
+		pExtension->pLowerDevice = IoAttachDeviceToDeviceStack(pDeviceObject, pPhysicalDeviceObject);
+		if (pExtension->pLowerDevice == NULL)
+		{
+			status = STATUS_NO_SUCH_DEVICE;
+			break;
+		}
+
+		pDeviceObject->Flags |= DO_BUFFERED_IO;
+		pDeviceObject->Flags |= (pExtension->pLowerDevice->Flags & DO_POWER_PAGABLE);
+		pDeviceObject->Flags &= ~DO_DEVICE_INITIALIZING;
+
+		status = STATUS_SUCCESS;
+
+	} while (FALSE);
+
+	if (!NT_SUCCESS(status) && pDeviceObject != NULL)
+	{
+		if (pExtension->interfaceName.Buffer != NULL)
+		{
+			RtlFreeUnicodeString(&pExtension->interfaceName);
+		}
+
+		IoDeleteDevice(pDeviceObject);
+		pDeviceObject = NULL;
+	}
+
+	return status;
+}
+
+
+void
+UnloadRoutine(
+	IN	PDRIVER_OBJECT		pDriverObject
+)
+{
+	// Every device is gone by now, IRP_MN_REMOVE_DEVICE deleted it.
+	UNREFERENCED_PARAMETER(pDriverObject);
+
+	KdPrint(("[%s:%d]\n", _FN_, _LN_));
+
+	return;
+}
+
+
+// ForwardIrp passes pIrp down untouched and releases the remove lock the
+// caller acquired for it.
+NTSTATUS
+ForwardIrp(
+	IN	PDEVICE_EXTENSION	pExtension,
+	IN	PIRP				pIrp
+)
+{
+	NTSTATUS status = STATUS_UNSUCCESSFUL;
+
+	IoSkipCurrentIrpStackLocation(pIrp);
+	status = IoCallDriver(pExtension->pLowerDevice, pIrp);
+
+	IoReleaseRemoveLock(&pExtension->removeLock, pIrp);
+
+	return status;
+}
+
+
+NTSTATUS
+PassRoutine(
+	IN	PDEVICE_OBJECT		pDeviceObject,
+	IN	PIRP				pIrp
+)
+{
+	NTSTATUS status = STATUS_UNSUCCESSFUL;
+	PDEVICE_EXTENSION pExtension = (PDEVICE_EXTENSION)pDeviceObject->DeviceExtension;
+
+	status = IoAcquireRemoveLock(&pExtension->removeLock, pIrp);
+	if (!NT_SUCCESS(status))
+	{
+		pIrp->IoStatus.Status = status;
+		pIrp->IoStatus.Information = 0;
+		IoCompleteRequest(pIrp, IO_NO_INCREMENT);
+
+		return status;
+	}
+
+	return ForwardIrp(pExtension, pIrp);
+}
+
+
+NTSTATUS
+CreateCloseRoutine(
+	IN	PDEVICE_OBJECT		pDeviceObject,
+	IN	PIRP				pIrp
+)
+{
+	NTSTATUS status = STATUS_UNSUCCESSFUL;
+	PDEVICE_EXTENSION pExtension = (PDEVICE_EXTENSION)pDeviceObject->DeviceExtension;
+	PIO_STACK_LOCATION pStack = IoGetCurrentIrpStackLocation(pIrp);
+
+	// Handles still open at surprise removal must be allowed to close, only
+	// new ones are refused.
+	if (pStack->MajorFunction == IRP_MJ_CREATE)
+	{
+		status = IoAcquireRemoveLock(&pExtension->removeLock, pIrp);
+		if (NT_SUCCESS(status))
+		{
+			if (pExtension->pnpState != Started)
+			{
+				status = STATUS_DEVICE_NOT_READY;
+			}
+
+			IoReleaseRemoveLock(&pExtension->removeLock, pIrp);
+		}
+	}
+	else
+	{
+		status = STATUS_SUCCESS;
+	}
+
+	pIrp->IoStatus.Status = status;
+	pIrp->IoStatus.Information = 0;
+	IoCompleteRequest(pIrp, IO_NO_INCREMENT);
+
+	return status;
+}
+
+
+NTSTATUS
+DeviceIoControlRoutine(
+	IN	PDEVICE_OBJECT		pDeviceObject,
+	IN	PIRP				pIrp
+)
+{
+	NTSTATUS status = STATUS_UNSUCCESSFUL;
+	ULONG_PTR information = 0;
+
+	PDEVICE_EXTENSION pExtension = (PDEVICE_EXTENSION)pDeviceObject->DeviceExtension;
+	PIO_STACK_LOCATION pStack = IoGetCurrentIrpStackLocation(pIrp);
+	ULONG ioCtlCode = pStack->Parameters.DeviceIoControl.IoControlCode;
+	ULONG inSize = pStack->Parameters.DeviceIoControl.InputBufferLength;
+	PVOID pInBuffer = pIrp->AssociatedIrp.SystemBuffer;
+
+	status = IoAcquireRemoveLock(&pExtension->removeLock, pIrp);
+	if (!NT_SUCCESS(status))
+	{
+		pIrp->IoStatus.Status = status;
+		pIrp->IoStatus.Information = 0;
+		IoCompleteRequest(pIrp, IO_NO_INCREMENT);
+
+		return status;
+	}
+
+	do
+	{
+		if (pExtension->pnpState != Started)
+		{
+			status = STATUS_DEVICE_NOT_READY;
+			break;
+		}
+
+		switch (ioCtlCode)
+		{
+		case IOCTL_{{ upperSnake .ProjectName }}_1:
+		{
+			KdPrint(("[%s:%d] IOCTL_{{ upperSnake .ProjectName }}_1. inSize : 0x%X\n", _FN_, _LN_, inSize));
+			if (pInBuffer == NULL || inSize != sizeof({{ upperSnake .ProjectName }}_DATA_1))
+			{
+				status = STATUS_INVALID_PARAMETER;
+				break;
+			}
+
+			status = STATUS_SUCCESS;
+			break;
+		}
+		default:
+			status = STATUS_INVALID_DEVICE_REQUEST;
+			information = 0;
+			break;
+		}
+
+	} while (FALSE);
+
+	pIrp->IoStatus.Status = status;
+	pIrp->IoStatus.Information = information;
+	IoCompleteRequest(pIrp, IO_NO_INCREMENT);
+
+	IoReleaseRemoveLock(&pExtension->removeLock, pIrp);
+
+	return status;
+}
+
+
+NTSTATUS
+PnpRoutine(
+	IN	PDEVICE_OBJECT		pDeviceObject,
+	IN	PIRP				pIrp
+)
+{
+	NTSTATUS status = STATUS_UNSUCCESSFUL;
+	PDEVICE_EXTENSION pExtension = (PDEVICE_EXTENSION)pDeviceObject->DeviceExtension;
+	PIO_STACK_LOCATION pStack = IoGetCurrentIrpStackLocation(pIrp);
+
+	status = IoAcquireRemoveLock(&pExtension->removeLock, pIrp);
+	if (!NT_SUCCESS(status))
+	{
+		pIrp->IoStatus.Status = status;
+		pIrp->IoStatus.Information = 0;
+		IoCompleteRequest(pIrp, IO_NO_INCREMENT);
+
+		return status;
+	}
+
+	KdPrint(("[%s:%d] minor : 0x%X\n", _FN_, _LN_, pStack->MinorFunction));
+
+	switch (pStack->MinorFunction)
+	{
+	case IRP_MN_START_DEVICE:
+	{
+		// The lower drivers start the hardware first.
+		if (!IoForwardIrpSynchronously(pExtension->pLowerDevice, pIrp))
+		{
+			status = STATUS_UNSUCCESSFUL;
+		}
+		else
+		{
+			status = pIrp->IoStatus.Status;
+		}
+
+		if (NT_SUCCESS(status))
+		{
+			SET_NEW_PNP_STATE(pExtension, Started);
+			IoSetDeviceInterfaceState(&pExtension->interfaceName, TRUE);
+		}
+
+		pIrp->IoStatus.Status = status;
+		IoCompleteRequest(pIrp, IO_NO_INCREMENT);
+		IoReleaseRemoveLock(&pExtension->removeLock, pIrp);
+
+		return status;
+	}
+	case IRP_MN_QUERY_STOP_DEVICE:
+	{
+		SET_NEW_PNP_STATE(pExtension, StopPending);
+		pIrp->IoStatus.Status = STATUS_SUCCESS;
+		break;
+	}
+	case IRP_MN_CANCEL_STOP_DEVICE:
+	{
+		if (pExtension->pnpState == StopPending)
+		{
+			RESTORE_PREVIOUS_PNP_STATE(pExtension);
+		}
+
+		pIrp->IoStatus.Status = STATUS_SUCCESS;
+		break;
+	}
+	case IRP_MN_STOP_DEVICE:
+	{
+		SET_NEW_PNP_STATE(pExtension, Stopped);
+		pIrp->IoStatus.Status = STATUS_SUCCESS;
+		break;
+	}
+	case IRP_MN_QUERY_REMOVE_DEVICE:
+	{
+		SET_NEW_PNP_STATE(pExtension, RemovePending);
+		pIrp->IoStatus.Status = STATUS_SUCCESS;
+		break;
+	}
+	case IRP_MN_CANCEL_REMOVE_DEVICE:
+	{
+		if (pExtension->pnpState == RemovePending)
+		{
+			RESTORE_PREVIOUS_PNP_STATE(pExtension);
+		}
+
+		pIrp->IoStatus.Status = STATUS_SUCCESS;
+		break;
+	}
+	case IRP_MN_SURPRISE_REMOVAL:
+	{
+		SET_NEW_PNP_STATE(pExtension, SurpriseRemovePending);
+		IoSetDeviceInterfaceState(&pExtension->interfaceName, FALSE);
+		pIrp->IoStatus.Status = STATUS_SUCCESS;
+		break;
+	}
+	case IRP_MN_REMOVE_DEVICE:
+	{
+		if (pExtension->pnpState != SurpriseRemovePending)
+		{
+			IoSetDeviceInterfaceState(&pExtension->interfaceName, FALSE);
+		}
+
+		SET_NEW_PNP_STATE(pExtension, Deleted);
+
+		// Waits for every IRP holding the lock, including this one.
+		IoReleaseRemoveLockAndWait(&pExtension->removeLock, pIrp);
+
+		pIrp->IoStatus.Status = STATUS_SUCCESS;
+		IoSkipCurrentIrpStackLocation(pIrp);
+		status = IoCallDriver(pExtension->pLowerDevice, pIrp);
+
+		IoDetachDevice(pExtension->pLowerDevice);
+		RtlFreeUnicodeString(&pExtension->interfaceName);
+		IoDeleteDevice(pDeviceObject);
+
+		return status;
+	}
+	default:
+		break;
+	}
+
+	return ForwardIrp(pExtension, pIrp);
+}
+
+
+NTSTATUS
+PowerRoutine(
+	IN	PDEVICE_OBJECT		pDeviceObject,
+	IN	PIRP				pIrp
+)
+{
+	NTSTATUS status = STATUS_UNSUCCESSFUL;
+	PDEVICE_EXTENSION pExtension = (PDEVICE_EXTENSION)pDeviceObject->DeviceExtension;
+
+	status = IoAcquireRemoveLock(&pExtension->removeLock, pIrp);
+	if (!NT_SUCCESS(status))
+	{
+		pIrp->IoStatus.Status = status;
+		pIrp->IoStatus.Information = 0;
+		IoCompleteRequest(pIrp, IO_NO_INCREMENT);
+
+		return status;
+	}
+
+	// Since Windows Vista PoStartNextPowerIrp is a no-op and power IRPs go
+	// down with IoCallDriver like any other.
+	return ForwardIrp(pExtension, pIrp);
+}
+`
+
+const WDM_PNP_DRIVER_INF_TEMPLATE =
+`;
+; $PROJECTNAME_SYS$.inf
+;
+; Installs $TARGETNAME_SYS$.sys as the function driver of a root-enumerated device.
+;   devcon install $PROJECTNAME_SYS$.inf Root\$PROJECTNAME_SYS$
+;
+
+[Version]
+Signature   = "$WINDOWS NT$"
+Class       = System
+ClassGuid   = {4d36e97d-e325-11ce-bfc1-08002be10318}
+Provider    = %ManufacturerName%
+DriverVer   =
+CatalogFile = $PROJECTNAME_SYS$.cat
+PnpLockdown = 1
+
+[DestinationDirs]
+DefaultDestDir = 12
+
+[SourceDisksNames]
+1 = %DiskName%,,,""
+
+[SourceDisksFiles]
+$TARGETNAME_SYS$.sys = 1,,
+
+[Manufacturer]
+%ManufacturerName% = Standard,NT$ARCH$
+
+[Standard.NT$ARCH$]
+%DeviceDesc% = Device_Install, Root\$PROJECTNAME_SYS$
+
+[Device_Install.NT]
+CopyFiles = Drivers_Dir
+
+[Drivers_Dir]
+$TARGETNAME_SYS$.sys
+
+[Device_Install.NT.Services]
+AddService = $PROJECTNAME_SYS$,0x00000002,Service_Install
+
+[Service_Install]
+DisplayName    = %ServiceName%
+ServiceType    = 1               ; SERVICE_KERNEL_DRIVER
+StartType      = 3               ; SERVICE_DEMAND_START
+ErrorControl   = 1               ; SERVICE_ERROR_NORMAL
+ServiceBinary  = %12%\$TARGETNAME_SYS$.sys
+
+[Strings]
+ManufacturerName = "{{ or .Vars.Company .ProjectName }}"
+DiskName         = "$PROJECTNAME_SYS$ Installation Disk"
+DeviceDesc       = "$PROJECTNAME_SYS$ Device"
+ServiceName      = "$PROJECTNAME_SYS$ Service"
+`
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWdmPnpLint(t *testing.T) {
+	pack := builtinPacks[WDM_PNP_PACK_NAME]
+	vars := map[string]string{"DeviceType": "FILE_DEVICE_NETWORK", "PoolTag": "Pnp1", "Company": "Contoso"}
+
+	for _, problem := range lintPack(pack, vars) {
+		t.Error(problem)
+	}
+
+	files := renderTestPack(t, pack, vars, map[string]string{"lang": "c"})
+	header := files[LINT_SAMPLE_NAME+"/"+LINT_SAMPLE_NAME+".h"]
+	if count := strings.Count(header, "#define TAG_NAME"); count != 1 {
+		t.Errorf("the driver header defines TAG_NAME %d times, want once", count)
+	}
+	if strings.Contains(header, "ALLOC_NONPAGED") {
+		t.Errorf("the driver allocates nothing, but its header defines ALLOC_NONPAGED")
+	}
+	if !strings.Contains(files[LINT_SAMPLE_NAME+"/"+LINT_SAMPLE_NAME+".c"], "IoInitializeRemoveLock(&pExtension->removeLock, TAG_NAME") {
+		t.Errorf("the remove lock is not tagged with the pool tag")
+	}
+}
@@ -0,0 +1,502 @@
+package main
+
+import "strings"
+
+const WFP_CALLOUT_PACK_NAME = `wfp-callout`
+
+// wfpLayers are the layer names Layers may list, FWPS_LAYER_* and
+// FWPM_LAYER_* without the prefix.
+var wfpLayers = []string{
+	"INBOUND_IPPACKET_V4", "INBOUND_IPPACKET_V6", "OUTBOUND_IPPACKET_V4", "OUTBOUND_IPPACKET_V6",
+	"INBOUND_TRANSPORT_V4", "INBOUND_TRANSPORT_V6", "OUTBOUND_TRANSPORT_V4", "OUTBOUND_TRANSPORT_V6",
+	"STREAM_V4", "STREAM_V6", "DATAGRAM_DATA_V4", "DATAGRAM_DATA_V6",
+	"INBOUND_ICMP_ERROR_V4", "INBOUND_ICMP_ERROR_V6", "OUTBOUND_ICMP_ERROR_V4", "OUTBOUND_ICMP_ERROR_V6",
+	"ALE_RESOURCE_ASSIGNMENT_V4", "ALE_RESOURCE_ASSIGNMENT_V6",
+	"ALE_AUTH_LISTEN_V4", "ALE_AUTH_LISTEN_V6",
+	"ALE_AUTH_RECV_ACCEPT_V4", "ALE_AUTH_RECV_ACCEPT_V6",
+	"ALE_AUTH_CONNECT_V4", "ALE_AUTH_CONNECT_V6",
+	"ALE_FLOW_ESTABLISHED_V4", "ALE_FLOW_ESTABLISHED_V6",
+	"ALE_CONNECT_REDIRECT_V4", "ALE_CONNECT_REDIRECT_V6",
+	"ALE_BIND_REDIRECT_V4", "ALE_BIND_REDIRECT_V6",
+	"ALE_ENDPOINT_CLOSURE_V4", "ALE_ENDPOINT_CLOSURE_V6",
+}
+
+var wfpLayerRegex = `(` + strings.Join(wfpLayers, "|") + `)`
+
+var wfpCalloutModel = driverModel{
+	Name:        WFP_CALLOUT_PACK_NAME,
+	Description: "WFP callout driver with an installer adding the sublayer, callouts and filters",
+	NoAlloc:     true,
+	Drop:        []string{"DeviceType"},
+	Variables: []packVariable{
+		{Name: "Layers", Type: VAR_TYPE_STRING, Default: "ALE_AUTH_CONNECT_V4", Regex: `^` + wfpLayerRegex + `(,` + wfpLayerRegex + `)*$`, Description: "comma-separated WFP layers without the FWPS_LAYER_ prefix, each gets a callout and a filter"},
+	},
+	Settings: []packSettings{
+		{Project: SETTINGS_PROJECT_DRIVER, configOverrides: configOverrides{
+			Defines: []string{"NDIS_SUPPORT_NDIS6"},
+			Link:    map[string]string{"AdditionalDependencies": "fwpkclnt.lib;uuid.lib;%(AdditionalDependencies)"},
+		}},
+		{Project: SETTINGS_PROJECT_APP, configOverrides: configOverrides{
+			Link: map[string]string{"AdditionalDependencies": "fwpuclnt.lib;%(AdditionalDependencies)"},
+		}},
+	},
+	Header: WFP_CALLOUT_DRIVER_HEADER_TEMPLATE,
+	Source: WFP_CALLOUT_DRIVER_SOURCE_TEMPLATE,
+	Inf:    DRIVER_INF_TEMPLATE,
+	Client: WFP_CALLOUT_EXE_CPP_TEMPLATE,
+	Common: WFP_CALLOUT_COMMON_HEADER_TEMPLATE,
+}
+
+const WFP_CALLOUT_DRIVER_HEADER_TEMPLATE =
+`#pragma once
+
+{{ if eq .Features.lang "cpp" -}}
+extern "C"
+{
+{{ end -}}
+#include <ntddk.h>
+#pragma warning(push)
+#pragma warning(disable:4201)	// nameless struct/union
+#include <ndis.h>
+#include <fwpsk.h>
+#pragma warning(pop)
+#include <fwpmk.h>
+#include <initguid.h>
+#include "..\Common\Common.h"
+{{- if eq .Features.lang "cpp" }}
+}
+{{- end }}
+{{- if eq .Features.cppruntime "true" }}
+
+#include "KernelCpp.h"
+{{- end }}
+
+#ifndef _FN_
+#define _FN_	__FUNCTION__
+#endif
+
+#ifndef _LN_
+#define _LN_	__LINE__
+#endif
+
+EXTERN_C DRIVER_INITIALIZE DriverEntry;
+
+void
+UnloadRoutine(
+	IN	PDRIVER_OBJECT		pDriverObject
+);
+
+NTSTATUS
+RegisterCallout(
+	IN	PDEVICE_OBJECT				pDeviceObject,
+	IN	const GUID*					pCalloutKey,
+	IN	FWPS_CALLOUT_CLASSIFY_FN	classifyFn,
+	OUT	UINT32*						pCalloutId
+);
+
+NTSTATUS
+UnregisterCallouts(
+);
+{{- range list .Vars.Layers }}
+
+void
+NTAPI
+Classify{{ pascal . }}(
+	IN		const FWPS_INCOMING_VALUES*				pInFixedValues,
+	IN		const FWPS_INCOMING_METADATA_VALUES*	pInMetaValues,
+	IN OUT	void*									pLayerData,
+	IN		const void*								pClassifyContext,
+	IN		const FWPS_FILTER*						pFilter,
+	IN		UINT64									flowContext,
+	IN OUT	FWPS_CLASSIFY_OUT*						pClassifyOut
+);
+{{- end }}
+
+NTSTATUS
+NTAPI
+Notify(
+	IN		FWPS_CALLOUT_NOTIFY_TYPE	notifyType,
+	IN		const GUID*					pFilterKey,
+	IN OUT	FWPS_FILTER*				pFilter
+);
+
+void
+NTAPI
+FlowDelete(
+	IN	UINT16		layerId,
+	IN	UINT32		calloutId,
+	IN	UINT64		flowContext
+);
+`
+
+const WFP_CALLOUT_DRIVER_SOURCE_TEMPLATE =
+`#include "$PROJECTNAME_SYS$.h"
+{{- $name := upperSnake .ProjectName }}{{ $layers := list .Vars.Layers }}
+
+{{ range $layers }}
+UINT32 gCalloutId{{ pascal . }} = 0;
+{{- end }}
+
+
+EXTERN_C
+NTSTATUS
+DriverEntry(
+	IN	PDRIVER_OBJECT		pDriverObject,
+	IN	PUNICODE_STRING		pRegistryPath
+)
+{
+	NTSTATUS status = STATUS_UNSUCCESSFUL;
+	PDEVICE_OBJECT pDeviceObject = NULL;
+
+	UNREFERENCED_PARAMETER(pRegistryPath);
+
+	KdPrint(("[%s:%d]\n", _FN_, _LN_));
+
+	do
+	{
+		// Callouts are registered on behalf of a device object, it needs no name.
+		status = IoCreateDevice(pDriverObject, 0, NULL, FILE_DEVICE_UNKNOWN, FILE_DEVICE_SECURE_OPEN, FALSE, &pDeviceObject);
+		if (!NT_SUCCESS(status))
+		{
+			break;
+		}
+{{ range $layers }}
+		status = RegisterCallout(pDeviceObject, &{{ $name }}_CALLOUT_{{ . }}, Classify{{ pascal . }}, &gCalloutId{{ pascal . }});
+		if (!NT_SUCCESS(status))
+		{
+			break;
+		}
+{{ end }}
+		pDriverObject->DriverUnload = UnloadRoutine;
+
+	} while (FALSE);
+
+	if (!NT_SUCCESS(status))
+	{
+		KdPrint(("[%s:%d] failed. status : 0x%X\n", _FN_, _LN_, status));
+
+		// See UnloadRoutine for why a busy callout keeps the device.
+		if (NT_SUCCESS(UnregisterCallouts()) && pDeviceObject != NULL)
+		{
+			IoDeleteDevice(pDeviceObject);
+			pDeviceObject = NULL;
+		}
+	}
+
+	return status;
+}
+
+
+void
+UnloadRoutine(
+	IN	PDRIVER_OBJECT		pDriverObject
+)
+{
+	KdPrint(("[%s:%d]\n", _FN_, _LN_));
+
+	// A callout stays registered with STATUS_DEVICE_BUSY while classifies or
+	// flow contexts are still outstanding, and the filter engine only drops
+	// it once they are done. Its device is then kept, which keeps the driver
+	// image loaded rather than unloading it under the callout. Uninstall the
+	// filters with MyApp first so that no new classifies come in.
+	if (!NT_SUCCESS(UnregisterCallouts()))
+	{
+		KdPrint(("[%s:%d] callouts still registered, keeping the device\n", _FN_, _LN_));
+		return;
+	}
+
+	if (pDriverObject->DeviceObject != NULL)
+	{
+		IoDeleteDevice(pDriverObject->DeviceObject);
+		pDriverObject->DeviceObject = NULL;
+	}
+
+	return;
+}
+
+
+NTSTATUS
+RegisterCallout(
+	IN	PDEVICE_OBJECT				pDeviceObject,
+	IN	const GUID*					pCalloutKey,
+	IN	FWPS_CALLOUT_CLASSIFY_FN	classifyFn,
+	OUT	UINT32*						pCalloutId
+)
+{
+	FWPS_CALLOUT callout = { 0 };
+
+	callout.calloutKey = *pCalloutKey;
+	callout.classifyFn = classifyFn;
+	callout.notifyFn = Notify;
+	callout.flowDeleteFn = FlowDelete;
+
+	return FwpsCalloutRegister(pDeviceObject, &callout, pCalloutId);
+}
+
+
+NTSTATUS
+UnregisterCallouts(
+)
+{
+	NTSTATUS result = STATUS_SUCCESS;
+	NTSTATUS status = STATUS_SUCCESS;
+{{ range $layers }}
+	if (gCalloutId{{ pascal . }} != 0)
+	{
+		status = FwpsCalloutUnregisterById(gCalloutId{{ pascal . }});
+		if (NT_SUCCESS(status))
+		{
+			gCalloutId{{ pascal . }} = 0;
+		}
+		else
+		{
+			KdPrint(("[%s:%d] {{ . }} callout not unregistered. status : 0x%X\n", _FN_, _LN_, status));
+			result = status;
+		}
+	}
+{{ end }}
+	return result;
+}
+{{- range $layers }}
+
+
+void
+NTAPI
+Classify{{ pascal . }}(
+	IN		const FWPS_INCOMING_VALUES*				pInFixedValues,
+	IN		const FWPS_INCOMING_METADATA_VALUES*	pInMetaValues,
+	IN OUT	void*									pLayerData,
+	IN		const void*								pClassifyContext,
+	IN		const FWPS_FILTER*						pFilter,
+	IN		UINT64									flowContext,
+	IN OUT	FWPS_CLASSIFY_OUT*						pClassifyOut
+)
+{
+	UNREFERENCED_PARAMETER(pInFixedValues);
+	UNREFERENCED_PARAMETER(pLayerData);
+	UNREFERENCED_PARAMETER(pClassifyContext);
+	UNREFERENCED_PARAMETER(pFilter);
+	UNREFERENCED_PARAMETER(flowContext);
+
+	if (FWPS_IS_METADATA_FIELD_PRESENT(pInMetaValues, FWPS_METADATA_FIELD_PROCESS_ID))
+	{
+		KdPrint(("[%s:%d] pid : %I64u\n", _FN_, _LN_, pInMetaValues->processId));
+	}
+
+	// Leave the decision to the other filters unless this callout may make it.
+	if (pClassifyOut->rights & FWPS_RIGHT_ACTION_WRITE)
+	{
+		pClassifyOut->actionType = FWP_ACTION_CONTINUE;
+	}
+}
+{{- end }}
+
+
+NTSTATUS
+NTAPI
+Notify(
+	IN		FWPS_CALLOUT_NOTIFY_TYPE	notifyType,
+	IN		const GUID*					pFilterKey,
+	IN OUT	FWPS_FILTER*				pFilter
+)
+{
+	UNREFERENCED_PARAMETER(pFilterKey);
+
+	switch (notifyType)
+	{
+	case FWPS_CALLOUT_NOTIFY_ADD_FILTER:
+		KdPrint(("[%s:%d] filter added. id : %I64u\n", _FN_, _LN_, pFilter->filterId));
+		break;
+	case FWPS_CALLOUT_NOTIFY_DELETE_FILTER:
+		KdPrint(("[%s:%d] filter deleted. id : %I64u\n", _FN_, _LN_, pFilter->filterId));
+		break;
+	default:
+		break;
+	}
+
+	return STATUS_SUCCESS;
+}
+
+
+void
+NTAPI
+FlowDelete(
+	IN	UINT16		layerId,
+	IN	UINT32		calloutId,
+	IN	UINT64		flowContext
+)
+{
+	UNREFERENCED_PARAMETER(layerId);
+	UNREFERENCED_PARAMETER(calloutId);
+
+	// Free what FwpsFlowAssociateContext attached to the flow here.
+	UNREFERENCED_PARAMETER(flowContext);
+}
+`
+
+const WFP_CALLOUT_COMMON_HEADER_TEMPLATE =
+`#pragma once
+{{- $name := upperSnake .ProjectName }}
+
+// Keys of the WFP objects, shared by the driver and the MyApp installer.
+// Defined where <initguid.h> comes first.
+DEFINE_GUID({{ $name }}_SUBLAYER,
+	{{ guidStruct (guidFrom "$GUID_INTERFACE$/sublayer") }});
+{{- range list .Vars.Layers }}
+
+DEFINE_GUID({{ $name }}_CALLOUT_{{ . }},
+	{{ guidStruct (guidFrom (print "$GUID_INTERFACE$/callout/" .)) }});
+DEFINE_GUID({{ $name }}_FILTER_{{ . }},
+	{{ guidStruct (guidFrom (print "$GUID_INTERFACE$/filter/" .)) }});
+{{- end }}
+`
+
+const WFP_CALLOUT_EXE_CPP_TEMPLATE =
+`#include <iostream>
+#include <string>
+#include <Windows.h>
+#include <initguid.h>
+#include <fwpmu.h>
+#include "../Common/Common.h"
+{{- $name := upperSnake .ProjectName }}
+
+// MyApp.exe [install|uninstall]
+//
+// The filters call out for inspection only: until the driver registers its
+// callouts they are skipped instead of blocking traffic.
+
+struct CALLOUT_ENTRY
+{
+	const GUID* calloutKey;
+	const GUID* filterKey;
+	const GUID* layerKey;
+	const wchar_t* name;
+};
+
+const CALLOUT_ENTRY callouts[] =
+{
+{{- range list .Vars.Layers }}
+	{ &{{ $name }}_CALLOUT_{{ . }}, &{{ $name }}_FILTER_{{ . }}, &FWPM_LAYER_{{ . }}, L"$PROJECTNAME_SYS$ {{ . }}" },
+{{- end }}
+};
+
+DWORD Install(HANDLE engine)
+{
+	FWPM_SUBLAYER0 sublayer = { 0 };
+	sublayer.subLayerKey = {{ $name }}_SUBLAYER;
+	sublayer.displayData.name = const_cast<wchar_t*>(L"$PROJECTNAME_SYS$ Sublayer");
+	sublayer.flags = FWPM_SUBLAYER_FLAG_PERSISTENT;
+	sublayer.weight = 0x100;
+
+	DWORD result = FwpmSubLayerAdd0(engine, &sublayer, nullptr);
+	if (result != ERROR_SUCCESS)
+	{
+		return result;
+	}
+
+	for (const CALLOUT_ENTRY& entry : callouts)
+	{
+		FWPM_CALLOUT0 callout = { 0 };
+		callout.calloutKey = *entry.calloutKey;
+		callout.displayData.name = const_cast<wchar_t*>(entry.name);
+		callout.flags = FWPM_CALLOUT_FLAG_PERSISTENT;
+		callout.applicableLayer = *entry.layerKey;
+
+		result = FwpmCalloutAdd0(engine, &callout, nullptr, nullptr);
+		if (result != ERROR_SUCCESS)
+		{
+			return result;
+		}
+
+		FWPM_FILTER0 filter = { 0 };
+		filter.filterKey = *entry.filterKey;
+		filter.displayData.name = const_cast<wchar_t*>(entry.name);
+		filter.flags = FWPM_FILTER_FLAG_PERSISTENT;
+		filter.layerKey = *entry.layerKey;
+		filter.subLayerKey = {{ $name }}_SUBLAYER;
+		filter.weight.type = FWP_EMPTY;
+		filter.action.type = FWP_ACTION_CALLOUT_INSPECTION;
+		filter.action.calloutKey = *entry.calloutKey;
+
+		result = FwpmFilterAdd0(engine, &filter, nullptr, nullptr);
+		if (result != ERROR_SUCCESS)
+		{
+			return result;
+		}
+	}
+
+	return ERROR_SUCCESS;
+}
+
+// Uninstall removes whatever Install added, objects already gone are fine.
+DWORD Uninstall(HANDLE engine)
+{
+	DWORD result = ERROR_SUCCESS;
+
+	for (const CALLOUT_ENTRY& entry : callouts)
+	{
+		result = FwpmFilterDeleteByKey0(engine, entry.filterKey);
+		if (result != ERROR_SUCCESS && result != FWP_E_FILTER_NOT_FOUND)
+		{
+			return result;
+		}
+
+		result = FwpmCalloutDeleteByKey0(engine, entry.calloutKey);
+		if (result != ERROR_SUCCESS && result != FWP_E_CALLOUT_NOT_FOUND)
+		{
+			return result;
+		}
+	}
+
+	result = FwpmSubLayerDeleteByKey0(engine, &{{ $name }}_SUBLAYER);
+	if (result != ERROR_SUCCESS && result != FWP_E_SUBLAYER_NOT_FOUND)
+	{
+		return result;
+	}
+
+	return ERROR_SUCCESS;
+}
+
+int main(int argc, char* argv[])
+{
+	HANDLE engine = nullptr;
+	bool uninstall = argc > 1 && std::string(argv[1]) == "uninstall";
+
+	DWORD result = FwpmEngineOpen0(nullptr, RPC_C_AUTHN_WINNT, nullptr, nullptr, &engine);
+	if (result != ERROR_SUCCESS)
+	{
+		std::wcerr << L"FwpmEngineOpen0 failed. error : 0x" << std::hex << result << std::endl;
+		return 1;
+	}
+
+	do
+	{
+		// All or nothing, a failed step leaves no partial install behind.
+		result = FwpmTransactionBegin0(engine, 0);
+		if (result != ERROR_SUCCESS)
+		{
+			break;
+		}
+
+		result = uninstall ? Uninstall(engine) : Install(engine);
+		if (result != ERROR_SUCCESS)
+		{
+			FwpmTransactionAbort0(engine);
+			break;
+		}
+
+		result = FwpmTransactionCommit0(engine);
+
+	} while (false);
+
+	FwpmEngineClose0(engine);
+
+	if (result != ERROR_SUCCESS)
+	{
+		std::wcerr << (uninstall ? L"uninstall" : L"install") << L" failed. error : 0x" << std::hex << result << std::endl;
+		return 1;
+	}
+
+	return 0;
+}
+`
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWfpCalloutLint(t *testing.T) {
+	pack := builtinPacks[WFP_CALLOUT_PACK_NAME]
+	vars := map[string]string{"Layers": "ALE_AUTH_CONNECT_V4,STREAM_V6,INBOUND_TRANSPORT_V4"}
+
+	for _, problem := range lintPack(pack, vars) {
+		t.Error(problem)
+	}
+
+	files := renderTestPack(t, pack, vars, map[string]string{"lang": "c"})
+	source := files[LINT_SAMPLE_NAME+"/"+LINT_SAMPLE_NAME+".c"]
+	for _, layer := range []string{"AleAuthConnectV4", "StreamV6", "InboundTransportV4"} {
+		if count := strings.Count(source, "FwpsCalloutUnregisterById(gCalloutId"+layer+")"); count != 1 {
+			t.Errorf("%s is unregistered %d times, want once", layer, count)
+		}
+	}
+	if !strings.Contains(source, "if (!NT_SUCCESS(UnregisterCallouts()))") {
+		t.Errorf("UnloadRoutine deletes the device even when a callout is still registered")
+	}
+	if strings.Contains(files[LINT_SAMPLE_NAME+"/"+LINT_SAMPLE_NAME+".h"], "ALLOC_NONPAGED") {
+		t.Errorf("the callout driver allocates nothing, but its header defines ALLOC_NONPAGED")
+	}
+}